@@ -0,0 +1,166 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the state of a rule for a site.
+type State string
+
+var (
+	// Pending alerts match their rule, but not yet for the duration of the rule.
+	Pending State = "pending"
+	// Firing alerts match their rule for at least the duration of the rule.
+	Firing State = "firing"
+	// Resolved alerts were firing and do not match their rule anymore.
+	Resolved State = "resolved"
+)
+
+// A Site is the source of the metrics.
+type Site struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// A Sample contains the current metrics of a site. Missing metrics, like the
+// state of charge of a site without a battery, do not change the alerts.
+type Sample struct {
+	Time     time.Time
+	Daylight bool
+	Values   map[string]float64
+}
+
+// An Alert is the state of a rule for a site. It is also the payload of the
+// webhooks.
+type Alert struct {
+	Fingerprint string     `json:"fingerprint"`
+	Status      State      `json:"status"`
+	Rule        Rule       `json:"rule"`
+	Site        Site       `json:"site"`
+	Value       float64    `json:"value"`
+	ActiveAt    time.Time  `json:"activeAt"`
+	FiredAt     *time.Time `json:"firedAt,omitempty"`
+	ResolvedAt  *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// A Notifier receives the alerts which are firing or resolved after an
+// evaluation. Firing alerts are passed at every evaluation, resolved alerts
+// until no notifier failed, so a notifier must drop duplicates.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// An Engine evaluates the rules for all sites and keeps the state of the alerts.
+type Engine struct {
+	lock      sync.Mutex
+	rules     []Rule
+	notifiers []Notifier
+	alerts    map[string]*Alert
+	unsent    map[string]bool
+	onError   func(err error)
+}
+
+// NewEngine returns an engine for the rules which notifies the given notifiers.
+// Errors of the notifiers are passed to onError.
+func NewEngine(rules []Rule, onError func(err error), notifiers ...Notifier) *Engine {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	return &Engine{
+		rules:     rules,
+		notifiers: notifiers,
+		alerts:    make(map[string]*Alert),
+		unsent:    make(map[string]bool),
+		onError:   onError,
+	}
+}
+
+func fingerprint(r Rule, s Site) string {
+	return fmt.Sprintf("%s/%s", s.ID, r.Name)
+}
+
+// Evaluate applies all rules to the sample of the site and notifies about the
+// firing and resolved alerts.
+func (e *Engine) Evaluate(site Site, sample Sample) {
+	e.lock.Lock()
+	var notify []Alert
+	now := sample.Time
+	for _, r := range e.rules {
+		v, ok := sample.Values[r.Metric]
+		if !ok {
+			continue
+		}
+		fp := fingerprint(r, site)
+		a := e.alerts[fp]
+		active := r.matches(v) && (!r.Daylight || sample.Daylight)
+		switch {
+		case active && (a == nil || a.Status == Resolved):
+			a = &Alert{Fingerprint: fp, Status: Pending, Rule: r, Site: site, ActiveAt: sample.Time}
+			e.alerts[fp] = a
+			delete(e.unsent, fp)
+			fallthrough
+		case active:
+			a.Value = v
+			if a.Status == Pending && !sample.Time.Before(a.ActiveAt.Add(r.For)) {
+				a.Status = Firing
+				a.FiredAt = &now
+			}
+			if a.Status == Firing {
+				notify = append(notify, *a)
+			}
+		case a == nil:
+		case a.Status == Resolved:
+			if e.unsent[fp] {
+				notify = append(notify, *a)
+			}
+		case a.Status == Pending:
+			delete(e.alerts, fp)
+		case a.Status == Firing:
+			a.Value = v
+			a.Status = Resolved
+			a.ResolvedAt = &now
+			e.unsent[fp] = true
+			notify = append(notify, *a)
+		}
+	}
+	e.lock.Unlock()
+
+	for _, a := range notify {
+		failed := false
+		for _, n := range e.notifiers {
+			if err := n.Notify(a); err != nil {
+				failed = true
+				e.onError(err)
+			}
+		}
+		if a.Status == Resolved && !failed {
+			e.sent(a)
+		}
+	}
+}
+
+// sent marks the resolved alert as sent to all notifiers unless it was
+// activated again meanwhile.
+func (e *Engine) sent(a Alert) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if cur := e.alerts[a.Fingerprint]; cur != nil && cur.Status == Resolved {
+		delete(e.unsent, a.Fingerprint)
+	}
+}
+
+// Alerts returns the pending, firing and resolved alerts sorted by their
+// fingerprint.
+func (e *Engine) Alerts() []Alert {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	res := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Fingerprint < res[j].Fingerprint })
+	return res
+}
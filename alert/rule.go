@@ -0,0 +1,143 @@
+// Package alert evaluates threshold rules against the values of solaredge
+// sites. A rule which is true for a given duration fires an alert; the alerts
+// are sent as JSON to webhooks when they fire, while they keep firing and when
+// they are resolved.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The metrics of a site which can be used in rules. Powers are in W, the ages
+// of the data are in seconds and critical is 1 if the battery is critical.
+const (
+	MetricPV       = "pv"
+	MetricGrid     = "grid"
+	MetricLoad     = "load"
+	MetricBattery  = "battery"
+	MetricSoC      = "soc"
+	MetricCritical = "critical"
+	MetricDataAge  = "data_age"
+	MetricFlowAge  = "flow_age"
+)
+
+var metrics = map[string]bool{
+	MetricPV: true, MetricGrid: true, MetricLoad: true, MetricBattery: true,
+	MetricSoC: true, MetricCritical: true, MetricDataAge: true, MetricFlowAge: true,
+}
+
+// A Rule compares a metric with a threshold.
+type Rule struct {
+	Name        string        `yaml:"name" json:"name"`
+	Description string        `yaml:"description,omitempty" json:"description,omitempty"`
+	Severity    string        `yaml:"severity,omitempty" json:"severity,omitempty"`
+	Metric      string        `yaml:"metric" json:"metric"`
+	Op          string        `yaml:"op" json:"op"`
+	Value       float64       `yaml:"value" json:"value"`
+	For         time.Duration `yaml:"for,omitempty" json:"for,omitempty"`
+	// Daylight rules are only true during daylight.
+	Daylight bool `yaml:"daylight,omitempty" json:"daylight,omitempty"`
+}
+
+// MarshalJSON writes the duration of the rule in a readable form.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	type plain Rule
+	res := struct {
+		plain
+		For string `json:"for,omitempty"`
+	}{plain: plain(r)}
+	if r.For > 0 {
+		res.For = r.For.String()
+	}
+	return json.Marshal(res)
+}
+
+// matches returns true if the value fulfills the condition of the rule.
+func (r Rule) matches(v float64) bool {
+	switch r.Op {
+	case "<":
+		return v < r.Value
+	case "<=":
+		return v <= r.Value
+	case ">":
+		return v > r.Value
+	case ">=":
+		return v >= r.Value
+	case "==":
+		return v == r.Value
+	case "!=":
+		return v != r.Value
+	}
+	return false
+}
+
+// Validate checks the metric and the operator of the rule.
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("a rule needs a name")
+	}
+	if !metrics[r.Metric] {
+		return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+	}
+	switch r.Op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return fmt.Errorf("rule %q: unknown operator %q", r.Name, r.Op)
+	}
+	return nil
+}
+
+// A WebhookConfig is the target of the notifications. Resend repeats the
+// notification of a firing alert after the given duration, never if 0.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Resend  time.Duration     `yaml:"resend,omitempty"`
+}
+
+// Config contains the rules and the webhooks.
+type Config struct {
+	Rules    []Rule          `yaml:"rules"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// Validate checks all rules and webhooks.
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+	for _, w := range c.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("a webhook needs an url")
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads and validates the rules and webhooks of a yaml file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read alert rules: %w", err)
+	}
+	var res Config
+	if err := yaml.UnmarshalStrict(data, &res); err != nil {
+		return nil, fmt.Errorf("cannot parse alert rules %q: %w", path, err)
+	}
+	if err := res.Validate(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,89 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type sent struct {
+	status State
+	at     time.Time
+}
+
+// A Webhook posts the alerts as JSON to an URL. Every state of an alert is sent
+// only once; a firing alert is sent again after the resend interval. Failed
+// posts are repeated at the next evaluation, the engine passes a resolved alert
+// again until it was posted.
+type Webhook struct {
+	lock   sync.Mutex
+	cfg    WebhookConfig
+	client *http.Client
+	sent   map[string]sent
+	now    func() time.Time
+}
+
+// NewWebhook returns a notifier for the webhook.
+func NewWebhook(cfg WebhookConfig) *Webhook {
+	return &Webhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		sent:   make(map[string]sent),
+		now:    time.Now,
+	}
+}
+
+// due returns true if the alert must be sent.
+func (w *Webhook) due(a Alert) bool {
+	last, ok := w.sent[a.Fingerprint]
+	switch {
+	case !ok:
+		// a resolved alert which was never sent as firing is not interesting
+		return a.Status == Firing
+	case last.status != a.Status:
+		return true
+	case a.Status == Firing && w.cfg.Resend > 0:
+		return w.now().Sub(last.at) >= w.cfg.Resend
+	}
+	return false
+}
+
+// Notify posts the alert if it is not a duplicate.
+func (w *Webhook) Notify(a Alert) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.due(a) {
+		return nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("cannot marshal alert: %w", err)
+	}
+	rq, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot create webhook request: %w", err)
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	for k, v := range w.cfg.Headers {
+		rq.Header.Set(k, v)
+	}
+	rsp, err := w.client.Do(rq)
+	if err != nil {
+		return fmt.Errorf("cannot post alert %s to webhook: %w", a.Fingerprint, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(rsp.Body)
+		return fmt.Errorf("cannot post alert %s to webhook: responsecode %d, data: %s", a.Fingerprint, rsp.StatusCode, body)
+	}
+	if a.Status == Resolved {
+		delete(w.sent, a.Fingerprint)
+	} else {
+		w.sent[a.Fingerprint] = sent{status: a.Status, at: w.now()}
+	}
+	return nil
+}
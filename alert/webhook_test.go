@@ -0,0 +1,51 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetriesResolved(t *testing.T) {
+	var posted []State
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		if fail {
+			http.Error(rw, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var a Alert
+		if err := json.NewDecoder(rq.Body).Decode(&a); err != nil {
+			t.Errorf("cannot decode alert: %v", err)
+		}
+		posted = append(posted, a.Status)
+	}))
+	defer srv.Close()
+
+	var errs int
+	rules := []Rule{{Name: "low", Metric: MetricSoC, Op: "<", Value: 10}}
+	e := NewEngine(rules, func(error) { errs++ }, NewWebhook(WebhookConfig{URL: srv.URL}))
+	site := Site{ID: "1"}
+	t0 := time.Now()
+	eval := func(i int, soc float64) {
+		e.Evaluate(site, Sample{Time: t0.Add(time.Duration(i) * time.Minute), Values: map[string]float64{MetricSoC: soc}})
+	}
+
+	eval(0, 5)
+	eval(1, 5)
+	fail = true
+	eval(2, 50)
+	eval(3, 50)
+	fail = false
+	eval(4, 50)
+	eval(5, 50)
+
+	if errs != 2 {
+		t.Errorf("got %d errors, want 2", errs)
+	}
+	if len(posted) != 2 || posted[0] != Firing || posted[1] != Resolved {
+		t.Errorf("posted %v, want firing and resolved", posted)
+	}
+}
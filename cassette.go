@@ -0,0 +1,414 @@
+package solaredge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "REDACTED"
+	// minTextLength is the minimum length of a value which is also replaced
+	// inside of other strings, shorter values would hit dates and numbers
+	minTextLength = 6
+)
+
+// textCategories are the categories whose values are also replaced inside of
+// other strings and in URLs, e.g. serial numbers in the names of equipment.
+var textCategories = map[string]bool{
+	"SN":   true,
+	"site": true,
+}
+
+// scrubbedKeys maps JSON keys whose values are replaced by the Anonymizer to the
+// category of the placeholder.
+var scrubbedKeys = map[string]string{
+	"SN":                         "SN",
+	"serialNumber":               "SN",
+	"connectedSolaredgeDeviceSN": "SN",
+	"connectedInverterSn":        "SN",
+	"accountId":                  "account",
+	"address":                    "address",
+	"address2":                   "address",
+	"zip":                        "zip",
+}
+
+// An Interaction is one recorded request together with its response.
+type Interaction struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body"`
+}
+
+// A Cassette is a list of recorded interactions which can be written to a file
+// and replayed later.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette from the given file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse cassette %q: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette to the given file.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal cassette: %w", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("cannot write cassette: %w", err)
+	}
+	return nil
+}
+
+// An Anonymizer replaces the API key, serial numbers, site names, addresses and
+// account ID's with placeholders. The same value always gets the same placeholder,
+// so relations between different responses survive the anonymization. Values
+// are replaced where they are the whole JSON value, only the API key and long
+// serial numbers and site names are also replaced inside of other strings.
+type Anonymizer struct {
+	lock         sync.Mutex
+	replacements map[string]string
+	text         map[string]string
+	numbers      map[string]json.Number
+	counters     map[string]int
+}
+
+// NewAnonymizer returns an empty Anonymizer.
+func NewAnonymizer() *Anonymizer {
+	return &Anonymizer{
+		replacements: make(map[string]string),
+		text:         make(map[string]string),
+		numbers:      make(map[string]json.Number),
+		counters:     make(map[string]int),
+	}
+}
+
+// Secret registers a value which is always replaced by REDACTED.
+func (a *Anonymizer) Secret(s string) {
+	if s == "" {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.replacements[s] = redacted
+	a.text[s] = redacted
+}
+
+// URL returns the path and query of the given URL without the API key. Path
+// segments and query values which are a known serial number or site name are
+// replaced, so the URL stays a valid key for the Replayer.
+func (a *Anonymizer) URL(u *url.URL) string {
+	q := u.Query()
+	for _, k := range q["api_key"] {
+		a.Secret(k)
+	}
+	q.Del("api_key")
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	segs := strings.Split(u.Path, "/")
+	for i, seg := range segs {
+		// the serial number of /equipment/{site}/{sn}/... does not have to be
+		// part of any body, so it is replaced by its position
+		if i >= 2 && segs[i-2] == "equipment" && i+1 < len(segs) {
+			segs[i] = url.PathEscape(a.placeholder("SN", seg))
+			continue
+		}
+		if p, ok := a.text[seg]; ok {
+			segs[i] = url.PathEscape(p)
+		}
+	}
+	res := strings.Join(segs, "/")
+	for _, vals := range q {
+		for i, v := range vals {
+			if p, ok := a.text[v]; ok {
+				vals[i] = p
+			}
+		}
+	}
+	if len(q) > 0 {
+		res = res + "?" + q.Encode()
+	}
+	return res
+}
+
+// Body anonymizes a response body. JSON bodies are scrubbed by their keys, all
+// other bodies only get the API key, serial numbers and site names replaced.
+func (a *Anonymizer) Body(data []byte) []byte {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return []byte(a.replaceText(string(data)))
+	}
+	// first replace the values of the scrubbed keys, so they are known when the
+	// remaining strings are replaced in the second pass
+	v = a.scrub("", v, false)
+	v = a.scrub("", v, true)
+	res, err := json.Marshal(v)
+	if err != nil {
+		return []byte(a.replaceText(string(data)))
+	}
+	return res
+}
+
+func (a *Anonymizer) scrub(key string, v any, replace bool) any {
+	switch val := v.(type) {
+	case map[string]any:
+		_, isSite := val["accountId"]
+		// walk the keys in a fixed order to get the same placeholders for the
+		// same responses
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e := val[k]
+			if isSite && k == "name" {
+				val[k] = a.scrub("siteName", e, replace)
+				continue
+			}
+			val[k] = a.scrub(k, e, replace)
+		}
+		return val
+	case []any:
+		for i, e := range val {
+			val[i] = a.scrub(key, e, replace)
+		}
+		return val
+	case string:
+		if cat, ok := a.category(key); ok {
+			if !replace && val != "" {
+				return a.placeholder(cat, val)
+			}
+			return val
+		}
+		if replace {
+			if p, ok := a.replacements[val]; ok {
+				return p
+			}
+			return a.replaceText(val)
+		}
+		return val
+	case json.Number:
+		if cat, ok := a.category(key); ok && !replace {
+			return a.number(cat, val)
+		}
+		return val
+	}
+	return v
+}
+
+func (a *Anonymizer) category(key string) (string, bool) {
+	if key == "siteName" {
+		return "site", true
+	}
+	cat, ok := scrubbedKeys[key]
+	return cat, ok
+}
+
+func (a *Anonymizer) placeholder(cat, val string) string {
+	if p, ok := a.replacements[val]; ok {
+		return p
+	}
+	a.counters[cat]++
+	p := fmt.Sprintf("%s-%d", cat, a.counters[cat])
+	a.replacements[val] = p
+	if textCategories[cat] && len(val) >= minTextLength {
+		a.text[val] = p
+	}
+	return p
+}
+
+func (a *Anonymizer) number(cat string, val json.Number) json.Number {
+	if p, ok := a.numbers[val.String()]; ok {
+		return p
+	}
+	a.counters[cat]++
+	p := json.Number(fmt.Sprint(a.counters[cat]))
+	a.numbers[val.String()] = p
+	return p
+}
+
+// replaceText replaces the values which are also replaced inside of other
+// strings, longest values first so a value which contains another one is
+// replaced as a whole.
+func (a *Anonymizer) replaceText(s string) string {
+	known := make([]string, 0, len(a.text))
+	for k := range a.text {
+		known = append(known, k)
+	}
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, k := range known {
+		s = strings.ReplaceAll(s, k, a.text[k])
+	}
+	return s
+}
+
+// A Recorder is a http.RoundTripper which forwards requests to the underlying
+// transport and records the anonymized interactions.
+type Recorder struct {
+	lock      sync.Mutex
+	transport http.RoundTripper
+	anon      *Anonymizer
+	cassette  Cassette
+}
+
+// NewRecorder returns a Recorder which uses the given transport. If the transport
+// is nil, the http.DefaultTransport is used.
+func NewRecorder(rt http.RoundTripper) *Recorder {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &Recorder{
+		transport: rt,
+		anon:      NewAnonymizer(),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(rq *http.Request) (*http.Response, error) {
+	rsp, err := r.transport.RoundTrip(rq)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read body response: %w", err)
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	// the body has to be anonymized before the url, so serial numbers in the
+	// path are known
+	u := *rq.URL
+	for _, k := range u.Query()["api_key"] {
+		r.anon.Secret(k)
+	}
+	body := r.anon.Body(data)
+	ia := Interaction{
+		Method:      rq.Method,
+		URL:         r.anon.URL(&u),
+		Status:      rsp.StatusCode,
+		ContentType: rsp.Header.Get("content-type"),
+		Body:        string(body),
+	}
+	r.lock.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, ia)
+	r.lock.Unlock()
+	return rsp, nil
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := &Cassette{Interactions: make([]Interaction, len(r.cassette.Interactions))}
+	copy(res.Interactions, r.cassette.Interactions)
+	return res
+}
+
+// Save writes the recorded interactions to the given file.
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// A Replayer is a http.RoundTripper which answers requests from a Cassette without
+// any network access. Requests are matched by method, path and query; the API key
+// is ignored. If a request was recorded more than once, the responses are returned
+// in the recorded order and the last one is repeated.
+type Replayer struct {
+	lock   sync.Mutex
+	byKey  map[string][]Interaction
+	played map[string]int
+}
+
+// NewReplayer returns a Replayer for the given cassette.
+func NewReplayer(c *Cassette) *Replayer {
+	res := &Replayer{
+		byKey:  make(map[string][]Interaction),
+		played: make(map[string]int),
+	}
+	for _, ia := range c.Interactions {
+		u, err := url.Parse(ia.URL)
+		if err != nil {
+			continue
+		}
+		k := replayKey(ia.Method, u)
+		res.byKey[k] = append(res.byKey[k], ia)
+	}
+	return res
+}
+
+// LoadReplayer returns a Replayer for the cassette in the given file.
+func LoadReplayer(path string) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(c), nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(rq *http.Request) (*http.Response, error) {
+	k := replayKey(rq.Method, rq.URL)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ias, ok := r.byKey[k]
+	if !ok {
+		return nil, fmt.Errorf("no recorded interaction for %s", k)
+	}
+	idx := r.played[k]
+	if idx >= len(ias) {
+		idx = len(ias) - 1
+	} else {
+		r.played[k]++
+	}
+	ia := ias[idx]
+	hdr := make(http.Header)
+	if ia.ContentType != "" {
+		hdr.Set("content-type", ia.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ia.Status, http.StatusText(ia.Status)),
+		StatusCode:    ia.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          ioutil.NopCloser(strings.NewReader(ia.Body)),
+		ContentLength: int64(len(ia.Body)),
+		Request:       rq,
+	}, nil
+}
+
+func replayKey(method string, u *url.URL) string {
+	q := u.Query()
+	q.Del("api_key")
+	if len(q) == 0 {
+		return method + " " + u.Path
+	}
+	return method + " " + u.Path + "?" + q.Encode()
+}
@@ -0,0 +1,165 @@
+package solaredge
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testKey    = "secretkey123"
+	testSerial = "7F123456-AB"
+)
+
+// testAPI answers the calls of the session with values which look like dates
+// and energies, the zip code is a year.
+func testAPI(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/site/1/details.json", func(rw http.ResponseWriter, rq *http.Request) {
+		fmt.Fprint(rw, `{"details":{"id":1,"name":"Sunny Home","accountId":4711,"status":"Active",
+			"location":{"country":"Austria","city":"Vienna","address":"Main Street 1","zip":"2023","timeZone":"Europe/Vienna"}}}`)
+	})
+	mux.HandleFunc("/site/1/inventory.json", func(rw http.ResponseWriter, rq *http.Request) {
+		fmt.Fprintf(rw, `{"Inventory":{"inverters":[{"name":"Inverter 1 (%s)","model":"SE5000","SN":%q}]}}`, testSerial, testSerial)
+	})
+	mux.HandleFunc("/site/1/energyDetails.json", func(rw http.ResponseWriter, rq *http.Request) {
+		fmt.Fprint(rw, `{"energyDetails":{"timeUnit":"DAY","unit":"Wh","meters":[{"type":"Production",
+			"values":[{"date":"2023-05-01 00:00:00","value":2023},{"date":"2023-05-02 00:00:00","value":20230}]}]}}`)
+	})
+	mux.HandleFunc("/equipment/1/"+testSerial+"/data.json", func(rw http.ResponseWriter, rq *http.Request) {
+		fmt.Fprint(rw, `{"data":{"count":1,"telemetries":[{"date":"2023-05-01 12:00:00","totalActivePower":2023}]}}`)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		if rq.URL.Query().Get("api_key") != testKey {
+			http.Error(rw, "invalid key", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(rw, rq)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// session calls the API like a user of the commands; the serial number is taken
+// from the inventory.
+func session(t *testing.T, sc *SiteClient) (*Site, *Inventory, *EngergyDetails, []InverterTelemetry) {
+	t.Helper()
+	det, err := sc.Details()
+	if err != nil {
+		t.Fatalf("cannot query details: %v", err)
+	}
+	inv, err := sc.Inventory()
+	if err != nil {
+		t.Fatalf("cannot query inventory: %v", err)
+	}
+	if len(inv.Inverters) != 1 {
+		t.Fatalf("inventory has %d inverters, want 1", len(inv.Inverters))
+	}
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 5, 31, 0, 0, 0, 0, time.UTC)
+	ed, err := sc.EnergyDetails(Day, start, end)
+	if err != nil {
+		t.Fatalf("cannot query energy details: %v", err)
+	}
+	tel, err := sc.InverterData(inv.Inverters[0].SN, start, end)
+	if err != nil {
+		t.Fatalf("cannot query inverter data: %v", err)
+	}
+	return det, inv, ed, tel
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := testAPI(t)
+	rec := NewRecorder(nil)
+	sc, err := SiteFromIDs(testKey, "1", WithBaseURL(srv.URL), WithTransport(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, sc)
+
+	cassette := rec.Cassette()
+	if len(cassette.Interactions) != 4 {
+		t.Fatalf("recorded %d interactions, want 4", len(cassette.Interactions))
+	}
+	var all strings.Builder
+	for _, ia := range cassette.Interactions {
+		all.WriteString(ia.URL + "\n" + ia.Body + "\n")
+	}
+	for _, secret := range []string{testKey, "Sunny Home", "Main Street", testSerial, `"2023"`, "4711"} {
+		if strings.Contains(all.String(), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, all.String())
+		}
+	}
+	// short scrubbed values like the zip must not touch dates and values
+	for _, kept := range []string{"2023-05-01 00:00:00", `"value":2023`, `"value":20230`, "startTime=2023-05-01"} {
+		if !strings.Contains(all.String(), kept) {
+			t.Errorf("cassette lost %q:\n%s", kept, all.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	rp, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err = SiteFromIDs("otherkey", "1", WithBaseURL("http://replay.invalid"), WithTransport(rp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	det, inv, ed, tel := session(t, sc)
+	if det.Name != "site-1" || det.Location.Zip != "zip-1" || det.Location.City != "Vienna" || det.AccountId != 1 {
+		t.Errorf("unexpected replayed details %+v", *det)
+	}
+	if inv.Inverters[0].SN != "SN-1" || inv.Inverters[0].Name != "Inverter 1 (SN-1)" {
+		t.Errorf("unexpected replayed inverter %+v", inv.Inverters[0])
+	}
+	if len(ed.Meters) != 1 || len(ed.Meters[0].Values) != 2 || ed.Meters[0].Values[0].Value != 2023 || ed.Meters[0].Values[1].Value != 20230 {
+		t.Errorf("unexpected replayed energy details %+v", *ed)
+	}
+	if len(tel) != 1 || tel[0].TotalActivePower != 2023 {
+		t.Errorf("unexpected replayed telemetries %+v", tel)
+	}
+}
+
+func TestRecordInverterData(t *testing.T) {
+	srv := testAPI(t)
+	rec := NewRecorder(nil)
+	sc, err := SiteFromIDs(testKey, "1", WithBaseURL(srv.URL), WithTransport(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the serial number is given by the user and is not part of any body
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := sc.InverterData(testSerial, start, end); err != nil {
+		t.Fatal(err)
+	}
+	cassette := rec.Cassette()
+	if len(cassette.Interactions) != 1 {
+		t.Fatalf("recorded %d interactions, want 1", len(cassette.Interactions))
+	}
+	u := cassette.Interactions[0].URL
+	if strings.Contains(u, testSerial) || !strings.HasPrefix(u, "/equipment/1/SN-1/data.json?") {
+		t.Errorf("unexpected recorded url %q", u)
+	}
+
+	sc, err = SiteFromIDs("otherkey", "1", WithBaseURL("http://replay.invalid"), WithTransport(NewReplayer(cassette)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tel, err := sc.InverterData("SN-1", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tel) != 1 || tel[0].TotalActivePower != 2023 {
+		t.Errorf("unexpected replayed telemetries %+v", tel)
+	}
+}
@@ -2,16 +2,47 @@ package solaredge
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 const (
 	DEFAULT_URL = "https://monitoringapi.solaredge.com"
+	// MaxConcurrentCalls is the number of concurrent API calls solaredge allows
+	// per API key.
+	MaxConcurrentCalls = 3
+	// DefaultTimeout is the time a call of the API may take.
+	DefaultTimeout = 30 * time.Second
 )
 
+// ResponseError is returned when the API answers with a non 2xx statuscode.
+type ResponseError struct {
+	StatusCode int
+	Data       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("responsecode %d, data: %s", e.StatusCode, e.Data)
+}
+
+// IsQuotaExceeded returns true if the error is a ResponseError which tells that
+// the daily request quota is used up.
+func IsQuotaExceeded(err error) bool {
+	var re *ResponseError
+	return errors.As(err, &re) && re.StatusCode == http.StatusTooManyRequests
+}
+
+// IsAuthError returns true if the error is a ResponseError which tells that the
+// API key is invalid or has no access to the site.
+func IsAuthError(err error) bool {
+	var re *ResponseError
+	return errors.As(err, &re) && (re.StatusCode == http.StatusUnauthorized || re.StatusCode == http.StatusForbidden)
+}
+
 // SEOpts is a options type for the client.
 type SEOpt func(sec *SEClient)
 
@@ -20,6 +51,8 @@ type SEClient struct {
 	apikey  string
 	baseurl string
 	client  *http.Client
+	calls   chan struct{}
+	quota   *quotaCounter
 }
 
 // SiteClient wraps a site and contains site specific methods.
@@ -32,7 +65,9 @@ type SiteClient struct {
 func NewClient(apikey string) *SEClient {
 	return &SEClient{
 		apikey: apikey,
-		client: http.DefaultClient,
+		client: &http.Client{Timeout: DefaultTimeout},
+		calls:  make(chan struct{}, MaxConcurrentCalls),
+		quota:  &quotaCounter{},
 	}
 }
 
@@ -43,6 +78,22 @@ func WithBaseURL(u string) SEOpt {
 	}
 }
 
+// WithTransport is an option for the SEClient to send all requests through the given
+// RoundTripper, e.g. a Recorder or a Replayer.
+func WithTransport(rt http.RoundTripper) SEOpt {
+	return func(c *SEClient) {
+		c.client.Transport = rt
+	}
+}
+
+// WithTimeout is an option for the SEClient to change the time a call may take,
+// the default is DefaultTimeout.
+func WithTimeout(d time.Duration) SEOpt {
+	return func(c *SEClient) {
+		c.client.Timeout = d
+	}
+}
+
 // NewSite returns a SiteClient with the given site-ID.
 func (sec *SEClient) NewSite(sid string) *SiteClient {
 	return &SiteClient{
@@ -51,8 +102,13 @@ func (sec *SEClient) NewSite(sid string) *SiteClient {
 	}
 }
 
-// SiteFromIDs return a SiteClient with the given apikey and siteid.
-func SiteFromIDs(apikey, siteid string, opts ...SEOpt) (*SiteClient, error) {
+// ID returns the site-ID of the SiteClient.
+func (sc *SiteClient) ID() string {
+	return sc.siteid
+}
+
+// ClientFromKey returns a SEClient with the given apikey and options.
+func ClientFromKey(apikey string, opts ...SEOpt) (*SEClient, error) {
 	cl := NewClient(apikey)
 	for _, o := range opts {
 		o(cl)
@@ -60,6 +116,15 @@ func SiteFromIDs(apikey, siteid string, opts ...SEOpt) (*SiteClient, error) {
 	if cl.baseurl == "" {
 		cl.baseurl = DEFAULT_URL
 	}
+	return cl, nil
+}
+
+// SiteFromIDs return a SiteClient with the given apikey and siteid.
+func SiteFromIDs(apikey, siteid string, opts ...SEOpt) (*SiteClient, error) {
+	cl, err := ClientFromKey(apikey, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return cl.NewSite(siteid), nil
 }
 
@@ -73,17 +138,15 @@ func (sec *SEClient) get(path string, parms url.Values, target any) error {
 	if err != nil {
 		return fmt.Errorf("cannot create request: %w", err)
 	}
-	rsp, err := sec.client.Do(rq)
-	if err != nil {
-		return fmt.Errorf("cannot invoke request: %w", err)
-	}
-	defer rsp.Body.Close()
-	data, err := ioutil.ReadAll(rsp.Body)
+	// solaredge rejects more than MaxConcurrentCalls parallel calls per key
+	sec.calls <- struct{}{}
+	data, rsp, err := sec.do(rq)
+	<-sec.calls
 	if err != nil {
-		return fmt.Errorf("cannot read body response: %w", err)
+		return err
 	}
 	if rsp.StatusCode/100 != 2 {
-		return fmt.Errorf("responsecode %d, data: %s", rsp.StatusCode, string(data))
+		return &ResponseError{StatusCode: rsp.StatusCode, Data: string(data)}
 	}
 	err = json.Unmarshal(data, target)
 	if err != nil {
@@ -91,3 +154,16 @@ func (sec *SEClient) get(path string, parms url.Values, target any) error {
 	}
 	return nil
 }
+
+func (sec *SEClient) do(rq *http.Request) ([]byte, *http.Response, error) {
+	rsp, err := sec.client.Do(rq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot invoke request: %w", err)
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read body response: %w", err)
+	}
+	return data, rsp, nil
+}
@@ -0,0 +1,36 @@
+package solaredge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	rec := NewRecorder(nil)
+	sc, err := SiteFromIDs(testKey, "1", WithTransport(rec), WithBaseURL(srv.URL), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := sc.Details(); err == nil {
+		t.Fatal("hanging call did not fail")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("call failed after %s", d)
+	}
+	if sc.client.Transport != rec {
+		t.Error("the transport is not kept with a timeout")
+	}
+	if NewClient(testKey).client.Timeout != DefaultTimeout {
+		t.Error("client has no default timeout")
+	}
+}
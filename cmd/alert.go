@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ulrichSchreiner/solaredge/alert"
+)
+
+var (
+	alertRules string
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&alertRules, "alert-rules", "", "a yaml file with alert rules and webhooks")
+}
+
+// newAlertEngine returns the engine for the rules of --alert-rules, otherwise
+// for the rules of the config.
+func newAlertEngine() (*alert.Engine, error) {
+	cfg := alertConfig
+	if alertRules != "" {
+		var err error
+		if cfg, err = alert.LoadConfig(alertRules); err != nil {
+			return nil, err
+		}
+	}
+	var notifiers []alert.Notifier
+	for _, w := range cfg.Webhooks {
+		notifiers = append(notifiers, alert.NewWebhook(w))
+	}
+	onError := func(err error) {
+		log.Error().Err(err).Msg("cannot send alert")
+	}
+	return alert.NewEngine(cfg.Rules, onError, notifiers...), nil
+}
+
+// alertSample returns the current metrics of the site for the alert rules.
+func (ss *siteService) alertSample(now time.Time) alert.Sample {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	pf := ss.currentPowerFlow
+	fd := genFlowData(pf)
+	values := map[string]float64{
+		alert.MetricGrid: fd.Grid,
+		alert.MetricLoad: pf.Load.CurrentPower * unitFactor(pf.Unit),
+	}
+	if pf.PV != nil {
+		values[alert.MetricPV] = fd.PV
+	}
+	if pf.Storage != nil {
+		values[alert.MetricBattery] = fd.Battery
+		values[alert.MetricSoC] = fd.SoC
+		values[alert.MetricCritical] = 0
+		if pf.Storage.Critical {
+			values[alert.MetricCritical] = 1
+		}
+	}
+	if lu := time.Time(ss.currentOverview.LastUpdateTime); !lu.IsZero() {
+		values[alert.MetricDataAge] = now.Sub(lu).Seconds()
+	}
+	if !ss.flowChanged.IsZero() {
+		values[alert.MetricFlowAge] = now.Sub(ss.flowChanged).Seconds()
+	}
+	return alert.Sample{
+		Time:     now,
+		Daylight: ss.scheduler.IsDay(now),
+		Values:   values,
+	}
+}
+
+// evaluateAlerts applies the alert rules to the current data of the site.
+func (ss *siteService) evaluateAlerts() {
+	if ss.alerts == nil {
+		return
+	}
+	sample := ss.alertSample(time.Now())
+	ss.alerts.Evaluate(alert.Site{ID: ss.site.ID(), Name: ss.name()}, sample)
+}
+
+// listAlerts lists the pending, firing and resolved alerts of all sites.
+func (ses *solaredgeService) listAlerts(rw http.ResponseWriter, rq *http.Request) {
+	res := []alert.Alert{}
+	if ses.alerts != nil {
+		res = ses.alerts.Alerts()
+	}
+	rw.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(res)
+}
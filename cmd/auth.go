@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// The endpoint groups of serve which can be protected separately.
+const (
+	groupMetrics = "metrics"
+	groupHealth  = "health"
+	groupData    = "data"
+	groupDetails = "details"
+	groupControl = "control"
+
+	// certCheck is the interval in which the certificate files are checked for
+	// changes
+	certCheck = 10 * time.Second
+)
+
+var (
+	tlsCert  string
+	tlsKey   string
+	authFile string
+
+	endpointGroups = map[string]bool{groupMetrics: true, groupHealth: true, groupData: true, groupDetails: true, groupControl: true}
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&tlsCert, "tls-cert", "", "serve https with this certificate file, it is reloaded when it changes")
+	serveCmd.PersistentFlags().StringVar(&tlsKey, "tls-key", "", "the key file of the certificate")
+	serveCmd.PersistentFlags().StringVar(&authFile, "auth-file", "", "a yaml file with the users and the endpoint groups they may access")
+}
+
+// authUser may access the endpoint groups with a bearer token or with basic
+// auth.
+type authUser struct {
+	Name     string   `yaml:"name"`
+	Token    string   `yaml:"token,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	Groups   []string `yaml:"groups"`
+}
+
+// authConfig contains the users and the groups which need no authentication.
+type authConfig struct {
+	Public []string   `yaml:"public,omitempty"`
+	Users  []authUser `yaml:"users"`
+}
+
+func loadAuthConfig(path string) (*authConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read auth file: %w", err)
+	}
+	var res authConfig
+	if err := yaml.UnmarshalStrict(data, &res); err != nil {
+		return nil, fmt.Errorf("cannot parse auth file %q: %w", path, err)
+	}
+	for _, g := range res.Public {
+		if !endpointGroups[g] {
+			return nil, fmt.Errorf("unknown public group %q", g)
+		}
+	}
+	for _, u := range res.Users {
+		if u.Token == "" && (u.Username == "" || u.Password == "") {
+			return nil, fmt.Errorf("user %q needs a token or a username and a password", u.Name)
+		}
+		for _, g := range u.Groups {
+			if !endpointGroups[g] {
+				return nil, fmt.Errorf("user %q: unknown group %q", u.Name, g)
+			}
+		}
+	}
+	return &res, nil
+}
+
+// endpointGroup returns the group of the endpoint with the given URL. The
+// details contain the address of the site and the inventory the serial numbers
+// of the devices, the control endpoints trigger API calls like the energy
+// history which is filled from the API.
+func endpointGroup(u *url.URL) string {
+	path := u.Path
+	_, endpoint, _ := strings.Cut(strings.TrimPrefix(path, "/sites/"), "/")
+	switch {
+	case path == "/metrics":
+		return groupMetrics
+	case path == "/healthz" || path == "/readyz":
+		return groupHealth
+	case path == "/details" || path == "/inventory" || strings.HasPrefix(path, "/sites/") && (endpoint == "details" || endpoint == "inventory"):
+		return groupDetails
+	case path == "/refresh" || strings.HasPrefix(path, "/sites/") && endpoint == "refresh":
+		return groupControl
+	case (path == "/history/energy" || strings.HasPrefix(path, "/sites/") && endpoint == "history/energy") && fillRequested(u.Query()):
+		return groupControl
+	}
+	return groupData
+}
+
+// equal compares the secrets in constant time.
+func equal(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// authenticate returns the user of the request. Browsers cannot set headers for
+// EventSource and WebSocket, so the token may also be the query parameter
+// access_token.
+func (ac *authConfig) authenticate(rq *http.Request) (*authUser, bool) {
+	token := rq.URL.Query().Get("access_token")
+	if h := rq.Header.Get("authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	username, password, basic := rq.BasicAuth()
+	for i := range ac.Users {
+		u := &ac.Users[i]
+		if token != "" && u.Token != "" && equal(token, u.Token) {
+			return u, true
+		}
+		if basic && u.Username != "" && equal(username, u.Username) && equal(password, u.Password) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+func (ac *authConfig) isPublic(group string) bool {
+	for _, g := range ac.Public {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a handler which only passes the requests of users who may access
+// the group of the endpoint.
+func (ac *authConfig) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		group := endpointGroup(rq.URL)
+		if ac.isPublic(group) {
+			next.ServeHTTP(rw, rq)
+			return
+		}
+		u, ok := ac.authenticate(rq)
+		if !ok {
+			rw.Header().Add("www-authenticate", `Basic realm="solaredge"`)
+			rw.Header().Add("www-authenticate", `Bearer realm="solaredge"`)
+			http.Error(rw, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		for _, g := range u.Groups {
+			if g == group {
+				next.ServeHTTP(rw, rq)
+				return
+			}
+		}
+		http.Error(rw, fmt.Sprintf("%s may not access %s", u.Name, group), http.StatusForbidden)
+	})
+}
+
+// certReloader loads the certificate again when one of its files changes, so a
+// renewed certificate is used without a restart.
+type certReloader struct {
+	lock     sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	res := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// modified returns the newest modification time of the files.
+func (cr *certReloader) modified() (time.Time, error) {
+	var res time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return res, fmt.Errorf("cannot stat %s: %w", f, err)
+		}
+		if fi.ModTime().After(res) {
+			res = fi.ModTime()
+		}
+	}
+	return res, nil
+}
+
+func (cr *certReloader) load() error {
+	mod, err := cr.modified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate: %w", err)
+	}
+	cr.cert = &cert
+	cr.modTime = mod
+	return nil
+}
+
+// getCertificate returns the current certificate. A certificate which cannot be
+// loaded does not replace the old one.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if time.Since(cr.checked) < certCheck {
+		return cr.cert, nil
+	}
+	cr.checked = time.Now()
+	if mod, err := cr.modified(); err != nil || !mod.After(cr.modTime) {
+		return cr.cert, nil
+	}
+	if err := cr.load(); err != nil {
+		log.Error().Err(err).Msg("cannot reload certificate")
+		return cr.cert, nil
+	}
+	log.Info().Str("cert", cr.certFile).Msg("reloaded certificate")
+	return cr.cert, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/export"
+	"gitlab.com/ulrichSchreiner/solaredge/history"
+	"gitlab.com/ulrichSchreiner/solaredge/influx"
+)
+
+var (
+	backfillHistory    string
+	backfillCheckpoint string
+	backfillBudget     int
+	backfillKinds      []string
+	backfillUnit       string
+	backfillCmd        = &cobra.Command{
+		Use:   "backfill",
+		Short: "loads the whole history of a site into the history store",
+		Run: func(cmd *cobra.Command, args []string) {
+			siteid := viper.GetString("siteid")
+			if len(args) > 0 {
+				siteid = args[0]
+			}
+			backfill(siteid)
+		},
+	}
+
+	errBudgetExhausted = errors.New("daily budget exhausted")
+)
+
+func init() {
+	backfillCmd.PersistentFlags().StringVar(&backfillHistory, "history", "history", "the directory of the history store")
+	backfillCmd.PersistentFlags().StringVar(&backfillCheckpoint, "checkpoint", "", "the checkpoint file, <history>/backfill-<siteid>.json if empty")
+	backfillCmd.PersistentFlags().IntVar(&backfillBudget, "budget", 250, "the number of API calls which may be used per day")
+	backfillCmd.PersistentFlags().StringSliceVar(&backfillKinds, "kinds", []string{"energy", "power", "storage"}, "the data to load: energy, power and/or storage")
+	backfillCmd.PersistentFlags().StringVar(&backfillUnit, "unit", string(solaredge.Quarter_Of_An_Hour), "the time unit of the energy details")
+}
+
+// backfillState is stored in the checkpoint file. Done contains the time up to
+// which every kind is loaded, Day and Calls count the API calls of the current day.
+type backfillState struct {
+	Site  string               `json:"site"`
+	Start time.Time            `json:"start"`
+	Done  map[string]time.Time `json:"done"`
+	Day   string               `json:"day"`
+	Calls int                  `json:"calls"`
+}
+
+func loadBackfillState(path, siteid string) (*backfillState, error) {
+	res := &backfillState{
+		Site: siteid,
+		Done: make(map[string]time.Time),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("cannot parse checkpoint %q: %w", path, err)
+	}
+	if res.Site != siteid {
+		return nil, fmt.Errorf("checkpoint %q belongs to site %q", path, res.Site)
+	}
+	if res.Done == nil {
+		res.Done = make(map[string]time.Time)
+	}
+	return res, nil
+}
+
+func (bs *backfillState) save(path string) error {
+	data, err := json.MarshalIndent(bs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal checkpoint: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot replace checkpoint: %w", err)
+	}
+	return nil
+}
+
+// call counts an API call against the daily budget.
+func (bs *backfillState) call(budget int) error {
+	today := time.Now().Format("2006-01-02")
+	if bs.Day != today {
+		bs.Day = today
+		bs.Calls = 0
+	}
+	if bs.Calls >= budget {
+		return errBudgetExhausted
+	}
+	bs.Calls++
+	return nil
+}
+
+// backfillWindow returns the largest time range which the API accepts for one
+// call of the given kind.
+func backfillWindow(kind string, unit solaredge.TimeUnit) func(time.Time) time.Time {
+	switch kind {
+	case "storage":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "energy":
+		if unit != solaredge.Quarter_Of_An_Hour && unit != solaredge.Hour {
+			return func(t time.Time) time.Time { return addMonths(t, 12) }
+		}
+	}
+	return func(t time.Time) time.Time { return addMonths(t, 1) }
+}
+
+// addMonths returns the first day of the month the months after the month of t.
+// Unlike AddDate it never spans more than the months, AddDate turns Jan 31 into
+// Mar 3 which is longer than the windows of the API; the windows after the
+// first one start on the first day of a month.
+func addMonths(t time.Time, months int) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+}
+
+// timeWindow is the time range [start, end) of one API call.
+type timeWindow struct {
+	start, end time.Time
+}
+
+// backfillWindows splits [from, to) into the windows of the kind, the windows are
+// in the location of from.
+func backfillWindows(kind string, unit solaredge.TimeUnit, from, to time.Time) []timeWindow {
+	next := backfillWindow(kind, unit)
+	var res []timeWindow
+	for start := from; start.Before(to); {
+		end := next(start)
+		if end.After(to) {
+			end = to.In(from.Location())
+		}
+		res = append(res, timeWindow{start: start, end: end})
+		start = end
+	}
+	return res
+}
+
+func backfillFetch(sc *solaredge.SiteClient, hs *history.Store, iw *influx.Writer, kind string, unit solaredge.TimeUnit, start, end time.Time) (int, error) {
+	var series *export.Series
+	var n int
+	var err error
+	switch kind {
+	case "energy":
+		det, ferr := sc.EnergyDetails(unit, start, end)
+		if ferr != nil {
+			return 0, ferr
+		}
+		series = export.Meters(det.Meters)
+		n, err = hs.AddMeters(sc.ID(), history.KindEnergy, det.Meters)
+	case "power":
+		det, ferr := sc.PowerDetails(start, end)
+		if ferr != nil {
+			return 0, ferr
+		}
+		series = export.Meters(det.Meters)
+		n, err = hs.AddMeters(sc.ID(), history.KindPower, det.Meters)
+	case "storage":
+		det, ferr := sc.StorageData(start, end)
+		if ferr != nil {
+			return 0, ferr
+		}
+		series = export.Storage(det)
+		n, err = hs.AddStorage(sc.ID(), det)
+	default:
+		return 0, fmt.Errorf("unknown kind %q", kind)
+	}
+	if err != nil || iw == nil {
+		return n, err
+	}
+	// the values are in the history, so a failed write does not stop the backfill
+	iw.Write(seriesPoints(kind, sc.ID(), series)...)
+	return n, nil
+}
+
+func backfill(siteid string) {
+	unit := solaredge.TimeUnit(strings.ToUpper(backfillUnit))
+	for _, k := range backfillKinds {
+		if k != "energy" && k != "power" && k != "storage" {
+			log.Fatal().Str("kind", k).Msg("unknown kind, use energy, power or storage")
+		}
+	}
+	sc, err := solaredge.SiteFromIDs(viper.GetString("apikey"), siteid, clientOptions()...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot create client")
+	}
+	hs, err := history.Open(backfillHistory)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot open history")
+	}
+	cp := backfillCheckpoint
+	if cp == "" {
+		cp = filepath.Join(backfillHistory, fmt.Sprintf("backfill-%s.json", siteid))
+	}
+	state, err := loadBackfillState(cp, siteid)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot load checkpoint")
+	}
+
+	iw, err := newInfluxWriter()
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot create influx writer")
+	}
+
+	err = runBackfill(sc, hs, iw, state, cp, unit)
+	if iw != nil {
+		if ierr := iw.Close(); ierr != nil {
+			log.Error().Err(ierr).Msg("cannot write to influx")
+		}
+	}
+	if serr := state.save(cp); serr != nil {
+		log.Error().Err(serr).Msg("cannot save checkpoint")
+	}
+	switch {
+	case errors.Is(err, errBudgetExhausted):
+		log.Info().Int("calls", state.Calls).Msg("daily budget reached, run backfill again tomorrow to resume")
+	case solaredge.IsQuotaExceeded(err):
+		log.Info().Err(err).Msg("API quota exceeded, run backfill again later to resume")
+	case err != nil:
+		log.Fatal().Err(err).Msg("backfill failed, run backfill again to resume")
+	default:
+		log.Info().Int("calls", state.Calls).Msg("backfill complete")
+	}
+}
+
+func runBackfill(sc *solaredge.SiteClient, hs *history.Store, iw *influx.Writer, state *backfillState, cp string, unit solaredge.TimeUnit) error {
+	if state.Start.IsZero() {
+		if err := state.call(backfillBudget); err != nil {
+			return err
+		}
+		dp, err := sc.DataPeriod()
+		if err != nil {
+			return fmt.Errorf("cannot query data period: %w", err)
+		}
+		if time.Time(dp.StartDate).IsZero() {
+			log.Info().Msg("the site has no data")
+			return nil
+		}
+		state.Start = time.Time(dp.StartDate)
+		if err := state.save(cp); err != nil {
+			return err
+		}
+	}
+	// the API interprets the times in the zone of the site
+	loc, err := time.LoadLocation(solaredge.SiteZone)
+	if err != nil {
+		return fmt.Errorf("cannot load site zone: %w", err)
+	}
+	now := time.Now().In(loc)
+	for _, kind := range backfillKinds {
+		start := state.Start.In(loc)
+		if done, ok := state.Done[kind]; ok {
+			start = done.In(loc)
+		}
+		for _, w := range backfillWindows(kind, unit, start, now) {
+			if err := state.call(backfillBudget); err != nil {
+				return err
+			}
+			n, err := backfillFetch(sc, hs, iw, kind, unit, w.start, w.end)
+			if err != nil {
+				return fmt.Errorf("cannot query %s from %s to %s: %w", kind, w.start, w.end, err)
+			}
+			log.Info().
+				Str("kind", kind).
+				Time("start", w.start).
+				Time("end", w.end).
+				Int("values", n).
+				Msg("loaded history")
+			state.Done[kind] = w.end
+			if err := state.save(cp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/history"
+)
+
+func mustZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestAddMonths(t *testing.T) {
+	vienna := mustZone(t, "Europe/Vienna")
+	for _, tc := range []struct {
+		t      time.Time
+		months int
+		want   time.Time
+	}{
+		{time.Date(2023, 1, 31, 12, 0, 0, 0, time.UTC), 1, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), 1, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 12, 15, 0, 0, 0, 0, time.UTC), 1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC), 12, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// the month starts at midnight of the zone, also after a DST change
+		{time.Date(2023, 3, 20, 8, 0, 0, 0, vienna), 1, time.Date(2023, 4, 1, 0, 0, 0, 0, vienna)},
+	} {
+		if got := addMonths(tc.t, tc.months); !got.Equal(tc.want) || got.Location() != tc.want.Location() {
+			t.Errorf("addMonths(%v, %d) is %v, want %v", tc.t, tc.months, got, tc.want)
+		}
+	}
+}
+
+func TestBackfillWindows(t *testing.T) {
+	vienna := mustZone(t, "Europe/Vienna")
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, vienna) }
+	for _, tc := range []struct {
+		kind     string
+		unit     solaredge.TimeUnit
+		from, to time.Time
+		want     []timeWindow
+	}{
+		{"energy", solaredge.Quarter_Of_An_Hour, day(2023, 1, 31), day(2023, 3, 15), []timeWindow{
+			{day(2023, 1, 31), day(2023, 2, 1)},
+			{day(2023, 2, 1), day(2023, 3, 1)},
+			{day(2023, 3, 1), day(2023, 3, 15)},
+		}},
+		{"power", solaredge.Day, day(2023, 3, 1), day(2023, 4, 1), []timeWindow{
+			{day(2023, 3, 1), day(2023, 4, 1)},
+		}},
+		{"energy", solaredge.Day, day(2022, 6, 10), day(2024, 1, 2), []timeWindow{
+			{day(2022, 6, 10), day(2023, 6, 1)},
+			{day(2023, 6, 1), day(2024, 1, 2)},
+		}},
+		{"storage", solaredge.Quarter_Of_An_Hour, day(2023, 3, 20), day(2023, 4, 1), []timeWindow{
+			{day(2023, 3, 20), day(2023, 3, 27)},
+			{day(2023, 3, 27), day(2023, 4, 1)},
+		}},
+		// the end is given in another zone
+		{"energy", solaredge.Hour, day(2023, 5, 1), day(2023, 5, 2).UTC(), []timeWindow{
+			{day(2023, 5, 1), day(2023, 5, 2)},
+		}},
+		{"energy", solaredge.Hour, day(2023, 5, 1), day(2023, 5, 1), nil},
+	} {
+		got := backfillWindows(tc.kind, tc.unit, tc.from, tc.to)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s/%s from %v to %v has windows %v, want %v", tc.kind, tc.unit, tc.from, tc.to, got, tc.want)
+			continue
+		}
+		for i, w := range got {
+			if !w.start.Equal(tc.want[i].start) || !w.end.Equal(tc.want[i].end) || w.end.Location() != vienna {
+				t.Errorf("%s/%s window %d is %v, want %v", tc.kind, tc.unit, i, w, tc.want[i])
+			}
+		}
+	}
+}
+
+// backfillAPI answers the data period and the energy details of site 1 and
+// records the requested start times of the energy.
+type backfillAPI struct {
+	lock    sync.Mutex
+	start   time.Time
+	periods int
+	starts  []string
+}
+
+func (ba *backfillAPI) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+	switch rq.URL.Path {
+	case "/site/1/dataPeriod.json":
+		ba.periods++
+		fmt.Fprintf(rw, `{"dataPeriod":{"startDate":%q,"endDate":null}}`, ba.start.Format("2006-01-02"))
+	case "/site/1/energyDetails.json":
+		start := rq.URL.Query().Get("startTime")
+		ba.starts = append(ba.starts, start)
+		fmt.Fprintf(rw, `{"energyDetails":{"timeUnit":"QUARTER_OF_AN_HOUR","unit":"Wh","meters":[{"type":"Production","values":[{"date":%q,"value":%d}]}]}}`, start, len(ba.starts))
+	default:
+		http.NotFound(rw, rq)
+	}
+}
+
+func TestRunBackfill(t *testing.T) {
+	defer func(kinds []string, budget int, zone string) {
+		backfillKinds, backfillBudget, solaredge.SiteZone = kinds, budget, zone
+	}(backfillKinds, backfillBudget, solaredge.SiteZone)
+	backfillKinds = []string{"energy"}
+	solaredge.SiteZone = "UTC"
+
+	now := time.Now().UTC()
+	api := &backfillAPI{start: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -75)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	sc, err := solaredge.SiteFromIDs("key", "1", solaredge.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	hs, err := history.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := filepath.Join(dir, "backfill-1.json")
+	windows := backfillWindows("energy", solaredge.Quarter_Of_An_Hour, api.start, now)
+	if len(windows) < 3 {
+		t.Fatalf("only %d windows since %v", len(windows), api.start)
+	}
+
+	// the data period and two windows fit into the budget
+	backfillBudget = 3
+	state, err := loadBackfillState(cp, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runBackfill(sc, hs, nil, state, cp, solaredge.Quarter_Of_An_Hour); !errors.Is(err, errBudgetExhausted) {
+		t.Fatalf("backfill returned %v, want an exhausted budget", err)
+	}
+	if api.periods != 1 || len(api.starts) != 2 {
+		t.Fatalf("backfill queried %d periods and %d windows, want 1 and 2", api.periods, len(api.starts))
+	}
+
+	// the next run resumes at the checkpoint of the same day
+	state, err = loadBackfillState(cp, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Calls != 3 || !state.Done["energy"].Equal(windows[1].end) || !state.Start.Equal(api.start) {
+		t.Fatalf("unexpected checkpoint %+v", state)
+	}
+	if err := runBackfill(sc, hs, nil, state, cp, solaredge.Quarter_Of_An_Hour); !errors.Is(err, errBudgetExhausted) {
+		t.Fatalf("backfill with a used budget returned %v", err)
+	}
+	if len(api.starts) != 2 {
+		t.Fatalf("backfill queried %d windows with a used budget", len(api.starts))
+	}
+	backfillBudget = 100
+	if err := runBackfill(sc, hs, nil, state, cp, solaredge.Quarter_Of_An_Hour); err != nil {
+		t.Fatal(err)
+	}
+	if api.periods != 1 || len(api.starts) != len(windows) {
+		t.Fatalf("backfill queried %d periods and %d windows, want 1 and %d", api.periods, len(api.starts), len(windows))
+	}
+	for i, w := range windows {
+		if want := w.start.Format("2006-01-02 15:04:05"); api.starts[i] != want {
+			t.Errorf("window %d starts at %s, want %s", i, api.starts[i], want)
+		}
+	}
+	values, err := hs.Meter("1", history.KindEnergy, "Production", time.Time{}, time.Time{})
+	if err != nil || len(values) != len(windows) {
+		t.Errorf("history has %d values, want %d: %v", len(values), len(windows), err)
+	}
+
+	// another run only loads the data since the last run
+	last := state.Done["energy"]
+	if err := runBackfill(sc, hs, nil, state, cp, solaredge.Quarter_Of_An_Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(api.starts) - len(windows); n > 1 || n == 1 && api.starts[len(windows)] != last.Format("2006-01-02 15:04:05") {
+		t.Errorf("finished backfill queried %v", api.starts[len(windows):])
+	}
+}
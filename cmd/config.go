@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gitlab.com/ulrichSchreiner/solaredge/alert"
+	"gitlab.com/ulrichSchreiner/solaredge/config"
+)
+
+var (
+	configFile string
+	// siteZones are the timezones of the sites in the config, they win over the
+	// timezones of the API
+	siteZones = make(map[string]string)
+	// alertConfig contains the alert rules and webhooks of the config
+	alertConfig *alert.Config
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "commands for the configuration file",
+	}
+	configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "checks the configuration file and lists its profiles",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := validateConfig(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "the configuration file, "+config.DefaultPath()+" if empty")
+	rootCmd.PersistentFlags().String("profile", "", "the profile of the configuration file")
+	_ = viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	configCmd.AddCommand(configValidateCmd)
+}
+
+// configPath returns the configuration file. The default file is optional, so
+// it is only returned if it exists.
+func configPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	p := config.DefaultPath()
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// setFlag sets the value of a flag which is not given on the command line.
+// Empty values keep the default.
+func setFlag(fs *pflag.FlagSet, name string, values ...string) error {
+	f := fs.Lookup(name)
+	if f == nil || f.Changed || len(values) == 0 || values[0] == "" {
+		return nil
+	}
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return sv.Replace(values)
+	}
+	if err := f.Value.Set(values[0]); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", values[0], name, err)
+	}
+	return nil
+}
+
+func duration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func number(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// applyConfig uses the selected profile of the configuration file for all
+// settings which are not given as flags or environment variables.
+func applyConfig() error {
+	path := configPath()
+	if path == "" {
+		return nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	_, p, err := cfg.Select(viper.GetString("profile"))
+	if err != nil {
+		return err
+	}
+
+	// the viper keys can also be set by the environment, which wins over the file
+	values := map[string]any{}
+	for k, v := range map[string]string{
+		"apikey":   p.APIKey,
+		"baseurl":  p.BaseURL,
+		"siteid":   strings.Join(p.SiteIDs(), ","),
+		"timezone": p.Zone(),
+	} {
+		if v != "" {
+			values[k] = v
+		}
+	}
+	if m := p.Outputs.MQTT; m != nil && m.Password != "" {
+		values["mqtt_password"] = m.Password
+	}
+	if in := p.Outputs.Influx; in != nil {
+		if in.Password != "" {
+			values["influx_password"] = in.Password
+		}
+		if in.Token != "" {
+			values["influx_token"] = in.Token
+		}
+	}
+	if err := viper.MergeConfigMap(values); err != nil {
+		return fmt.Errorf("cannot apply config: %w", err)
+	}
+
+	var coords, modbusAddrs []string
+	for _, s := range p.Sites {
+		if s.Coordinates != "" {
+			coords = append(coords, s.ID+"="+s.Coordinates)
+		}
+		if s.Modbus != "" {
+			modbusAddrs = append(modbusAddrs, s.ID+"="+s.Modbus)
+		}
+		if s.Timezone != "" {
+			siteZones[s.ID] = s.Timezone
+		}
+	}
+	// the zone of the sites is also the zone of the timestamps, so a flag or an
+	// environment variable must not change it
+	for id, z := range siteZones {
+		if tz := viper.GetString("timezone"); tz != z {
+			return fmt.Errorf("the timezone %q differs from the timezone %q of site %s", tz, z, id)
+		}
+	}
+	sv := p.Serve
+	serveFlags := map[string][]string{
+		"listen":            {sv.Listen},
+		"flow":              {duration(sv.Flow)},
+		"poll":              {duration(sv.Poll)},
+		"budget":            {number(sv.Budget)},
+		"fill-budget":       {number(sv.FillBudget)},
+		"endpoint":          sv.Endpoints,
+		"day-window":        {sv.DayWindow},
+		"sunrise-margin":    {duration(sv.SunriseMargin)},
+		"sunset-margin":     {duration(sv.SunsetMargin)},
+		"history":           {sv.History},
+		"history-retention": {duration(sv.HistoryRetention)},
+		"coordinates":       coords,
+		"tls-cert":          {sv.TLSCert},
+		"tls-key":           {sv.TLSKey},
+		"auth-file":         {sv.AuthFile},
+		"state":             {sv.State},
+		"stale-after":       {duration(sv.StaleAfter)},
+		"stale-gauges":      {sv.StaleGauges},
+		"modbus":            modbusAddrs,
+		"modbus-unit":       {number(sv.ModbusUnit)},
+		"modbus-interval":   {duration(sv.ModbusInterval)},
+		"modbus-listen":     {sv.ModbusListen},
+	}
+	if sv.AllSites {
+		serveFlags["all-sites"] = []string{"true"}
+	}
+	if sv.ModbusInvertMeter {
+		serveFlags["modbus-invert-meter"] = []string{"true"}
+	}
+	if sv.ModbusListenInvertMeter {
+		serveFlags["modbus-listen-invert-meter"] = []string{"true"}
+	}
+	if m := p.Outputs.MQTT; m != nil {
+		serveFlags["mqtt-broker"] = []string{m.Broker}
+		serveFlags["mqtt-username"] = []string{m.Username}
+		serveFlags["mqtt-client-id"] = []string{m.ClientID}
+		serveFlags["mqtt-topic"] = []string{m.Topic}
+		serveFlags["mqtt-availability-topic"] = []string{m.AvailabilityTopic}
+		serveFlags["mqtt-discovery-prefix"] = []string{m.DiscoveryPrefix}
+	}
+	rootFlags := map[string][]string{}
+	if in := p.Outputs.Influx; in != nil {
+		rootFlags["influx-url"] = []string{in.URL}
+		rootFlags["influx-database"] = []string{in.Database}
+		rootFlags["influx-retention-policy"] = []string{in.RetentionPolicy}
+		rootFlags["influx-username"] = []string{in.Username}
+		rootFlags["influx-org"] = []string{in.Org}
+		rootFlags["influx-bucket"] = []string{in.Bucket}
+		rootFlags["influx-buffer"] = []string{in.Buffer}
+	}
+	for fs, flags := range map[*pflag.FlagSet]map[string][]string{
+		serveCmd.PersistentFlags(): serveFlags,
+		rootCmd.PersistentFlags():  rootFlags,
+	} {
+		for name, v := range flags {
+			if err := setFlag(fs, name, v...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(p.Alerts) > 0 {
+		alertConfig = p.AlertConfig()
+	}
+	return nil
+}
+
+// validateConfig loads the configuration file and prints its profiles or all
+// problems.
+func validateConfig() error {
+	path := configPath()
+	if path == "" {
+		return fmt.Errorf("no config file, create %s or use --config", config.DefaultPath())
+	}
+	cfg, err := config.Load(path)
+	var errs config.Errors
+	if errors.As(err, &errs) {
+		fmt.Printf("%s is invalid:\n", path)
+		for _, e := range errs {
+			fmt.Printf("  - %s\n", e)
+		}
+		return fmt.Errorf("%d problems found", len(errs))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is valid\n", path)
+	for _, n := range cfg.Names() {
+		p := cfg.Profiles[n]
+		def := ""
+		if n == cfg.Profile || len(cfg.Profiles) == 1 {
+			def = " (default)"
+		}
+		fmt.Printf("  profile %s%s: %d sites, %d alert rules\n", n, def, len(p.Sites), len(p.Alerts))
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles contains the static files of the dashboard, which only uses the
+// other endpoints of serve.
+//
+//go:embed web
+var webFiles embed.FS
+
+// dashboard serves the files of the dashboard at /. All other unknown paths are
+// answered with 404.
+func dashboard() http.Handler {
+	sub, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/serveapi"
+)
+
+var (
+	readyMaxAge     time.Duration
+	shutdownTimeout time.Duration
+)
+
+func init() {
+	serveCmd.PersistentFlags().DurationVar(&readyMaxAge, "ready-max-age", 2*time.Hour, "the service is not ready if the last successful fetch of a site is older, never if 0")
+	serveCmd.PersistentFlags().DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "the time to finish the running requests on shutdown")
+}
+
+// health checks that the data of the site is not older than maxAge and that the
+// API key is accepted.
+func (ss *siteService) health(now time.Time, maxAge time.Duration) serveapi.SiteHealth {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	res := serveapi.SiteHealth{ID: ss.site.ID(), Name: ss.staticDetails.Name}
+	last := ss.flowFetched
+	if ss.overviewFetched.After(last) {
+		last = ss.overviewFetched
+	}
+	if !last.IsZero() {
+		res.LastFetch = &last
+	}
+	switch authErr := ss.authError(); {
+	case authErr != nil:
+		res.Reason = fmt.Sprintf("authentication failed: %v", authErr)
+	case last.IsZero():
+		res.Reason = "no data fetched yet"
+	case maxAge > 0 && now.Sub(last) > maxAge:
+		res.Reason = fmt.Sprintf("last successful fetch %s ago", now.Sub(last).Round(time.Second))
+	default:
+		res.Ready = true
+	}
+	return res
+}
+
+// authError returns the rejected API key of an endpoint whose last call failed.
+func (ss *siteService) authError() error {
+	names := make([]string, 0, len(ss.lastErrors))
+	for name := range ss.lastErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ss.lastErrors[name]; solaredge.IsAuthError(err) {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// healthz answers as long as the service is running.
+func (ses *solaredgeService) healthz(rw http.ResponseWriter, rq *http.Request) {
+	rw.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]string{"status": "ok"})
+}
+
+// readyz answers with 503 if the data of a site is stale, the API key is
+// rejected or the service is shutting down.
+func (ses *solaredgeService) readyz(rw http.ResponseWriter, rq *http.Request) {
+	res := serveapi.Readiness{Ready: true}
+	now := time.Now()
+	for _, ss := range ses.sites {
+		h := ss.health(now, readyMaxAge)
+		res.Ready = res.Ready && h.Ready
+		res.Sites = append(res.Sites, h)
+	}
+	select {
+	case <-ses.stop:
+		res.Ready = false
+	default:
+	}
+
+	rw.Header().Add("content-type", "application/json")
+	if !res.Ready {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(rw).Encode(res)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/export"
+	"gitlab.com/ulrichSchreiner/solaredge/history"
+)
+
+const (
+	// energyGap is the longest time without energy values which is not filled
+	// from the API, the energy details have a value every 15 minutes
+	energyGap = time.Hour
+	// energyReference is the meter which is checked for missing values
+	energyReference = "Production"
+)
+
+var (
+	fillBudget int
+
+	errFillBudget = errors.New("fill budget exhausted")
+
+	historyTimePatterns = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+	historyContentTypes = map[export.Format]string{
+		export.CSV:     "text/csv",
+		export.JSON:    "application/json",
+		export.JSONL:   "application/x-ndjson",
+		export.Parquet: "application/vnd.apache.parquet",
+	}
+)
+
+func init() {
+	serveCmd.PersistentFlags().IntVar(&fillBudget, "fill-budget", 10, "the API calls per site and day which may be used to fill the history on demand")
+}
+
+// historyFill counts the API calls which fill the history of a site.
+type historyFill struct {
+	lock  sync.Mutex
+	day   string
+	calls int
+	// start is the first day with data of the site, it is queried once
+	start time.Time
+}
+
+// call counts an API call against the daily fill budget.
+func (hf *historyFill) call() error {
+	today := time.Now().Format("2006-01-02")
+	if hf.day != today {
+		hf.day = today
+		hf.calls = 0
+	}
+	if hf.calls >= fillBudget {
+		return errFillBudget
+	}
+	hf.calls++
+	return nil
+}
+
+// historyQuery contains the parameters of a history request.
+type historyQuery struct {
+	from   time.Time
+	to     time.Time
+	step   time.Duration
+	format export.Format
+	layout export.Layout
+	fill   bool
+}
+
+// parseHistoryTime parses a RFC3339 time or a date with an optional time in the
+// zone of the sites.
+func parseHistoryTime(s string, loc *time.Location) (time.Time, error) {
+	for _, p := range historyTimePatterns {
+		if t, err := time.ParseInLocation(p, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q, use RFC3339 or YYYY-MM-DD[ hh:mm:ss]", s)
+}
+
+// parseHistoryQuery returns the parameters of the request. The default range is
+// the last 24 hours without resampling as JSON.
+func parseHistoryQuery(rq *http.Request, loc *time.Location) (*historyQuery, error) {
+	q := rq.URL.Query()
+	res := &historyQuery{to: time.Now().In(loc), format: export.JSON, layout: export.Wide}
+	var err error
+	if s := q.Get("to"); s != "" {
+		if res.to, err = parseHistoryTime(s, loc); err != nil {
+			return nil, err
+		}
+	}
+	res.from = res.to.Add(-24 * time.Hour)
+	if s := q.Get("from"); s != "" {
+		if res.from, err = parseHistoryTime(s, loc); err != nil {
+			return nil, err
+		}
+	}
+	if !res.from.Before(res.to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if s := q.Get("step"); s != "" {
+		if res.step, err = time.ParseDuration(s); err != nil || res.step <= 0 {
+			return nil, fmt.Errorf("invalid step %q, use a duration like 15m or 1h", s)
+		}
+	}
+	switch f := q.Get("format"); {
+	case f != "":
+		if res.format, err = export.ParseFormat(f); err != nil {
+			return nil, err
+		}
+	case strings.Contains(rq.Header.Get("accept"), "text/csv"):
+		res.format = export.CSV
+	}
+	if s := q.Get("layout"); s != "" {
+		if res.layout, err = export.ParseLayout(s); err != nil {
+			return nil, err
+		}
+	}
+	res.fill = fillRequested(q)
+	return res, nil
+}
+
+// fillRequested returns true if the query asks to fill the energy history from
+// the API.
+func fillRequested(q url.Values) bool {
+	return q.Get("fill") == "true" || q.Get("fill") == "1"
+}
+
+// flowSeries returns the flowdata and the load of the stored powerflows.
+func flowSeries(samples []history.PowerFlowSample, loc *time.Location) *export.Series {
+	res := &export.Series{KeyColumn: "key", NameColumn: "metric"}
+	for _, s := range samples {
+		t := s.Time.In(loc)
+		add := func(name string, v float64) {
+			res.Observations = append(res.Observations, export.Observation{Time: t, Name: name, Value: v})
+		}
+		fd := genFlowData(s.Flow)
+		add("pv", fd.PV)
+		add("grid", fd.Grid)
+		add("load", s.Flow.Load.CurrentPower*unitFactor(s.Flow.Unit))
+		if s.Flow.Storage != nil {
+			add("battery", fd.Battery)
+			add("soc", fd.SoC)
+		}
+	}
+	return res
+}
+
+// overviewSeries returns the energy and the current power of the stored
+// overviews at their last update time.
+func overviewSeries(ovs []solaredge.OverviewData, loc *time.Location) *export.Series {
+	res := &export.Series{KeyColumn: "key", NameColumn: "metric"}
+	for _, ov := range ovs {
+		t := time.Time(ov.LastUpdateTime).In(loc)
+		add := func(name string, v float64) {
+			res.Observations = append(res.Observations, export.Observation{Time: t, Name: name, Value: v})
+		}
+		add("lifetimeEnergy", ov.LifetimeData.Energy)
+		add("yearEnergy", ov.LastYearData.Energy)
+		add("monthEnergy", ov.LastMonthData.Energy)
+		add("dayEnergy", ov.LastDayData.Energy)
+		add("currentPower", ov.CurrentPower.Power)
+	}
+	return res
+}
+
+// energySeries returns the stored values of all energy meters of the site.
+func (ss *siteService) energySeries(from, to time.Time, loc *time.Location) (*export.Series, error) {
+	names, err := ss.history.Series(ss.site.ID())
+	if err != nil {
+		return nil, err
+	}
+	res := &export.Series{KeyColumn: "key", NameColumn: "meter"}
+	for _, n := range names {
+		meter := strings.TrimPrefix(n, string(history.KindEnergy)+"/")
+		if meter == n {
+			continue
+		}
+		values, err := ss.history.Meter(ss.site.ID(), history.KindEnergy, meter, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			res.Observations = append(res.Observations, export.Observation{Time: v.Time.In(loc), Name: meter, Value: v.Value})
+		}
+	}
+	return res, nil
+}
+
+// alignStep returns the begin of the step which contains t. The steps start at
+// midnight, so an hourly step begins at a full hour and steps of whole days
+// begin at the midnight of the day of t.
+func alignStep(t time.Time, step time.Duration) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if step%(24*time.Hour) == 0 {
+		return midnight
+	}
+	return midnight.Add(t.Sub(midnight) / step * step)
+}
+
+// energyGaps returns the ranges in [from, to) without energy values.
+func energyGaps(values []history.MeterSample, from, to time.Time) [][2]time.Time {
+	var res [][2]time.Time
+	last := from
+	for _, v := range values {
+		if v.Time.Sub(last) > energyGap {
+			res = append(res, [2]time.Time{last, v.Time})
+		}
+		last = v.Time
+	}
+	if to.Sub(last) > energyGap {
+		res = append(res, [2]time.Time{last, to})
+	}
+	return res
+}
+
+// fillEnergy loads the missing energy values in [from, to) from the API. It
+// stops when the fill budget of the day is used up.
+func (ss *siteService) fillEnergy(from, to time.Time, loc *time.Location) error {
+	ss.fill.lock.Lock()
+	defer ss.fill.lock.Unlock()
+
+	if ss.fill.start.IsZero() {
+		if err := ss.fill.call(); err != nil {
+			return err
+		}
+		dp, err := ss.site.DataPeriod()
+		if err != nil {
+			return fmt.Errorf("cannot query data period: %w", err)
+		}
+		ss.fill.start = time.Time(dp.StartDate)
+	}
+	if from.Before(ss.fill.start) {
+		from = ss.fill.start
+	}
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	if !from.Before(to) {
+		return nil
+	}
+
+	values, err := ss.history.Meter(ss.site.ID(), history.KindEnergy, energyReference, from, to)
+	if err != nil {
+		return err
+	}
+	for _, gap := range energyGaps(values, from, to) {
+		for _, w := range backfillWindows("energy", solaredge.Quarter_Of_An_Hour, gap[0].In(loc), gap[1]) {
+			if err := ss.fill.call(); err != nil {
+				return err
+			}
+			det, err := ss.site.EnergyDetails(solaredge.Quarter_Of_An_Hour, w.start, w.end)
+			if err != nil {
+				return fmt.Errorf("cannot query energy from %s to %s: %w", w.start, w.end, err)
+			}
+			n, err := ss.history.AddMeters(ss.site.ID(), history.KindEnergy, det.Meters)
+			if err != nil {
+				return err
+			}
+			log.Info().
+				Str("site", ss.site.ID()).
+				Time("start", w.start).
+				Time("end", w.end).
+				Int("values", n).
+				Msg("filled energy history")
+		}
+	}
+	return nil
+}
+
+// siteHistory answers with the stored flow, energy or overview of the site in
+// the requested range.
+func (ss *siteService) siteHistory(rw http.ResponseWriter, rq *http.Request, kind string) {
+	if ss.history == nil {
+		http.Error(rw, "the history is disabled, start serve with --history", http.StatusNotFound)
+		return
+	}
+	loc, err := time.LoadLocation(solaredge.SiteZone)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q, err := parseHistoryQuery(rq, loc)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var series *export.Series
+	agg := export.Mean
+	switch kind {
+	case "flow":
+		samples, herr := ss.history.PowerFlows(ss.site.ID(), q.from, q.to)
+		series, err = flowSeries(samples, loc), herr
+	case "overview":
+		ovs, herr := ss.history.Overviews(ss.site.ID(), q.from, q.to)
+		series, err = overviewSeries(ovs, loc), herr
+		agg = export.Last
+	case "energy":
+		if q.fill {
+			// the stored values are answered even if the fill fails
+			if ferr := ss.fillEnergy(q.from, q.to, loc); ferr != nil {
+				log.Error().Err(ferr).Str("site", ss.site.ID()).Msg("cannot fill energy history")
+				rw.Header().Set("x-fill-error", ferr.Error())
+			}
+		}
+		series, err = ss.energySeries(q.from, q.to, loc)
+		agg = export.Sum
+	default:
+		http.NotFound(rw, rq)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if q.step > 0 {
+		series = series.Resample(alignStep(q.from, q.step), q.step, agg)
+	}
+
+	rw.Header().Set("content-type", historyContentTypes[q.format])
+	if err := series.Table(q.layout).Write(rw, q.format); err != nil {
+		log.Error().Err(err).Str("site", ss.site.ID()).Msg("cannot write history")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/export"
+	"gitlab.com/ulrichSchreiner/solaredge/history"
+)
+
+func TestParseHistoryQuery(t *testing.T) {
+	wien := mustZone(t, "Europe/Vienna")
+	for _, tc := range []struct {
+		query    string
+		accept   string
+		from, to time.Time
+		step     time.Duration
+		format   export.Format
+		layout   export.Layout
+		fill     bool
+		err      bool
+	}{
+		{query: "from=2023-03-26&to=2023-03-27", from: time.Date(2023, 3, 26, 0, 0, 0, 0, wien), to: time.Date(2023, 3, 27, 0, 0, 0, 0, wien), format: export.JSON, layout: export.Wide},
+		// the day of the change to daylight saving time has 23 hours
+		{query: "to=2023-03-27", from: time.Date(2023, 3, 25, 23, 0, 0, 0, wien), to: time.Date(2023, 3, 27, 0, 0, 0, 0, wien), format: export.JSON, layout: export.Wide},
+		{query: "from=2023-05-01+12:00:00&to=2023-05-01T18:30:00", from: time.Date(2023, 5, 1, 12, 0, 0, 0, wien), to: time.Date(2023, 5, 1, 18, 30, 0, 0, wien), format: export.JSON, layout: export.Wide},
+		{query: "from=2023-05-01T10:00:00Z&to=2023-05-01T12:00:00%2B02:00", from: time.Date(2023, 5, 1, 12, 0, 0, 0, wien), to: time.Date(2023, 5, 1, 12, 0, 0, 0, wien), err: true},
+		{query: "from=2023-05-01T08:00:00Z&to=2023-05-02&step=1h&format=csv&layout=long&fill=1", from: time.Date(2023, 5, 1, 10, 0, 0, 0, wien), to: time.Date(2023, 5, 2, 0, 0, 0, 0, wien), step: time.Hour, format: export.CSV, layout: export.Long, fill: true},
+		{query: "from=2023-05-01&to=2023-05-08&step=168h&fill=true", from: time.Date(2023, 5, 1, 0, 0, 0, 0, wien), to: time.Date(2023, 5, 8, 0, 0, 0, 0, wien), step: 168 * time.Hour, format: export.JSON, layout: export.Wide, fill: true},
+		{query: "from=2023-05-01&to=2023-05-02&fill=yes", accept: "text/csv, */*", from: time.Date(2023, 5, 1, 0, 0, 0, 0, wien), to: time.Date(2023, 5, 2, 0, 0, 0, 0, wien), format: export.CSV, layout: export.Wide},
+		// the format parameter wins over the accept header
+		{query: "from=2023-05-01&to=2023-05-02&format=jsonl", accept: "text/csv", from: time.Date(2023, 5, 1, 0, 0, 0, 0, wien), to: time.Date(2023, 5, 2, 0, 0, 0, 0, wien), format: export.JSONL, layout: export.Wide},
+		{query: "from=2023-05-02&to=2023-05-01", err: true},
+		{query: "from=yesterday", err: true},
+		{query: "to=2023-13-01", err: true},
+		{query: "step=0s", err: true},
+		{query: "step=-1h", err: true},
+		{query: "step=hourly", err: true},
+		{query: "format=xml", err: true},
+		{query: "layout=tall", err: true},
+	} {
+		rq := httptest.NewRequest(http.MethodGet, "/history/flow?"+tc.query, nil)
+		if tc.accept != "" {
+			rq.Header.Set("accept", tc.accept)
+		}
+		q, err := parseHistoryQuery(rq, wien)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: query is valid: %+v", tc.query, *q)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if !q.from.Equal(tc.from) || !q.to.Equal(tc.to) || q.step != tc.step || q.format != tc.format || q.layout != tc.layout || q.fill != tc.fill {
+			t.Errorf("%s: query is %+v", tc.query, *q)
+		}
+	}
+
+	// the default range are the last 24 hours
+	q, err := parseHistoryQuery(httptest.NewRequest(http.MethodGet, "/history/flow", nil), wien)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !within(q.to, time.Now(), time.Minute) || q.to.Sub(q.from) != 24*time.Hour || q.step != 0 || q.fill {
+		t.Errorf("default query is %+v", *q)
+	}
+}
+
+func TestAlignStep(t *testing.T) {
+	wien := mustZone(t, "Europe/Vienna")
+	at := func(m time.Month, d, h, min int) time.Time { return time.Date(2023, m, d, h, min, 0, 0, wien) }
+	for _, tc := range []struct {
+		t    time.Time
+		step time.Duration
+		want time.Time
+	}{
+		{at(5, 1, 10, 40), 15 * time.Minute, at(5, 1, 10, 30)},
+		{at(5, 1, 10, 40), time.Hour, at(5, 1, 10, 0)},
+		{at(5, 1, 10, 40), 3 * time.Hour, at(5, 1, 9, 0)},
+		{at(5, 1, 10, 40), 24 * time.Hour, at(5, 1, 0, 0)},
+		// steps longer than a day start at midnight of the first day
+		{at(5, 3, 10, 40), 7 * 24 * time.Hour, at(5, 3, 0, 0)},
+		{at(5, 3, 0, 0), 48 * time.Hour, at(5, 3, 0, 0)},
+		// the steps are counted from midnight in real time, 03:30 is two
+		// hours after midnight on the day of the change to summer time
+		{at(3, 26, 3, 30), time.Hour, at(3, 26, 3, 0)},
+		{at(3, 26, 4, 30), 2 * time.Hour, at(3, 26, 3, 0)},
+		// the second 02:30 of the change to winter time
+		{at(10, 29, 2, 30).Add(time.Hour), time.Hour, at(10, 29, 2, 0).Add(time.Hour)},
+		{at(10, 29, 23, 59), 24 * time.Hour, at(10, 29, 0, 0)},
+	} {
+		if got := alignStep(tc.t, tc.step); !got.Equal(tc.want) {
+			t.Errorf("alignStep(%v, %v) is %v, want %v", tc.t, tc.step, got, tc.want)
+		}
+	}
+}
+
+func TestEnergyGaps(t *testing.T) {
+	t0 := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	samples := func(mins ...int) []history.MeterSample {
+		var res []history.MeterSample
+		for _, m := range mins {
+			res = append(res, history.MeterSample{Time: at(m), Value: 1})
+		}
+		return res
+	}
+	for _, tc := range []struct {
+		name     string
+		values   []history.MeterSample
+		from, to int
+		want     string
+	}{
+		{"empty", nil, 0, 600, "[0-600]"},
+		{"complete", samples(0, 15, 30, 45, 60), 0, 75, "[]"},
+		// a gap of exactly an hour is not filled
+		{"hour", samples(0, 60, 120), 0, 180, "[]"},
+		{"inner", samples(0, 15, 120, 135), 0, 150, "[15-120]"},
+		{"edges", samples(90, 105), 0, 300, "[0-90 105-300]"},
+		{"short range", nil, 0, 60, "[]"},
+	} {
+		var got []string
+		for _, g := range energyGaps(tc.values, at(tc.from), at(tc.to)) {
+			got = append(got, fmt.Sprintf("%d-%d", int(g[0].Sub(t0)/time.Minute), int(g[1].Sub(t0)/time.Minute)))
+		}
+		if s := "[" + strings.Join(got, " ") + "]"; s != tc.want {
+			t.Errorf("%s: gaps are %s, want %s", tc.name, s, tc.want)
+		}
+	}
+}
+
+// fillAPI answers the data period and the energy details with a value every
+// quarter of an hour.
+type fillAPI struct {
+	lock    sync.Mutex
+	start   string
+	periods int
+	windows []string
+}
+
+func (fa *fillAPI) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+	fa.lock.Lock()
+	defer fa.lock.Unlock()
+	switch rq.URL.Path {
+	case "/site/1/dataPeriod.json":
+		fa.periods++
+		fmt.Fprintf(rw, `{"dataPeriod":{"startDate":%q}}`, fa.start)
+	case "/site/1/energyDetails.json":
+		loc, _ := time.LoadLocation(solaredge.SiteZone)
+		q := rq.URL.Query()
+		start, _ := time.ParseInLocation("2006-01-02 15:04:05", q.Get("startTime"), loc)
+		end, _ := time.ParseInLocation("2006-01-02 15:04:05", q.Get("endTime"), loc)
+		fa.windows = append(fa.windows, q.Get("startTime")+" - "+q.Get("endTime"))
+		var values []string
+		for t := start; t.Before(end); t = t.Add(15 * time.Minute) {
+			values = append(values, fmt.Sprintf(`{"date":%q,"value":1}`, t.Format("2006-01-02 15:04:05")))
+		}
+		fmt.Fprintf(rw, `{"energyDetails":{"timeUnit":"QUARTER_OF_AN_HOUR","unit":"Wh","meters":[{"type":"Production","values":[%s]}]}}`, strings.Join(values, ","))
+	default:
+		http.NotFound(rw, rq)
+	}
+}
+
+func TestFillEnergy(t *testing.T) {
+	defer func(budget int, zone string) { fillBudget, solaredge.SiteZone = budget, zone }(fillBudget, solaredge.SiteZone)
+	solaredge.SiteZone = "Europe/Vienna"
+	wien := mustZone(t, solaredge.SiteZone)
+	day := func(m time.Month, d int) time.Time { return time.Date(2023, m, d, 0, 0, 0, 0, wien) }
+
+	api := &fillAPI{start: "2023-01-10"}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	sc, err := solaredge.SiteFromIDs("key", "1", solaredge.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &siteService{site: sc, history: hs}
+
+	// the data period and two of the three months fit into the budget, the
+	// range before the first day with data is not loaded
+	fillBudget = 3
+	if err := ss.fillEnergy(day(1, 1), day(3, 10), wien); !errors.Is(err, errFillBudget) {
+		t.Fatalf("fill returned %v, want an exhausted budget", err)
+	}
+	want := []string{
+		"2023-01-10 00:00:00 - 2023-02-01 00:00:00",
+		"2023-02-01 00:00:00 - 2023-03-01 00:00:00",
+	}
+	if api.periods != 1 || fmt.Sprint(api.windows) != fmt.Sprint(want) || ss.fill.calls != 3 {
+		t.Fatalf("fill queried %d periods and %v with %d calls", api.periods, api.windows, ss.fill.calls)
+	}
+	// the budget of the day is used up
+	if err := ss.fillEnergy(day(1, 1), day(3, 10), wien); !errors.Is(err, errFillBudget) || len(api.windows) != 2 {
+		t.Fatalf("fill with a used budget returned %v after %d windows", err, len(api.windows))
+	}
+
+	// only the missing range is loaded, the data period is known
+	fillBudget = 10
+	if err := ss.fillEnergy(day(1, 1), day(3, 10), wien); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want,
+		"2023-02-28 23:45:00 - 2023-03-01 00:00:00",
+		"2023-03-01 00:00:00 - 2023-03-10 00:00:00",
+	)
+	if api.periods != 1 || fmt.Sprint(api.windows) != fmt.Sprint(want) || ss.fill.calls != 5 {
+		t.Fatalf("fill queried %d periods and %v with %d calls", api.periods, api.windows, ss.fill.calls)
+	}
+	values, err := hs.Meter("1", history.KindEnergy, "Production", day(1, 10), day(3, 10))
+	if err != nil || len(values) != int(day(3, 10).Sub(day(1, 10))/(15*time.Minute)) {
+		t.Errorf("history has %d values: %v", len(values), err)
+	}
+
+	// a complete range and a range in the future need no calls
+	if err := ss.fillEnergy(day(2, 1), day(3, 1), wien); err != nil || ss.fill.calls != 5 {
+		t.Errorf("fill of a complete range used %d calls: %v", ss.fill.calls-5, err)
+	}
+	future := time.Now().AddDate(1, 0, 0)
+	if err := ss.fillEnergy(future, future.AddDate(0, 0, 1), wien); err != nil || ss.fill.calls != 5 {
+		t.Errorf("fill of the future used %d calls: %v", ss.fill.calls-5, err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/export"
+	"gitlab.com/ulrichSchreiner/solaredge/influx"
+)
+
+var (
+	influxURL             string
+	influxDatabase        string
+	influxRetentionPolicy string
+	influxUsername        string
+	influxOrg             string
+	influxBucket          string
+	influxBuffer          string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&influxURL, "influx-url", "", "write the data to the InfluxDB at this URL, e.g. http://localhost:8086")
+	rootCmd.PersistentFlags().StringVar(&influxDatabase, "influx-database", "", "the database of InfluxDB v1")
+	rootCmd.PersistentFlags().StringVar(&influxRetentionPolicy, "influx-retention-policy", "", "the retention policy of InfluxDB v1")
+	rootCmd.PersistentFlags().StringVar(&influxUsername, "influx-username", "", "the username of InfluxDB v1")
+	rootCmd.PersistentFlags().String("influx-password", "", "the password of InfluxDB v1")
+	rootCmd.PersistentFlags().StringVar(&influxOrg, "influx-org", "", "the organization of InfluxDB v2")
+	rootCmd.PersistentFlags().StringVar(&influxBucket, "influx-bucket", "", "the bucket of InfluxDB v2, selects the v2 API")
+	rootCmd.PersistentFlags().String("influx-token", "", "the API token of InfluxDB v2")
+	rootCmd.PersistentFlags().StringVar(&influxBuffer, "influx-buffer", "", "keep the points in this file while InfluxDB is not reachable")
+	_ = viper.BindPFlag("influx_password", rootCmd.PersistentFlags().Lookup("influx-password"))
+	_ = viper.BindPFlag("influx_token", rootCmd.PersistentFlags().Lookup("influx-token"))
+}
+
+// newInfluxWriter returns the writer of the influx flags or nil if no URL is
+// given.
+func newInfluxWriter() (*influx.Writer, error) {
+	if influxURL == "" {
+		return nil, nil
+	}
+	opts := []influx.Opt{
+		influx.WithBuffer(influxBuffer),
+		influx.WithErrorHandler(func(err error) {
+			log.Error().Err(err).Msg("cannot write to influx")
+		}),
+	}
+	if influxBucket != "" {
+		opts = append(opts, influx.WithV2(influxOrg, influxBucket, viper.GetString("influx_token")))
+	} else {
+		if influxDatabase == "" {
+			return nil, fmt.Errorf("InfluxDB needs --influx-database (v1) or --influx-bucket (v2)")
+		}
+		opts = append(opts, influx.WithV1(influxDatabase, influxRetentionPolicy, influxUsername, viper.GetString("influx_password")))
+	}
+	return influx.New(influxURL, opts...)
+}
+
+// powerFlowPoint returns the powerflow as a point. The powerflow has no
+// timestamp, so the time of the poll is used.
+func powerFlowPoint(siteid string, t time.Time, pf solaredge.PowerFlow) influx.Point {
+	fd := genFlowData(pf)
+	fields := map[string]any{
+		"pv":      fd.PV,
+		"grid":    fd.Grid,
+		"load":    pf.Load.CurrentPower * unitFactor(pf.Unit),
+		"battery": fd.Battery,
+	}
+	if pf.Storage != nil {
+		fields["soc"] = fd.SoC
+		fields["critical"] = pf.Storage.Critical
+	}
+	return influx.Point{
+		Measurement: "solaredge_powerflow",
+		Tags:        map[string]string{"site": siteid},
+		Fields:      fields,
+		Time:        t,
+	}
+}
+
+// overviewPoint returns the overview as a point at its last update time.
+func overviewPoint(siteid string, ov solaredge.OverviewData) influx.Point {
+	return influx.Point{
+		Measurement: "solaredge_overview",
+		Tags:        map[string]string{"site": siteid},
+		Fields: map[string]any{
+			"current_power":   ov.CurrentPower.Power,
+			"lifetime_energy": ov.LifetimeData.Energy,
+			"year_energy":     ov.LastYearData.Energy,
+			"month_energy":    ov.LastMonthData.Energy,
+			"day_energy":      ov.LastDayData.Energy,
+		},
+		Time: time.Time(ov.LastUpdateTime),
+	}
+}
+
+// seriesPoints returns the observations of a detail series as points of the
+// measurement solaredge_<name> with the times of solaredge.
+func seriesPoints(name, siteid string, s *export.Series) []influx.Point {
+	return influx.SeriesPoints("solaredge_"+name, map[string]string{"site": siteid}, s)
+}
+
+// writeLineProtocol writes the points in the line protocol.
+func writeLineProtocol(w io.Writer, points []influx.Point) error {
+	for _, p := range points {
+		if l := p.Line(); l != "" {
+			if _, err := fmt.Fprintln(w, l); err != nil {
+				return fmt.Errorf("cannot write line protocol: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// pushSeries writes the series to InfluxDB if it is configured.
+func pushSeries(name, siteid string, s *export.Series) {
+	iw, err := newInfluxWriter()
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot create influx writer")
+	}
+	if iw == nil {
+		return
+	}
+	iw.Write(seriesPoints(name, siteid, s)...)
+	if err := iw.Close(); err != nil {
+		log.Error().Err(err).Msg("cannot write to influx")
+	}
+}
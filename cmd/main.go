@@ -1,8 +1,11 @@
 package main
 
 func main() {
-	siteCmd.AddCommand(detailsCmd, inventoryCmd, storageData, powerDetails, energyDetails, powerflow, overview)
+	siteCmd.AddCommand(detailsCmd, inventoryCmd, storageData, powerDetails, energyDetails, powerflow, overview, dataPeriod, inverterData, watchCmd)
 	rootCmd.AddCommand(siteCmd)
 	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(backfillCmd)
+	rootCmd.AddCommand(modbusSimCmd)
+	rootCmd.AddCommand(configCmd)
 	Execute()
 }
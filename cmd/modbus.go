@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+	"gitlab.com/ulrichSchreiner/solaredge/schedule"
+	"gitlab.com/ulrichSchreiner/solaredge/sunspec"
+)
+
+var (
+	modbusAddrs       []string
+	modbusUnit        int
+	modbusInterval    time.Duration
+	modbusInvertMeter bool
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringArrayVar(&modbusAddrs, "modbus", nil, "read the powerflow from the inverter with SunSpec Modbus TCP at host:port for all sites or siteid=host:port, can be repeated")
+	serveCmd.PersistentFlags().IntVar(&modbusUnit, "modbus-unit", 1, "the modbus unit id of the inverters")
+	serveCmd.PersistentFlags().DurationVar(&modbusInterval, "modbus-interval", 5*time.Second, "the poll interval of the powerflow over modbus")
+	serveCmd.PersistentFlags().BoolVar(&modbusInvertMeter, "modbus-invert-meter", false, "the meter reports imported power as positive, SolarEdge meters report exported power as positive")
+}
+
+// modbusAddress returns the modbus address of the site; the address of a single
+// site wins over the address of all sites.
+func modbusAddress(siteid string) string {
+	var res string
+	for _, a := range modbusAddrs {
+		id, addr, ok := strings.Cut(a, "=")
+		if !ok {
+			if res == "" {
+				res = a
+			}
+			continue
+		}
+		if strings.TrimSpace(id) == siteid {
+			return strings.TrimSpace(addr)
+		}
+	}
+	return strings.TrimSpace(res)
+}
+
+// newDataSource returns the source of the powerflow of the site and the poll
+// interval of a local source. The API is the source of sites without a modbus
+// address, its polls are planned with the budget.
+func newDataSource(site *solaredge.SiteClient) (solaredge.DataSource, time.Duration, error) {
+	addr := modbusAddress(site.ID())
+	if addr == "" {
+		return site, 0, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, 0, fmt.Errorf("invalid modbus address of site %s: %w", site.ID(), err)
+	}
+	if modbusUnit < 1 || modbusUnit > 247 {
+		return nil, 0, fmt.Errorf("invalid modbus unit %d, must be between 1 and 247", modbusUnit)
+	}
+	if modbusInterval <= 0 {
+		return nil, 0, fmt.Errorf("invalid modbus interval %s", modbusInterval)
+	}
+	opts := []sunspec.ReaderOpt{sunspec.WithUnit(byte(modbusUnit))}
+	if !modbusInvertMeter {
+		opts = append(opts, sunspec.WithExportPositive())
+	}
+	log.Info().
+		Str("site", site.ID()).
+		Str("modbus", addr).
+		Int("unit", modbusUnit).
+		Dur("interval", modbusInterval).
+		Msg("reading powerflow with modbus")
+	return sunspec.NewReader(modbus.NewClient(addr), opts...), modbusInterval, nil
+}
+
+// localEndpoints removes the powerflow from the planned endpoints of a site
+// with a local source.
+func localEndpoints(eps []schedule.Endpoint) []schedule.Endpoint {
+	var res []schedule.Endpoint
+	for _, ep := range eps {
+		if ep.Name != "powerflow" {
+			res = append(res, ep)
+		}
+	}
+	return res
+}
+
+// pollSource polls the powerflow of a local source with its own interval, the
+// calls do not count against the quota of the API.
+func (ss *siteService) pollSource() {
+	ticker := time.NewTicker(ss.sourceInterval)
+	defer ticker.Stop()
+	for {
+		_ = ss.poll("powerflow")
+		select {
+		case <-ss.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
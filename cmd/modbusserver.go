@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge/metrics"
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+	"gitlab.com/ulrichSchreiner/solaredge/sunspec"
+)
+
+var (
+	modbusListen            string
+	modbusListenInvertMeter bool
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&modbusListen, "modbus-listen", "", "serve the flow of the sites as SunSpec inverter, meter and battery with Modbus TCP on this address, the n-th site answers as unit n")
+	serveCmd.PersistentFlags().BoolVar(&modbusListenInvertMeter, "modbus-listen-invert-meter", false, "the served meter reports imported power as positive instead of exported power like SolarEdge meters")
+}
+
+// sunspecValues are the values of a site for the SunSpec models.
+type sunspecValues struct {
+	inverter sunspec.Inverter
+	meter    sunspec.Meter
+	battery  *sunspec.Battery
+}
+
+// sunspecValues returns the current flow of the site as SunSpec values. A stale
+// flow is handled like the gauges of the metrics: the values are kept, they are
+// not implemented for nan or there are no values for blank. ok is false if
+// there are no values.
+func (ss *siteService) sunspecValues(now time.Time, mode metrics.StaleMode) (sunspecValues, bool) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	var res sunspecValues
+	if ss.flowFetched.IsZero() {
+		return res, false
+	}
+	stale := ss.flowFreshness(now).Stale
+	if stale && mode == metrics.BlankStale {
+		return res, false
+	}
+	fd := genFlowData(ss.currentPowerFlow)
+	hasStorage := ss.currentPowerFlow.Storage != nil
+	if stale && mode == metrics.NaNStale {
+		fd.PV, fd.Grid, fd.Battery, fd.SoC = math.NaN(), math.NaN(), math.NaN(), math.NaN()
+	}
+
+	// the battery is DC coupled, so it is part of the AC power of the inverter
+	res.inverter = sunspec.Inverter{
+		W:     fd.PV + fd.Battery,
+		DCW:   fd.PV + fd.Battery,
+		WH:    ss.currentOverview.LifetimeData.Energy,
+		State: sunspec.InverterSleeping,
+	}
+	if fd.PV > 0 {
+		res.inverter.State = sunspec.InverterMPPT
+	}
+	res.meter = sunspec.Meter{W: fd.Grid, WhImported: math.NaN(), WhExported: math.NaN()}
+	if !modbusListenInvertMeter {
+		res.meter.W = -fd.Grid
+	}
+	if hasStorage {
+		b := &sunspec.Battery{W: fd.Battery, SoC: fd.SoC, ChargeState: sunspec.ChargeHolding, WHRtg: math.NaN()}
+		switch {
+		case fd.Battery > 0:
+			b.ChargeState = sunspec.ChargeDischarging
+		case fd.Battery < 0:
+			b.ChargeState = sunspec.ChargeCharging
+		}
+		res.battery = b
+	}
+	return res, true
+}
+
+// modbusDevice is the SunSpec device of a site. The device is created again
+// when the site gets or loses its battery.
+type modbusDevice struct {
+	lock    sync.Mutex
+	site    *siteService
+	unit    byte
+	mode    metrics.StaleMode
+	device  *sunspec.Device
+	storage bool
+}
+
+// ReadHoldingRegisters sets the current values of the site and answers the
+// read. Without values the site answers like a gateway without its device.
+func (md *modbusDevice) ReadHoldingRegisters(unit byte, addr, count uint16) ([]uint16, error) {
+	values, ok := md.site.sunspecValues(time.Now(), md.mode)
+	if !ok {
+		return nil, &modbus.Exception{Function: modbus.FuncReadHoldingRegisters, Code: modbus.GatewayTargetFailed}
+	}
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	storage := values.battery != nil
+	if md.device == nil || md.storage != storage {
+		models := []int{sunspec.ModelInverterThreePhase, sunspec.ModelMeterWye}
+		if storage {
+			models = append(models, sunspec.ModelBattery)
+		}
+		common := sunspec.Common{
+			Manufacturer: "SolarEdge",
+			Model:        md.site.name(),
+			Serial:       md.site.site.ID(),
+		}
+		dev, err := sunspec.NewDevice(common, md.unit, models...)
+		if err != nil {
+			return nil, err
+		}
+		md.device, md.storage = dev, storage
+	}
+	md.device.Update(values.inverter, values.meter, values.battery)
+	return md.device.Registers().ReadHoldingRegisters(unit, addr, count)
+}
+
+// newModbusServer returns a server which answers the reads of unit n with the
+// values of the n-th site.
+func newModbusServer(sites []*siteService, mode metrics.StaleMode) (*modbus.Server, error) {
+	if len(sites) > 247 {
+		return nil, fmt.Errorf("cannot serve %d sites with modbus, at most 247", len(sites))
+	}
+	devices := make(map[byte]*modbusDevice)
+	for i, ss := range sites {
+		unit := byte(i + 1)
+		devices[unit] = &modbusDevice{site: ss, unit: unit, mode: mode}
+	}
+	return modbus.NewServer(modbus.HandlerFunc(func(unit byte, addr, count uint16) ([]uint16, error) {
+		md, ok := devices[unit]
+		if !ok {
+			return nil, &modbus.Exception{Function: modbus.FuncReadHoldingRegisters, Code: modbus.GatewayPathUnavailable}
+		}
+		return md.ReadHoldingRegisters(unit, addr, count)
+	})), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+	"gitlab.com/ulrichSchreiner/solaredge/sunspec"
+)
+
+const (
+	// simMaxBatteryPower is the maximum charge and discharge power of the
+	// simulated battery
+	simMaxBatteryPower = 5000.0
+	// simMinSoC is the reserve of the simulated battery
+	simMinSoC = 5.0
+)
+
+var (
+	simListen      string
+	simPeak        float64
+	simCapacity    float64
+	simInvertMeter bool
+	modbusSimCmd   = &cobra.Command{
+		Use:   "modbus-sim",
+		Short: "simulates an inverter with meter and battery with SunSpec Modbus TCP",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := modbusSim(); err != nil {
+				log.Fatal().Err(err).Msg("cannot run modbus simulator")
+			}
+		},
+	}
+)
+
+func init() {
+	modbusSimCmd.PersistentFlags().StringVar(&simListen, "listen", "localhost:1502", "the listen address of the simulator")
+	modbusSimCmd.PersistentFlags().Float64Var(&simPeak, "peak", 8000, "the peak power of the PV in W")
+	modbusSimCmd.PersistentFlags().Float64Var(&simCapacity, "battery", 10000, "the capacity of the battery in Wh, no battery if 0")
+	modbusSimCmd.PersistentFlags().BoolVar(&simInvertMeter, "invert-meter", false, "report imported power as positive instead of exported power like SolarEdge meters")
+}
+
+// simulation is a site with a PV, a random load and a battery which stores the
+// surplus of the PV.
+type simulation struct {
+	load       float64
+	soc        float64
+	produced   float64
+	imported   float64
+	exported   float64
+	inverter   sunspec.Inverter
+	meter      sunspec.Meter
+	battery    sunspec.Battery
+	hasBattery bool
+}
+
+// step advances the simulation by dt to now.
+func (sim *simulation) step(now time.Time, dt time.Duration) {
+	h := float64(now.Hour()) + float64(now.Minute())/60
+	pv := simPeak * math.Max(0, math.Sin(math.Pi*(h-6)/12)) * (0.9 + 0.1*rand.Float64())
+	sim.load = math.Min(4000, math.Max(200, sim.load+50*rand.NormFloat64()))
+	hours := dt.Hours()
+
+	// the battery is positive while it discharges
+	battery := 0.0
+	if sim.hasBattery {
+		surplus := pv - sim.load
+		switch {
+		case surplus > 0 && sim.soc < 100:
+			battery = -math.Min(surplus, simMaxBatteryPower)
+		case surplus < 0 && sim.soc > simMinSoC:
+			battery = math.Min(-surplus, simMaxBatteryPower)
+		}
+		sim.soc = math.Min(100, math.Max(0, sim.soc-battery*hours/simCapacity*100))
+	}
+	grid := sim.load - pv - battery
+	if grid > 0 {
+		sim.imported += grid * hours
+	} else {
+		sim.exported -= grid * hours
+	}
+	ac := pv + battery
+	sim.produced += math.Max(0, ac) * hours
+
+	state := sunspec.InverterMPPT
+	if pv == 0 {
+		state = sunspec.InverterSleeping
+	}
+	sim.inverter = sunspec.Inverter{W: ac, DCW: ac, WH: sim.produced, State: state}
+	sim.meter = sunspec.Meter{W: grid, WhImported: sim.imported, WhExported: sim.exported}
+	if !simInvertMeter {
+		sim.meter.W = -grid
+	}
+	chargeState := sunspec.ChargeHolding
+	switch {
+	case battery > 0:
+		chargeState = sunspec.ChargeDischarging
+	case battery < 0:
+		chargeState = sunspec.ChargeCharging
+	case sim.soc >= 100:
+		chargeState = sunspec.ChargeFull
+	case sim.soc <= simMinSoC:
+		chargeState = sunspec.ChargeEmpty
+	}
+	sim.battery = sunspec.Battery{W: battery, SoC: sim.soc, ChargeState: chargeState, WHRtg: simCapacity}
+}
+
+func modbusSim() error {
+	models := []int{sunspec.ModelInverterThreePhase, sunspec.ModelMeterWye}
+	if simCapacity > 0 {
+		models = append(models, sunspec.ModelBattery)
+	}
+	common := sunspec.Common{Manufacturer: "Simulator", Model: "SE-SIM", Version: "1", Serial: "SIM0001"}
+	dev, err := sunspec.NewDevice(common, 1, models...)
+	if err != nil {
+		return err
+	}
+	sim := &simulation{load: 500, soc: 50, hasBattery: simCapacity > 0}
+	update := func(now time.Time, dt time.Duration) {
+		sim.step(now, dt)
+		dev.Update(sim.inverter, sim.meter, &sim.battery)
+	}
+	update(time.Now(), 0)
+
+	srv := modbus.NewServer(dev.Registers())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe(simListen)
+	}()
+	log.Info().Str("listen", simListen).Ints("models", models).Msg("modbus simulator started")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			if err := srv.Close(); err != nil {
+				return err
+			}
+			if err := <-errc; !errors.Is(err, modbus.ErrServerClosed) {
+				return err
+			}
+			return nil
+		case now := <-ticker.C:
+			update(now, now.Sub(last))
+			last = now
+		}
+	}
+}
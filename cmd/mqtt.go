@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"gitlab.com/ulrichSchreiner/solaredge/mqtt"
+)
+
+var (
+	mqttBroker       string
+	mqttUsername     string
+	mqttClientID     string
+	mqttTopic        string
+	mqttAvailability string
+	mqttDiscovery    string
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&mqttBroker, "mqtt-broker", "", "publish the polled data to this MQTT broker, e.g. tcp://localhost:1883")
+	serveCmd.PersistentFlags().StringVar(&mqttUsername, "mqtt-username", "", "the username for the MQTT broker")
+	serveCmd.PersistentFlags().String("mqtt-password", "", "the password for the MQTT broker")
+	serveCmd.PersistentFlags().StringVar(&mqttClientID, "mqtt-client-id", "", "the client ID for the MQTT broker, solaredge-<host>-<pid> if empty")
+	serveCmd.PersistentFlags().StringVar(&mqttTopic, "mqtt-topic", mqtt.DefaultTopic, "the topic of the states, {site} and {kind} are replaced")
+	serveCmd.PersistentFlags().StringVar(&mqttAvailability, "mqtt-availability-topic", mqtt.DefaultAvailabilityTopic, "the topic of the availability")
+	serveCmd.PersistentFlags().StringVar(&mqttDiscovery, "mqtt-discovery-prefix", mqtt.DefaultDiscoveryPrefix, "the Home Assistant discovery prefix, no discovery if empty")
+	_ = viper.BindPFlag("mqtt_password", serveCmd.PersistentFlags().Lookup("mqtt-password"))
+}
+
+func newPublisher() (*mqtt.Publisher, error) {
+	opts := []mqtt.Opt{
+		mqtt.WithCredentials(mqttUsername, viper.GetString("mqtt_password")),
+		mqtt.WithTopic(mqttTopic),
+		mqtt.WithAvailabilityTopic(mqttAvailability),
+		mqtt.WithDiscoveryPrefix(mqttDiscovery),
+	}
+	if mqttClientID != "" {
+		opts = append(opts, mqtt.WithClientID(mqttClientID))
+	}
+	return mqtt.New(mqttBroker, opts...)
+}
+
+func (ss *siteService) mqttSite() mqtt.Site {
+	return mqtt.Site{ID: ss.site.ID(), Name: ss.staticDetails.Name}
+}
+
+// publishPowerFlow publishes the current powerflow and the battery state.
+func (ss *siteService) publishPowerFlow() {
+	if ss.publisher == nil {
+		return
+	}
+	ss.lock.RLock()
+	pf := ss.currentPowerFlow
+	site := ss.mqttSite()
+	ss.lock.RUnlock()
+
+	fd := genFlowData(pf)
+	err := ss.publisher.PublishFlow(site, mqtt.FlowState{
+		PV:      fd.PV,
+		Grid:    fd.Grid,
+		Load:    pf.Load.CurrentPower * unitFactor(pf.Unit),
+		Battery: fd.Battery,
+		SoC:     fd.SoC,
+	})
+	if err == nil && pf.Storage != nil {
+		err = ss.publisher.PublishBattery(site, mqtt.BatteryState{
+			SoC:      fd.SoC,
+			Power:    fd.Battery,
+			Status:   pf.Storage.Status,
+			Critical: pf.Storage.Critical,
+		})
+	}
+	if err != nil {
+		log.Error().Err(err).Str("site", site.ID).Msg("cannot publish powerflow")
+	}
+}
+
+// publishOverview publishes the current overview.
+func (ss *siteService) publishOverview() {
+	if ss.publisher == nil {
+		return
+	}
+	ss.lock.RLock()
+	ov := ss.currentOverview
+	site := ss.mqttSite()
+	ss.lock.RUnlock()
+
+	err := ss.publisher.PublishOverview(site, mqtt.OverviewState{
+		CurrentPower:   ov.CurrentPower.Power,
+		LifetimeEnergy: ov.LifetimeData.Energy,
+		YearEnergy:     ov.LastYearData.Energy,
+		MonthEnergy:    ov.LastMonthData.Energy,
+		DayEnergy:      ov.LastDayData.Energy,
+		LastUpdate:     time.Time(ov.LastUpdateTime),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("site", site.ID).Msg("cannot publish overview")
+	}
+}
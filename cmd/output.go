@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	localTimePattern = "2006-01-02 15:04:05"
+)
+
+var (
+	output         string
+	outputTemplate string
+)
+
+// printOutput writes the value in the selected output format.
+func printOutput(w io.Writer, v any) error {
+	switch strings.ToLower(output) {
+	case "", "json":
+		_, err := fmt.Fprintf(w, "%s", dumpAsJson(v))
+		return err
+	case "yaml":
+		return writeYaml(w, v)
+	case "table":
+		return writeTable(w, v)
+	case "template":
+		return writeTemplate(w, v, outputTemplate)
+	}
+	return fmt.Errorf("unknown output %q, use json, yaml, table or template", output)
+}
+
+// genericValue converts v to maps and slices with the names of the json
+// representation, so templates and yaml use the same names as the json output.
+func genericValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal value: %w", err)
+	}
+	var res any
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal value: %w", err)
+	}
+	return res, nil
+}
+
+func writeYaml(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot marshal value: %w", err)
+	}
+	// json is valid yaml, a MapSlice keeps the order of the fields
+	var ms any
+	if data[0] == '{' {
+		var m yaml.MapSlice
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("cannot convert to yaml: %w", err)
+		}
+		ms = m
+	} else if err := yaml.Unmarshal(data, &ms); err != nil {
+		return fmt.Errorf("cannot convert to yaml: %w", err)
+	}
+	out, err := yaml.Marshal(ms)
+	if err != nil {
+		return fmt.Errorf("cannot marshal yaml: %w", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func writeTemplate(w io.Writer, v any, tmpl string) error {
+	if tmpl == "" {
+		return fmt.Errorf("the template output needs a --template")
+	}
+	t, err := template.New("output").Funcs(template.FuncMap{
+		"power":  formatPower,
+		"energy": formatEnergy,
+		"json":   dumpAsJson,
+	}).Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("cannot parse template: %w", err)
+	}
+	gv, err := genericValue(v)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(w, gv); err != nil {
+		return fmt.Errorf("cannot execute template: %w", err)
+	}
+	if !strings.HasSuffix(tmpl, "\n") {
+		_, err = fmt.Fprintln(w)
+	}
+	return err
+}
+
+// scaled formats v with the largest prefix which keeps the value above 1.
+func scaled(v float64, unit string) string {
+	prefixes := []string{"", "k", "M", "G"}
+	idx := 0
+	for math.Abs(v) >= 1000 && idx < len(prefixes)-1 {
+		v /= 1000
+		idx++
+	}
+	return fmt.Sprintf("%.2f %s%s", v, prefixes[idx], unit)
+}
+
+// formatPower formats a power given in W.
+func formatPower(w float64) string {
+	return scaled(w, "W")
+}
+
+// formatEnergy formats an energy given in Wh.
+func formatEnergy(wh float64) string {
+	return scaled(wh, "Wh")
+}
+
+// localTime formats a time from solaredge in the local zone.
+func localTime(t solaredge.SETime) string {
+	tm := time.Time(t)
+	if tm.IsZero() {
+		return ""
+	}
+	return tm.Local().Format(localTimePattern)
+}
+
+func writeTable(w io.Writer, v any) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	row := func(cols ...any) {
+		s := make([]string, len(cols))
+		for i, c := range cols {
+			s[i] = fmt.Sprint(c)
+		}
+		fmt.Fprintln(tw, strings.Join(s, "\t"))
+	}
+
+	switch val := v.(type) {
+	case *solaredge.Site:
+		row("ID", val.Id)
+		row("NAME", val.Name)
+		row("ACCOUNT", val.AccountId)
+		row("STATUS", val.Status)
+		row("PEAK POWER", formatPower(val.PeakPower*1000)+"p")
+		row("ADDRESS", val.Location.Address)
+		row("CITY", val.Location.Zip+" "+val.Location.City)
+		row("COUNTRY", val.Location.Country)
+		row("TIMEZONE", val.Location.TimeZone)
+	case *solaredge.Inventory:
+		row("TYPE", "NAME", "MANUFACTURER", "MODEL", "SERIAL", "FIRMWARE")
+		for _, i := range val.Inverters {
+			row("inverter", i.Name, i.Manufacturer, i.Model, i.SN, i.CPUVersion)
+		}
+		for _, b := range val.Batteries {
+			row("battery", b.Name, b.Manufacturer, b.Model, b.SN, b.FirmwareVersion)
+		}
+		for _, m := range val.Meters {
+			row("meter", m.Name, m.Manufacturer, m.Model, m.SN, m.FirmwareVersion)
+		}
+		for _, g := range val.Gateways {
+			row("gateway", g.Name, "", "", g.SerialNumber, g.FirmwareVersion)
+		}
+		for _, s := range val.Sensors {
+			row("sensor", s.Type, "", s.Category, "", "")
+		}
+	case *solaredge.OverviewData:
+		row("LAST UPDATE", localTime(val.LastUpdateTime))
+		row("CURRENT POWER", formatPower(val.CurrentPower.Power))
+		row("LAST DAY", formatEnergy(val.LastDayData.Energy))
+		row("LAST MONTH", formatEnergy(val.LastMonthData.Energy))
+		row("LAST YEAR", formatEnergy(val.LastYearData.Energy))
+		row("LIFETIME", formatEnergy(val.LifetimeData.Energy))
+		row("MEASURED BY", val.MeasuredBy)
+	case *solaredge.PowerFlow:
+		scale := unitFactor(val.Unit)
+		row("ELEMENT", "STATUS", "POWER", "CHARGE LEVEL")
+		if val.PV != nil {
+			row("PV", val.PV.Status, formatPower(val.PV.CurrentPower*scale), "")
+		}
+		row("LOAD", val.Load.Status, formatPower(val.Load.CurrentPower*scale), "")
+		row("GRID", val.Grid.Status, formatPower(val.Grid.CurrentPower*scale), "")
+		if val.Storage != nil {
+			row("STORAGE", val.Storage.Status, formatPower(val.Storage.CurrentPower*scale), fmt.Sprintf("%d %%", val.Storage.ChargeLevel))
+		}
+		for _, c := range val.Connections {
+			row("", c.From+" -> "+c.To, "", "")
+		}
+	case *solaredge.PowerDetails:
+		writeMeterTable(row, val.Meters, unitFactor(val.Unit), formatPower)
+	case *solaredge.EngergyDetails:
+		writeMeterTable(row, val.Meters, unitFactor(strings.TrimSuffix(strings.ToLower(val.Unit), "h")), formatEnergy)
+	case []solaredge.StorageBattery:
+		row("TIME", "BATTERY", "POWER", "CHARGE LEVEL", "AVAILABLE", "TEMPERATURE", "STATE")
+		for _, b := range val {
+			for _, t := range b.Telemetries {
+				row(localTime(t.Timestamp), b.SN, formatPower(t.Power), fmt.Sprintf("%.1f %%", t.PercentageState),
+					formatEnergy(t.FullPackEngergyAvailable), fmt.Sprintf("%.1f °C", t.InternalTemp), t.State)
+			}
+		}
+	case []solaredge.InverterTelemetry:
+		row("TIME", "POWER", "DC VOLTAGE", "TEMPERATURE", "TOTAL ENERGY", "MODE")
+		for _, t := range val {
+			row(localTime(t.Date), formatPower(t.TotalActivePower), fmt.Sprintf("%.1f V", t.DCVoltage),
+				fmt.Sprintf("%.1f °C", t.Temperature), formatEnergy(t.TotalEnergy), t.InverterMode)
+		}
+	case *solaredge.DataPeriod:
+		row("START", time.Time(val.StartDate).Format("2006-01-02"))
+		row("END", time.Time(val.EndDate).Format("2006-01-02"))
+	default:
+		return fmt.Errorf("no table output for %T", v)
+	}
+	return tw.Flush()
+}
+
+// writeMeterTable writes one row per timestamp with one column per meter type.
+// The values are multiplied with scale to get W or Wh.
+func writeMeterTable(row func(cols ...any), meters []solaredge.MeteredValue, scale float64, format func(float64) string) {
+	// keyed by the instant, the values of the meters have different locations
+	values := make(map[int64]map[string]float64)
+	var times []time.Time
+	hdr := []any{"TIME"}
+	for _, m := range meters {
+		hdr = append(hdr, strings.ToUpper(m.Type))
+		for _, v := range m.Values {
+			t := time.Time(v.Date)
+			k := t.UnixNano()
+			if values[k] == nil {
+				values[k] = make(map[string]float64)
+				times = append(times, t)
+			}
+			values[k][m.Type] = v.Value
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	row(hdr...)
+	for _, t := range times {
+		cols := []any{t.Local().Format(localTimePattern)}
+		for _, m := range meters {
+			v, ok := values[t.UnixNano()][m.Type]
+			if !ok {
+				cols = append(cols, "")
+				continue
+			}
+			cols = append(cols, format(v*scale))
+		}
+		row(cols...)
+	}
+}
@@ -19,25 +19,47 @@ var (
 		Run: func(cmd *cobra.Command, args []string) {
 
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			saveRecording()
+		},
 	}
-	timezone string
+	record   string
+	replay   string
+	recorder *solaredge.Recorder
 )
 
 func init() {
 	zone := time.Local.String()
 
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		// the validate command reports the problems of the config itself
+		if cmd != configValidateCmd {
+			if err := applyConfig(); err != nil {
+				log.Fatalf("cannot load config: %v", err)
+			}
+		}
+		initTimezone()
+	}
 
 	rootCmd.PersistentFlags().String("baseurl", solaredge.DEFAULT_URL, "The base URL for the webservices")
-	rootCmd.PersistentFlags().StringVar(&timezone, "timezone", zone, "The timezone to use for timestamps")
+	rootCmd.PersistentFlags().String("timezone", zone, "The timezone to use for timestamps")
 	rootCmd.PersistentFlags().String("apikey", "", "Your API key")
+	rootCmd.PersistentFlags().StringVar(&record, "record", "", "Record the anonymized API responses to this cassette file")
+	rootCmd.PersistentFlags().StringVar(&replay, "replay", "", "Answer all API calls from this cassette file instead of the webservice")
 	_ = viper.BindPFlag("apikey", rootCmd.PersistentFlags().Lookup("apikey"))
 	_ = viper.BindPFlag("baseurl", rootCmd.PersistentFlags().Lookup("baseurl"))
+	_ = viper.BindPFlag("timezone", rootCmd.PersistentFlags().Lookup("timezone"))
 }
 
 func initConfig() {
 	viper.SetEnvPrefix("solaredge")
 	viper.AutomaticEnv()
+}
+
+// initTimezone sets the zone of the sites, the config may have changed it.
+func initTimezone() {
+	timezone := viper.GetString("timezone")
 	if timezone != "" {
 		_, err := time.LoadLocation(timezone)
 		if err != nil {
@@ -47,6 +69,34 @@ func initConfig() {
 	}
 }
 
+// clientOptions returns the options for the solaredge client from the global flags.
+func clientOptions() []solaredge.SEOpt {
+	opts := []solaredge.SEOpt{solaredge.WithBaseURL(viper.GetString("baseurl"))}
+	if replay != "" {
+		rp, err := solaredge.LoadReplayer(replay)
+		if err != nil {
+			log.Fatalf("cannot load cassette: %v", err)
+		}
+		return append(opts, solaredge.WithTransport(rp))
+	}
+	if record != "" {
+		if recorder == nil {
+			recorder = solaredge.NewRecorder(nil)
+		}
+		opts = append(opts, solaredge.WithTransport(recorder))
+	}
+	return opts
+}
+
+func saveRecording() {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Save(record); err != nil {
+		log.Printf("cannot save recording: %v", err)
+	}
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -1,239 +1,1046 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/alert"
+	"gitlab.com/ulrichSchreiner/solaredge/history"
+	"gitlab.com/ulrichSchreiner/solaredge/influx"
+	"gitlab.com/ulrichSchreiner/solaredge/metrics"
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+	"gitlab.com/ulrichSchreiner/solaredge/mqtt"
+	"gitlab.com/ulrichSchreiner/solaredge/schedule"
+	"gitlab.com/ulrichSchreiner/solaredge/serveapi"
 )
 
+// historyPruneInterval is the time between two runs of the history retention.
+const historyPruneInterval = time.Hour
+
 var (
-	listen   string
-	flow     time.Duration
-	poll     time.Duration
-	serveCmd = &cobra.Command{
-		Use:   "serve",
-		Short: "starts a http service for a site",
+	listen           string
+	flow             time.Duration
+	poll             time.Duration
+	historyDir       string
+	historyRetention time.Duration
+	allSites         bool
+	coordinates      []string
+	sunriseMargin    time.Duration
+	sunsetMargin     time.Duration
+	dayWindow        string
+	budget           int
+	endpoints        []string
+	serveCmd         = &cobra.Command{
+		Use:   "serve [siteid...]",
+		Short: "starts a http service for one or more sites",
 		Run: func(cmd *cobra.Command, args []string) {
-			siteid := viper.GetString("siteid")
-			if len(args) > 0 {
-				siteid = args[0]
+			siteids := args
+			if len(siteids) == 0 && viper.GetString("siteid") != "" {
+				siteids = strings.Split(viper.GetString("siteid"), ",")
+			}
+			if err := serveService(siteids); err != nil {
+				log.Fatal().Err(err).Msg("service failed")
 			}
-			serveService(siteid)
 		},
 	}
-
-	pvGauge      prometheus.Gauge
-	gridGauge    prometheus.Gauge
-	batteryGauge prometheus.Gauge
-	socGauge     prometheus.Gauge
 )
 
 func init() {
 	serveCmd.PersistentFlags().StringVar(&listen, "listen", "localhost:7777", "the listen address for the service")
-	serveCmd.PersistentFlags().DurationVar(&flow, "flow", 180*time.Second, "the poll duration for the powerflow call")
-	serveCmd.PersistentFlags().DurationVar(&poll, "poll", 15*time.Minute, "the poll duration for standard API calls")
+	serveCmd.PersistentFlags().DurationVar(&flow, "flow", 180*time.Second, "the minimum poll duration for the powerflow call")
+	serveCmd.PersistentFlags().DurationVar(&poll, "poll", 15*time.Minute, "the minimum poll duration for standard API calls")
+	serveCmd.PersistentFlags().StringVar(&historyDir, "history", "", "the directory of the history store, no history is kept if empty")
+	serveCmd.PersistentFlags().DurationVar(&historyRetention, "history-retention", 0, "the retention of the powerflow history, forever if 0")
+	serveCmd.PersistentFlags().BoolVar(&allSites, "all-sites", false, "serve all sites which are visible with the API key")
+	serveCmd.PersistentFlags().StringArrayVar(&coordinates, "coordinates", nil, "the coordinates of the sites as lat,lon for all sites or siteid=lat,lon, can be repeated")
+	serveCmd.PersistentFlags().DurationVar(&sunriseMargin, "sunrise-margin", 0, "the daylight starts this long after sunrise")
+	serveCmd.PersistentFlags().DurationVar(&sunsetMargin, "sunset-margin", 0, "the daylight ends this long before sunset")
+	serveCmd.PersistentFlags().IntVar(&budget, "budget", solaredge.DailyQuota-20, "the API calls per site and day which are planned for the polls")
+	serveCmd.PersistentFlags().StringArrayVar(&endpoints, "endpoint", []string{"powerflow=3,15m", "overview=1,1h"}, "the polled endpoints as name=priority,freshness, can be repeated")
+	serveCmd.PersistentFlags().StringVar(&dayWindow, "day-window", schedule.DefaultWindow.String(), "the daylight in the site zone if no coordinates are given")
 }
 
+// solaredgeService serves the data of all polled sites.
 type solaredgeService struct {
+	sites     []*siteService
+	byID      map[string]*siteService
+	mux       *http.ServeMux
+	exporter  *metrics.Exporter
+	history   *history.Store
+	publisher *mqtt.Publisher
+	influx    *influx.Writer
+	alerts    *alert.Engine
+	streamHub *streamHub
+	auth      *authConfig
+	certs     *certReloader
+	state     *stateStore
+	// modbus serves the sites on the listener if it is set
+	modbus         *modbus.Server
+	modbusListener net.Listener
+	// stop is closed on shutdown to stop the polling of all sites
+	stop    chan struct{}
+	pollers sync.WaitGroup
+}
+
+// siteService polls the data of a single site.
+type siteService struct {
 	lock             sync.RWMutex
 	site             *solaredge.SiteClient
+	source           solaredge.DataSource
+	sourceInterval   time.Duration
+	history          *history.Store
+	publisher        *mqtt.Publisher
+	influx           *influx.Writer
+	alerts           *alert.Engine
+	stream           *streamHub
+	state            *stateStore
+	exporter         *metrics.Exporter
 	flowTimer        time.Duration
-	pollTimer        time.Duration
+	scheduler        *schedule.Scheduler
+	planner          *schedule.Planner
+	plan             *schedule.Plan
+	next             map[string]time.Time
+	refresh          chan struct{}
+	stop             <-chan struct{}
 	currentPowerFlow solaredge.PowerFlow
 	currentOverview  solaredge.OverviewData
 	staticDetails    solaredge.Site
+	detailsFetched   time.Time
+	flowFetched      time.Time
+	flowChanged      time.Time
+	overviewFetched  time.Time
+	flowRecorded     time.Time
+	fill             historyFill
+	// the inventory is fetched once on the first request
+	inventoryLock sync.Mutex
+	inventory     *solaredge.Inventory
+	// the last error per endpoint, so a successful call of one endpoint does
+	// not hide a rejected API key of another one
+	lastErrors map[string]error
+}
+
+// serviceOpt is an option type for the solaredgeService.
+type serviceOpt func(ses *solaredgeService)
+
+// withHistory stores the polled data of all sites in the history store.
+func withHistory(hs *history.Store) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.history = hs
+	}
+}
+
+// withInflux writes the polled data of all sites to InfluxDB.
+func withInflux(iw *influx.Writer) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.influx = iw
+	}
+}
+
+// withAlerts evaluates the alert rules after every poll.
+func withAlerts(e *alert.Engine) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.alerts = e
+	}
+}
+
+// withAuth protects the endpoints with the users of the auth config.
+func withAuth(ac *authConfig) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.auth = ac
+	}
+}
+
+// withState restores the data of the last run and saves every fetched value.
+func withState(st *stateStore) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.state = st
+	}
 }
 
-func newSolaredgeService(sc *solaredge.SiteClient) (*solaredgeService, error) {
+// withTLS serves https with the certificate of the reloader.
+func withTLS(cr *certReloader) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.certs = cr
+	}
+}
+
+// withModbusListener serves the flow of all sites with SunSpec Modbus TCP on the
+// listener.
+func withModbusListener(l net.Listener) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.modbusListener = l
+	}
+}
+
+// withPublisher publishes the polled data of all sites to MQTT.
+func withPublisher(pub *mqtt.Publisher) serviceOpt {
+	return func(ses *solaredgeService) {
+		ses.publisher = pub
+	}
+}
+
+func newSolaredgeService(sec *solaredge.SEClient, siteids []string, opts ...serviceOpt) (*solaredgeService, error) {
 	res := &solaredgeService{
-		site:      sc,
-		flowTimer: flow,
-		pollTimer: poll,
+		byID:      make(map[string]*siteService),
+		mux:       http.NewServeMux(),
+		streamHub: newStreamHub(),
+		stop:      make(chan struct{}),
 	}
+	for _, o := range opts {
+		o(res)
+	}
+	staleMode, err := metrics.ParseStaleMode(staleGauges)
+	if err != nil {
+		return nil, err
+	}
+	res.exporter = metrics.NewExporter(res.snapshots, metrics.WithStaleMode(staleMode))
 
-	if err := res.fetchSiteDetails(); err != nil {
+	// the site list contains the details, so they must not be fetched again
+	eps, err := planEndpoints()
+	if err != nil {
 		return nil, err
 	}
 
-	pvGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: strings.ToLower(fmt.Sprintf("site_%d", res.staticDetails.Id)),
-		Subsystem: "pv",
-		Name:      "current_power",
-		Help:      "the current power of the pv",
-	})
-	prometheus.MustRegister(pvGauge)
-	gridGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: strings.ToLower(fmt.Sprintf("site_%d", res.staticDetails.Id)),
-		Subsystem: "grid",
-		Name:      "current_power",
-		Help:      "the current power of the grid",
-	})
-	prometheus.MustRegister(gridGauge)
-	batteryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: strings.ToLower(fmt.Sprintf("site_%d", res.staticDetails.Id)),
-		Subsystem: "battery",
-		Name:      "current_power",
-		Help:      "the current power of the battery",
-	})
-	prometheus.MustRegister(batteryGauge)
-	socGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: strings.ToLower(fmt.Sprintf("site_%d", res.staticDetails.Id)),
-		Subsystem: "soc",
-		Name:      "current_value",
-		Help:      "the current state of charge of the battery",
+	details := make(map[string]solaredge.Site)
+	if allSites {
+		sites, err := sec.Sites()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list sites: %w", err)
+		}
+		siteids = nil
+		for _, s := range sites {
+			id := fmt.Sprint(s.Id)
+			siteids = append(siteids, id)
+			details[id] = s
+		}
+	}
+	if len(siteids) == 0 {
+		return nil, fmt.Errorf("no sites to serve")
+	}
+
+	for _, id := range siteids {
+		id = strings.TrimSpace(id)
+		if _, ok := res.byID[id]; ok {
+			continue
+		}
+		site := sec.NewSite(id)
+		source, sourceInterval, err := newDataSource(site)
+		if err != nil {
+			return nil, err
+		}
+		ss := &siteService{
+			site:           site,
+			source:         source,
+			sourceInterval: sourceInterval,
+			history:        res.history,
+			publisher:      res.publisher,
+			influx:         res.influx,
+			alerts:         res.alerts,
+			stream:         res.streamHub,
+			state:          res.state,
+			exporter:       res.exporter,
+			flowTimer:      flow,
+			refresh:        make(chan struct{}, 1),
+			stop:           res.stop,
+			lastErrors:     make(map[string]error),
+		}
+		restored := ss.restore()
+		if det, ok := details[id]; ok {
+			ss.staticDetails = det
+			ss.detailsFetched = time.Now()
+		} else if !restored {
+			if err := ss.fetchSiteDetails(); err != nil {
+				return nil, err
+			}
+		}
+		sched, err := newScheduler(ss.staticDetails)
+		if err != nil {
+			return nil, err
+		}
+		ss.scheduler = sched
+		if ss.sourceInterval > 0 {
+			ss.planner = schedule.NewPlanner(sched, budget, localEndpoints(eps))
+		} else {
+			ss.planner = schedule.NewPlanner(sched, budget, eps)
+		}
+		res.sites = append(res.sites, ss)
+		res.byID[id] = ss
+	}
+
+	if res.modbusListener != nil {
+		res.modbus, err = newModbusServer(res.sites, staleMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res.mux.HandleFunc("/sites", res.listSites)
+	res.mux.HandleFunc("/sites/", res.siteHandler)
+	// the endpoints without a site serve the first site
+	first := res.sites[0]
+	res.mux.HandleFunc("/powerflow", first.sitePowerFlow)
+	res.mux.HandleFunc("/flow", first.siteFlow)
+	res.mux.HandleFunc("/overview", first.siteOverview)
+	res.mux.HandleFunc("/details", first.siteDetails)
+	res.mux.HandleFunc("/inventory", first.siteInventory)
+	res.mux.HandleFunc("/refresh", first.siteRefresh)
+	res.mux.HandleFunc("/history/", func(rw http.ResponseWriter, rq *http.Request) {
+		first.siteHistory(rw, rq, strings.TrimPrefix(rq.URL.Path, "/history/"))
 	})
-	prometheus.MustRegister(socGauge)
+	res.mux.HandleFunc("/schedule", res.schedule)
+	res.mux.HandleFunc("/alerts", res.listAlerts)
+	res.mux.HandleFunc("/stream", res.stream)
+	res.mux.HandleFunc("/healthz", res.healthz)
+	res.mux.HandleFunc("/readyz", res.readyz)
+	res.mux.HandleFunc("/openapi.json", openAPI)
+	res.mux.Handle("/", dashboard())
 
-	http.HandleFunc("/powerflow", res.sitePowerFlow)
-	http.HandleFunc("/flow", res.siteFlow)
-	http.HandleFunc("/overview", res.siteOverview)
-	http.HandleFunc("/details", res.siteDetails)
+	res.mux.Handle("/metrics", promhttp.HandlerFor(metrics.NewRegistry(res.exporter), promhttp.HandlerOpts{}))
 
-	http.Handle("/metrics", promhttp.Handler())
+	for _, ss := range res.sites {
+		res.pollers.Add(1)
+		go func(ss *siteService) {
+			defer res.pollers.Done()
+			ss.start()
+		}(ss)
+		if ss.sourceInterval > 0 {
+			res.pollers.Add(1)
+			go func(ss *siteService) {
+				defer res.pollers.Done()
+				ss.pollSource()
+			}(ss)
+		}
+	}
+	if res.history != nil {
+		res.pollers.Add(1)
+		go func() {
+			defer res.pollers.Done()
+			res.pruneHistory()
+		}()
+	}
+	return res, nil
+}
 
-	go res.start()
+// planEndpoints returns the polled endpoints of the flags. The minimum intervals
+// are the flow and poll flags.
+func planEndpoints() ([]schedule.Endpoint, error) {
+	var res []schedule.Endpoint
+	for _, e := range endpoints {
+		ep, err := schedule.ParseEndpoint(e)
+		if err != nil {
+			return nil, err
+		}
+		switch ep.Name {
+		case "powerflow":
+			ep.MinInterval = flow
+		case "overview":
+			ep.MinInterval = poll
+		default:
+			return nil, fmt.Errorf("unknown endpoint %q, use powerflow or overview", ep.Name)
+		}
+		res = append(res, ep)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no endpoints to poll")
+	}
 	return res, nil
 }
 
-func (ses *solaredgeService) listen(l string) {
-	_ = http.ListenAndServe(listen, nil)
+// newScheduler returns the daylight of the site in the zone of the site. A zone
+// of the site in the config wins over the zone of the API.
+func newScheduler(site solaredge.Site) (*schedule.Scheduler, error) {
+	zone := site.Location.TimeZone
+	if z, ok := siteZones[fmt.Sprint(site.Id)]; ok {
+		zone = z
+	}
+	if zone == "" {
+		zone = solaredge.SiteZone
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load zone of site %d: %w", site.Id, err)
+	}
+	window, err := schedule.ParseWindow(dayWindow)
+	if err != nil {
+		return nil, err
+	}
+	opts := []schedule.Opt{schedule.WithWindow(window), schedule.WithMargins(sunriseMargin, sunsetMargin)}
+
+	// coordinates for a single site win over the coordinates of all sites
+	var coords string
+	for _, c := range coordinates {
+		id, latlon, ok := strings.Cut(c, "=")
+		if !ok {
+			if coords == "" {
+				coords = c
+			}
+			continue
+		}
+		if strings.TrimSpace(id) == fmt.Sprint(site.Id) {
+			coords = latlon
+			break
+		}
+	}
+	if coords != "" {
+		c, err := schedule.ParseCoordinates(coords)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, schedule.WithCoordinates(c))
+	}
+	return schedule.New(loc, opts...), nil
+}
+
+// run serves the http requests until SIGINT or SIGTERM. Then the polling is
+// stopped and the running requests are finished.
+func (ses *solaredgeService) run(l string) error {
+	var handler http.Handler = ses.mux
+	if ses.auth != nil {
+		handler = ses.auth.wrap(handler)
+	}
+	srv := &http.Server{Addr: l, Handler: handler}
+	// streams never finish on their own
+	srv.RegisterOnShutdown(ses.streamHub.close)
+	if ses.certs != nil {
+		srv.TLSConfig = &tls.Config{GetCertificate: ses.certs.getCertificate, MinVersion: tls.VersionTLS12}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	if ses.modbus != nil {
+		go func() {
+			if err := ses.modbus.Serve(ses.modbusListener); !errors.Is(err, modbus.ErrServerClosed) {
+				log.Error().Err(err).Msg("cannot serve modbus")
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+		err = fmt.Errorf("cannot listen on %s: %w", l, err)
+	case <-ctx.Done():
+		log.Info().Msg("shutting down")
+	}
+	close(ses.stop)
+	if ses.modbus != nil {
+		ses.modbus.Close()
+	}
+	sctx, scancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer scancel()
+	if serr := srv.Shutdown(sctx); serr != nil && err == nil {
+		err = fmt.Errorf("cannot finish running requests: %w", serr)
+	}
+	// a poll waits for its running API call, which is bounded by the timeout
+	// of the client, but the shutdown does not wait longer than its timeout
+	stopped := make(chan struct{})
+	go func() {
+		ses.pollers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-sctx.Done():
+		if err == nil {
+			err = fmt.Errorf("cannot stop polling within %s", shutdownTimeout)
+		}
+	}
+	return err
+}
+
+func (ses *solaredgeService) snapshots() []metrics.SiteSnapshot {
+	res := make([]metrics.SiteSnapshot, 0, len(ses.sites))
+	for _, ss := range ses.sites {
+		res = append(res, ss.snapshot())
+	}
+	return res
+}
+
+func (ses *solaredgeService) listSites(rw http.ResponseWriter, rq *http.Request) {
+	res := make([]serveapi.Site, 0, len(ses.sites))
+	for _, ss := range ses.sites {
+		res = append(res, serveapi.Site{ID: ss.site.ID(), Name: ss.name()})
+	}
+	rw.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(res)
+}
+
+// siteHandler dispatches /sites/{id}/{endpoint} to the handler of the site.
+func (ses *solaredgeService) siteHandler(rw http.ResponseWriter, rq *http.Request) {
+	id, endpoint, _ := strings.Cut(strings.TrimPrefix(rq.URL.Path, "/sites/"), "/")
+	ss, ok := ses.byID[id]
+	if !ok {
+		http.NotFound(rw, rq)
+		return
+	}
+	switch endpoint {
+	case "powerflow":
+		ss.sitePowerFlow(rw, rq)
+	case "flow":
+		ss.siteFlow(rw, rq)
+	case "overview":
+		ss.siteOverview(rw, rq)
+	case "details":
+		ss.siteDetails(rw, rq)
+	case "inventory":
+		ss.siteInventory(rw, rq)
+	case "refresh":
+		ss.siteRefresh(rw, rq)
+	case "schedule":
+		rw.Header().Add("content-type", "application/json")
+		_ = json.NewEncoder(rw).Encode(ss.schedule())
+	case "stream":
+		ses.serveStream(rw, rq, []*siteService{ss})
+	case "history/flow", "history/energy", "history/overview":
+		ss.siteHistory(rw, rq, strings.TrimPrefix(endpoint, "history/"))
+	default:
+		http.NotFound(rw, rq)
+	}
+}
+
+func (ss *siteService) name() string {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	return ss.staticDetails.Name
+}
+
+// snapshot returns the current values of the site for the metrics.
+func (ss *siteService) snapshot() metrics.SiteSnapshot {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	pf := ss.currentPowerFlow
+	fd := genFlowData(pf)
+	now := time.Now()
+	res := metrics.SiteSnapshot{
+		ID:             ss.site.ID(),
+		Name:           ss.staticDetails.Name,
+		PV:             fd.PV,
+		Grid:           fd.Grid,
+		Load:           pf.Load.CurrentPower * unitFactor(pf.Unit),
+		Battery:        fd.Battery,
+		SoC:            fd.SoC,
+		HasStorage:     pf.Storage != nil,
+		LifetimeEnergy: ss.currentOverview.LifetimeData.Energy,
+		YearEnergy:     ss.currentOverview.LastYearData.Energy,
+		MonthEnergy:    ss.currentOverview.LastMonthData.Energy,
+		DayEnergy:      ss.currentOverview.LastDayData.Energy,
+		LastUpdate:     time.Time(ss.currentOverview.LastUpdateTime),
+		RemainingQuota: ss.site.RemainingQuota(),
+		FlowChanged:    ss.flowChanged,
+		FlowStale:      ss.flowFreshness(now).Stale,
+		OverviewStale:  ss.overviewFreshness(now).Stale,
+	}
+	if pf.Storage != nil {
+		res.Critical = pf.Storage.Critical
+	}
+	return res
+}
+
+// observe records the duration and result of an API call in the metrics.
+func (ss *siteService) observe(endpoint string, start time.Time, err error) {
+	ss.exporter.ObservePoll(ss.site.ID(), ss.staticDetails.Name, endpoint, time.Since(start), err)
 }
 
-func (ses *solaredgeService) fetchPowerFlow() {
-	ses.lock.Lock()
-	defer ses.lock.Unlock()
-	det, err := ses.site.PowerFlow()
+// fetchPowerFlow fetches the powerflow from the source. recorded is true if it
+// was written to the state and the history: a local source is polled every few
+// seconds, so its powerflow is only recorded at the interval of the flow. The
+// source is read without the lock, a slow read must not block the handlers.
+func (ss *siteService) fetchPowerFlow() (recorded bool, err error) {
+	start := time.Now()
+	det, err := ss.source.PowerFlow()
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.observe("powerflow", start, err)
+	ss.lastErrors["powerflow"] = err
 	if err != nil {
-		log.Error().Err(err).Msg("cannot query powerflow")
+		log.Error().Err(err).Str("site", ss.site.ID()).Msg("cannot query powerflow")
 	} else {
-		log.Info().
+		// a local source is polled every few seconds
+		ev := log.Info()
+		if ss.sourceInterval > 0 {
+			ev = log.Debug()
+		}
+		ev.
+			Str("site", ss.site.ID()).
 			Interface("powerflow", *det).
 			Msg("fetched new powerflow")
-		ses.currentPowerFlow = *det
+		// solaredge repeats the last powerflow while the inverter is offline
+		if ss.flowChanged.IsZero() || !reflect.DeepEqual(*det, ss.currentPowerFlow) {
+			ss.flowChanged = time.Now()
+		}
+		ss.currentPowerFlow = *det
+		ss.flowFetched = time.Now()
+		if ss.sourceInterval > 0 && ss.flowFetched.Sub(ss.flowRecorded) < ss.flowTimer {
+			return false, nil
+		}
+		ss.flowRecorded = ss.flowFetched
+		ss.persist(func(s *siteState) {
+			s.PowerFlow = det
+			s.FlowFetched = ss.flowFetched
+			s.FlowChanged = ss.flowChanged
+		})
+		if ss.history != nil {
+			if err := ss.history.AddPowerFlow(ss.site.ID(), time.Now(), *det); err != nil {
+				log.Error().Err(err).Msg("cannot store powerflow")
+			}
+		}
+		return true, nil
 	}
-
-	fd := genFlowData(ses.currentPowerFlow)
-	pvGauge.Set(fd.PV)
-	gridGauge.Set(fd.Grid)
-	batteryGauge.Set(fd.Battery)
-	socGauge.Set(fd.SoC)
+	return false, err
 }
 
-func (ses *solaredgeService) fetchOverview() {
-	ses.lock.Lock()
-	defer ses.lock.Unlock()
-	det, err := ses.site.Overview()
+func (ss *siteService) fetchOverview() error {
+	start := time.Now()
+	det, err := ss.site.Overview()
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.observe("overview", start, err)
+	ss.lastErrors["overview"] = err
 	if err != nil {
-		log.Error().Err(err).Msg("cannot query overview")
+		log.Error().Err(err).Str("site", ss.site.ID()).Msg("cannot query overview")
 	} else {
 		log.Info().
+			Str("site", ss.site.ID()).
 			Interface("overview", *det).
 			Msg("fetched new overview")
-		ses.currentOverview = *det
+		ss.currentOverview = *det
+		ss.overviewFetched = time.Now()
+		ss.persist(func(s *siteState) {
+			s.Overview = det
+			s.OverviewFetched = ss.overviewFetched
+		})
+		if ss.history != nil {
+			if err := ss.history.AddOverview(ss.site.ID(), *det); err != nil {
+				log.Error().Err(err).Msg("cannot store overview")
+			}
+		}
+	}
+	return err
+}
+
+// pruneHistory applies the retention of the history once at the start and then
+// every historyPruneInterval until the service is stopped.
+func (ses *solaredgeService) pruneHistory() {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := ses.history.Prune(time.Now()); err != nil {
+			log.Error().Err(err).Msg("cannot prune history")
+		}
+		select {
+		case <-ses.stop:
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
-func (ses *solaredgeService) fetchSiteDetails() error {
-	ses.lock.Lock()
-	defer ses.lock.Unlock()
-	det, err := ses.site.Details()
+func (ss *siteService) fetchSiteDetails() error {
+	start := time.Now()
+	det, err := ss.site.Details()
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if err == nil {
+		// the name is a label of the metrics, so it must be known before observing
+		ss.staticDetails = *det
+		ss.detailsFetched = time.Now()
+		ss.persist(func(s *siteState) {
+			s.Details = det
+			s.DetailsFetched = ss.detailsFetched
+		})
+	}
+	ss.observe("details", start, err)
 	if err != nil {
-		return fmt.Errorf("cannot query site details: %w", err)
-	} else {
-		log.Info().
-			Interface("details", *det).
-			Msg("fetched new site details")
-		ses.staticDetails = *det
+		return fmt.Errorf("cannot query site details of %q: %w", ss.site.ID(), err)
 	}
+	log.Info().
+		Interface("details", *det).
+		Msg("fetched new site details")
 	return nil
 }
 
-func (ses *solaredgeService) start() {
-	currentFlowInterval := 10 * time.Second
-	flowticker := time.NewTicker(currentFlowInterval)
-	polltick := time.Tick(ses.pollTimer)
+// poll fetches the data of the endpoint.
+func (ss *siteService) poll(endpoint string) error {
+	defer ss.evaluateAlerts()
+	switch endpoint {
+	case "powerflow":
+		recorded, err := ss.fetchPowerFlow()
+		if err == nil {
+			ss.publishStream(streamFlow)
+		}
+		if recorded {
+			ss.publishPowerFlow()
+			ss.writeInflux(powerFlowPoint(ss.site.ID(), time.Now(), ss.powerFlow()))
+		}
+		return err
+	case "overview":
+		err := ss.fetchOverview()
+		if err == nil {
+			ss.publishOverview()
+			ss.publishStream(streamOverview)
+			ss.writeInflux(overviewPoint(ss.site.ID(), ss.overview()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (ss *siteService) powerFlow() solaredge.PowerFlow {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	return ss.currentPowerFlow
+}
+
+func (ss *siteService) overview() solaredge.OverviewData {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	return ss.currentOverview
+}
+
+// writeInflux writes the point to InfluxDB if it is configured. The writer
+// batches the points in the background and keeps them while the database is
+// down.
+func (ss *siteService) writeInflux(p influx.Point) {
+	if ss.influx == nil {
+		return
+	}
+	ss.influx.Write(p)
+}
 
-	// first initialize our state
-	ses.fetchPowerFlow()
-	ses.fetchOverview()
+// replan computes a new plan with the calls which are left for today. After a
+// quota error no more calls are planned for today.
+func (ss *siteService) replan(err error) {
+	now := time.Now()
+	var plan *schedule.Plan
+	if solaredge.IsQuotaExceeded(err) {
+		plan = ss.planner.Exhausted(now)
+	} else {
+		plan = ss.planner.Plan(now, ss.site.CallsToday(ss.site.ID()))
+	}
+	ss.lock.Lock()
+	ss.plan = plan
+	ss.lock.Unlock()
+	log.Info().
+		Str("site", ss.site.ID()).
+		Int("used", plan.Used).
+		Int("planned", plan.Planned).
+		Bool("feasible", plan.Feasible).
+		Interface("endpoints", plan.Endpoints).
+		Msg("new poll plan")
+}
+
+func (ss *siteService) start() {
+	ss.lock.Lock()
+	ss.next = make(map[string]time.Time)
+	ss.lock.Unlock()
+
+	// first initialize our state, restored data is polled again when it is due
+	var err error
+	last := make(map[string]time.Time)
+	for _, ep := range ss.planner.Endpoints() {
+		if ss.stopped() {
+			return
+		}
+		if t := ss.fetched(ep.Name); !t.IsZero() {
+			last[ep.Name] = t
+			continue
+		}
+		if perr := ss.poll(ep.Name); perr != nil {
+			err = perr
+		}
+		last[ep.Name] = time.Now()
+	}
+	ss.replan(err)
 
 	for {
+		// the next poll is the earliest of all endpoints
+		ss.lock.Lock()
+		plan := ss.plan
+		var due time.Time
+		for name, l := range last {
+			ss.next[name] = plan.Next(name, l)
+			if due.IsZero() || ss.next[name].Before(due) {
+				due = ss.next[name]
+			}
+		}
+		ss.lock.Unlock()
+		// without planned endpoints only a local source is polled
+		timer := time.NewTimer(time.Until(due))
+		wait := timer.C
+		if due.IsZero() {
+			timer.Stop()
+			wait = nil
+		}
+
 		select {
-		case <-flowticker.C:
-			hour := time.Now().Hour()
-			log.Info().Msg("current hour: " + fmt.Sprint(hour))
-			// we want to poll more often during the day to stay within our quota of 300 requests per day
-			if hour >= 8 && hour < 20 && currentFlowInterval != ses.flowTimer { // If it's day and flowTimer is not set to flowtick
-				flowticker.Stop()
-				currentFlowInterval = ses.flowTimer
-				flowticker = time.NewTicker(currentFlowInterval)
-				log.Info().Msg("new flow poll interval set to : " + fmt.Sprint(currentFlowInterval))
-			} else if hour >= 20 || hour < 6 && currentFlowInterval != ses.pollTimer { // If it's night and flowTimer is not set to nightInterval
-				flowticker.Stop()
-				currentFlowInterval = ses.pollTimer
-				flowticker = time.NewTicker(currentFlowInterval)
-				log.Info().Msg("new flow poll interval set to : " + fmt.Sprint(currentFlowInterval))
+		case <-ss.stop:
+			timer.Stop()
+			return
+		case <-wait:
+			now := time.Now()
+			var err error
+			for name := range last {
+				if ss.next[name].After(now) {
+					continue
+				}
+				if perr := ss.poll(name); perr != nil {
+					err = perr
+				}
+				last[name] = now
 			}
-			ses.fetchPowerFlow()
-		case <-polltick:
-			ses.fetchOverview()
+			if err != nil || !now.Before(plan.End) {
+				ss.replan(err)
+			}
+		case <-ss.refresh:
+			timer.Stop()
+			var err error
+			for name := range last {
+				if perr := ss.poll(name); perr != nil {
+					err = perr
+				}
+				last[name] = time.Now()
+			}
+			ss.replan(err)
 		}
 	}
 }
 
-func (ses *solaredgeService) sitePowerFlow(rw http.ResponseWriter, rq *http.Request) {
-	ses.lock.RLock()
-	defer ses.lock.RUnlock()
+// stopped returns true if the service is shutting down.
+func (ss *siteService) stopped() bool {
+	select {
+	case <-ss.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// siteSchedule is the current plan of a site with the next poll times.
+type siteSchedule struct {
+	ID   string               `json:"id"`
+	Name string               `json:"name"`
+	Plan *schedule.Plan       `json:"plan"`
+	Next map[string]time.Time `json:"next"`
+}
+
+func (ss *siteService) schedule() siteSchedule {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	res := siteSchedule{
+		ID:   ss.site.ID(),
+		Name: ss.staticDetails.Name,
+		Plan: ss.plan,
+		Next: make(map[string]time.Time),
+	}
+	for k, v := range ss.next {
+		res.Next[k] = v
+	}
+	return res
+}
+
+// schedule lists the plans of all sites.
+func (ses *solaredgeService) schedule(rw http.ResponseWriter, rq *http.Request) {
+	res := make([]siteSchedule, 0, len(ses.sites))
+	for _, ss := range ses.sites {
+		res = append(res, ss.schedule())
+	}
+	rw.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(res)
+}
+
+// siteRefresh polls all endpoints of the site now and makes a new plan.
+func (ss *siteService) siteRefresh(rw http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodPost {
+		rw.Header().Add("allow", http.MethodPost)
+		http.Error(rw, "use POST to refresh the site", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case ss.refresh <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (ss *siteService) sitePowerFlow(rw http.ResponseWriter, rq *http.Request) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	rw.Header().Add("content-type", "application/json")
+	setAge(rw, ss.flowFetched)
+	_ = json.NewEncoder(rw).Encode(powerFlowResponse{&ss.currentPowerFlow, ss.flowFreshness(time.Now())})
+}
+
+func (ss *siteService) siteFlow(rw http.ResponseWriter, rq *http.Request) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
 
+	fd := genFlowData(ss.currentPowerFlow)
+	fd.Freshness = ss.flowFreshness(time.Now())
 	rw.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(rw).Encode(ses.currentPowerFlow)
+	setAge(rw, ss.flowFetched)
+	_ = json.NewEncoder(rw).Encode(fd)
 }
 
-func (ses *solaredgeService) siteFlow(rw http.ResponseWriter, rq *http.Request) {
-	ses.lock.RLock()
-	defer ses.lock.RUnlock()
+func (ss *siteService) siteOverview(rw http.ResponseWriter, rq *http.Request) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
 
 	rw.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(rw).Encode(genFlowData(ses.currentPowerFlow))
+	setAge(rw, ss.overviewFetched)
+	_ = json.NewEncoder(rw).Encode(overviewResponse{&ss.currentOverview, ss.overviewFreshness(time.Now())})
 }
 
-func (ses *solaredgeService) siteOverview(rw http.ResponseWriter, rq *http.Request) {
-	ses.lock.RLock()
-	defer ses.lock.RUnlock()
+func (ss *siteService) siteDetails(rw http.ResponseWriter, rq *http.Request) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
 
 	rw.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(rw).Encode(ses.currentOverview)
+	setAge(rw, ss.detailsFetched)
+	_ = json.NewEncoder(rw).Encode(&ss.staticDetails)
 }
 
-func (ses *solaredgeService) siteDetails(rw http.ResponseWriter, rq *http.Request) {
-	ses.lock.RLock()
-	defer ses.lock.RUnlock()
+// siteInventory answers with the devices of the site. They do not change, so
+// the inventory is only fetched once.
+func (ss *siteService) siteInventory(rw http.ResponseWriter, rq *http.Request) {
+	ss.inventoryLock.Lock()
+	defer ss.inventoryLock.Unlock()
 
+	if ss.inventory == nil {
+		start := time.Now()
+		inv, err := ss.site.Inventory()
+		ss.observe("inventory", start, err)
+		if err != nil {
+			log.Error().Err(err).Str("site", ss.site.ID()).Msg("cannot query inventory")
+			http.Error(rw, fmt.Sprintf("cannot query inventory: %v", err), http.StatusBadGateway)
+			return
+		}
+		ss.inventory = inv
+	}
 	rw.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(rw).Encode(ses.staticDetails)
+	_ = json.NewEncoder(rw).Encode(ss.inventory)
 }
 
-func serveService(siteid string) {
-	sic, err := solaredge.SiteFromIDs(viper.GetString("apikey"), siteid, solaredge.WithBaseURL(viper.GetString("baseurl")))
+// openAPI answers with the OpenAPI description of the endpoints.
+func openAPI(rw http.ResponseWriter, rq *http.Request) {
+	rw.Header().Add("content-type", "application/json")
+	_, _ = rw.Write(serveapi.OpenAPI)
+}
+
+func serveService(siteids []string) error {
+	if streamHeartbeat <= 0 {
+		return fmt.Errorf("invalid stream heartbeat %s, must be positive", streamHeartbeat)
+	}
+	sec, err := solaredge.ClientFromKey(viper.GetString("apikey"), clientOptions()...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot create client")
 	}
 
-	srv, err := newSolaredgeService(sic)
+	var opts []serviceOpt
+	if historyDir != "" {
+		var hopts []history.Opt
+		if historyRetention > 0 {
+			hopts = append(hopts, history.WithRetention(history.KindPowerFlow, historyRetention))
+		}
+		hs, err := history.Open(historyDir, hopts...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot open history")
+		}
+		opts = append(opts, withHistory(hs))
+	}
+
+	if stateFile != "" {
+		st, err := loadState(stateFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot load state")
+		}
+		opts = append(opts, withState(st))
+	}
+
+	iw, err := newInfluxWriter()
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot create influx writer")
+	}
+	if iw != nil {
+		defer iw.Close()
+		opts = append(opts, withInflux(iw))
+	}
+
+	if alertRules != "" || alertConfig != nil {
+		e, err := newAlertEngine()
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot load alert rules")
+		}
+		opts = append(opts, withAlerts(e))
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cr, err := newCertReloader(tlsCert, tlsKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot load certificate")
+		}
+		opts = append(opts, withTLS(cr))
+	}
+
+	if authFile != "" {
+		ac, err := loadAuthConfig(authFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot load auth file")
+		}
+		opts = append(opts, withAuth(ac))
+	}
+
+	if modbusListen != "" {
+		l, err := net.Listen("tcp", modbusListen)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot listen for modbus")
+		}
+		opts = append(opts, withModbusListener(l))
+	}
+
+	if mqttBroker != "" {
+		pub, err := newPublisher()
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot connect to mqtt broker")
+		}
+		defer pub.Close()
+		opts = append(opts, withPublisher(pub))
+	}
+
+	srv, err := newSolaredgeService(sec, siteids, opts...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot start solaredge service")
 	}
-	srv.listen(listen)
+	// the deferred closes flush the sinks after the service stopped
+	return srv.run(listen)
 }
 
 func unitFactor(unit string) float64 {
@@ -261,18 +1068,11 @@ func flowDirection(c solaredge.PowerFlowConnection) (float64, bool) {
 	return 0, false
 }
 
-type flowdata struct {
-	PV      float64 `json:"pv"`
-	Grid    float64 `json:"grid"`
-	Battery float64 `json:"battery"`
-	SoC     float64 `json:"soc"`
-}
-
-func genFlowData(pf solaredge.PowerFlow) flowdata {
+func genFlowData(pf solaredge.PowerFlow) serveapi.FlowData {
 	battscale := -1.0
 	unitscale := unitFactor(pf.Unit)
 
-	var res flowdata
+	var res serveapi.FlowData
 	if pf.PV != nil {
 		res.PV = pf.PV.CurrentPower * unitscale
 	}
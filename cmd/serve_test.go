@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/metrics"
+)
+
+// within returns true if a and b differ by less than d.
+func within(a, b time.Time, d time.Duration) bool {
+	return a.Sub(b) < d && b.Sub(a) < d
+}
+
+func TestNewSchedulerWindow(t *testing.T) {
+	defer func(c []string, w string) { coordinates, dayWindow = c, w }(coordinates, dayWindow)
+	dayWindow = "07:30-19:00"
+
+	var site solaredge.Site
+	site.Id = 1
+	site.Location.TimeZone = "Europe/Vienna"
+	wien, err := time.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2023, 6, 21, 12, 0, 0, 0, wien)
+	for _, tc := range []struct {
+		name        string
+		coordinates []string
+		start, end  time.Time
+	}{
+		{"no coordinates", nil, time.Date(2023, 6, 21, 7, 30, 0, 0, wien), time.Date(2023, 6, 21, 19, 0, 0, 0, wien)},
+		// only the coordinates of another site are known
+		{"coordinates of another site", []string{"2=48.2082,16.3738"}, time.Date(2023, 6, 21, 7, 30, 0, 0, wien), time.Date(2023, 6, 21, 19, 0, 0, 0, wien)},
+		{"coordinates of all sites", []string{"48.2082,16.3738"}, time.Date(2023, 6, 21, 4, 53, 0, 0, wien), time.Date(2023, 6, 21, 20, 58, 0, 0, wien)},
+		{"coordinates of the site", []string{"0,0", "1=48.2082,16.3738"}, time.Date(2023, 6, 21, 4, 53, 0, 0, wien), time.Date(2023, 6, 21, 20, 58, 0, 0, wien)},
+	} {
+		coordinates = tc.coordinates
+		s, err := newScheduler(site)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		start, end := s.Daylight(day)
+		if !within(start, tc.start, 2*time.Minute) || !within(end, tc.end, 2*time.Minute) {
+			t.Errorf("%s: daylight is %v - %v, want %v - %v", tc.name, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// slowSource answers the powerflow when it is released.
+type slowSource struct {
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *slowSource) PowerFlow() (*solaredge.PowerFlow, error) {
+	s.reading <- struct{}{}
+	<-s.release
+	return &solaredge.PowerFlow{Unit: "kW"}, nil
+}
+
+func TestFetchPowerFlowUnlocked(t *testing.T) {
+	sc, err := solaredge.SiteFromIDs("key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &slowSource{reading: make(chan struct{}), release: make(chan struct{})}
+	ss := &siteService{
+		site:       sc,
+		source:     src,
+		exporter:   metrics.NewExporter(func() []metrics.SiteSnapshot { return nil }),
+		lastErrors: make(map[string]error),
+	}
+	done := make(chan error)
+	go func() {
+		_, err := ss.fetchPowerFlow()
+		done <- err
+	}()
+
+	// the current powerflow can be read while the source is read
+	<-src.reading
+	read := make(chan solaredge.PowerFlow)
+	go func() { read <- ss.powerFlow() }()
+	select {
+	case pf := <-read:
+		if pf.Unit != "" {
+			t.Errorf("powerflow is %+v before the read finished", pf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("powerflow is locked during the read of the source")
+	}
+	close(src.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if pf := ss.powerFlow(); pf.Unit != "kW" || ss.flowChanged.IsZero() {
+		t.Errorf("powerflow is %+v, changed at %v", pf, ss.flowChanged)
+	}
+}
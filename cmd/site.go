@@ -1,7 +1,8 @@
 package main
 
 import (
-	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/export"
 )
 
 var (
@@ -79,6 +81,25 @@ var (
 			siteOverview()
 		},
 	}
+	inverterData = &cobra.Command{
+		Use:   "inverterdata <serialnumber>",
+		Short: "query the technical data of an inverter",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			start, end := getStartEnd()
+			siteInverterData(args[0], start, end)
+		},
+	}
+	format     string
+	layout     string
+	outputFile string
+	dataPeriod = &cobra.Command{
+		Use:   "dataperiod",
+		Short: "query the first and last day with data",
+		Run: func(cmd *cobra.Command, args []string) {
+			siteDataPeriod()
+		},
+	}
 )
 
 func getStartEnd() (time.Time, time.Time) {
@@ -106,6 +127,8 @@ func getStartEnd() (time.Time, time.Time) {
 func init() {
 	siteCmd.PersistentFlags().String("siteid", "", "your site id to query")
 	_ = viper.BindPFlag("siteid", siteCmd.PersistentFlags().Lookup("siteid"))
+	siteCmd.PersistentFlags().StringVarP(&output, "output", "o", "json", "the output: json, yaml, table or template")
+	siteCmd.PersistentFlags().StringVar(&outputTemplate, "template", "", "the go text/template for the template output, e.g. '{{.STORAGE.chargeLevel}}'")
 	storageData.PersistentFlags().StringVar(&startTime, "start", "", "the start time for the query or 1h in the past if empty, RFC3339")
 	storageData.PersistentFlags().StringVar(&endTime, "end", "", "the end time for the query or 'now' if empty, RFC3339")
 	storageData.PersistentFlags().StringVar(&since, "since", "1h", "the start of the query time range")
@@ -115,10 +138,18 @@ func init() {
 	energyDetails.PersistentFlags().StringVar(&startTime, "start", "", "the start time for the query or 1h in the past if empty, RFC3339")
 	energyDetails.PersistentFlags().StringVar(&endTime, "end", "", "the end time for the query or 'now' if empty, RFC3339")
 	energyDetails.PersistentFlags().StringVar(&since, "since", "1h", "the start of the query time range")
+	inverterData.PersistentFlags().StringVar(&startTime, "start", "", "the start time for the query or 1h in the past if empty, RFC3339")
+	inverterData.PersistentFlags().StringVar(&endTime, "end", "", "the end time for the query or 'now' if empty, RFC3339")
+	inverterData.PersistentFlags().StringVar(&since, "since", "1h", "the start of the query time range")
+	for _, c := range []*cobra.Command{storageData, powerDetails, energyDetails, inverterData} {
+		c.PersistentFlags().StringVar(&format, "format", "json", "the table format: csv, jsonl, parquet or influx (line protocol), json uses the --output")
+		c.PersistentFlags().StringVar(&layout, "layout", "wide", "the table layout for csv, jsonl and parquet: wide or long")
+		c.PersistentFlags().StringVarP(&outputFile, "file", "f", "", "write the output to this file instead of stdout")
+	}
 }
 
 func siteClient() *solaredge.SiteClient {
-	sic, err := solaredge.SiteFromIDs(viper.GetString("apikey"), viper.GetString("siteid"), solaredge.WithBaseURL(viper.GetString("baseurl")))
+	sic, err := solaredge.SiteFromIDs(viper.GetString("apikey"), viper.GetString("siteid"), clientOptions()...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot create client")
 	}
@@ -130,7 +161,7 @@ func siteDetails() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query details")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	printSiteOutput(det)
 }
 
 func siteInventory() {
@@ -138,7 +169,54 @@ func siteInventory() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query inventory")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	printSiteOutput(det)
+}
+
+func printSiteOutput(det any) {
+	if err := printOutput(os.Stdout, det); err != nil {
+		log.Fatal().Err(err).Msg("cannot write output")
+	}
+}
+
+// writeSeries prints the queried data as json or the series as a table in the
+// selected format. The name is used for the measurement of the line protocol and
+// InfluxDB.
+func writeSeries(det any, name string, series *export.Series) {
+	siteid := viper.GetString("siteid")
+	pushSeries(name, siteid, series)
+
+	var out io.Writer = os.Stdout
+	if outputFile != "" {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", outputFile).Msg("cannot create output file")
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		if err := printOutput(out, det); err != nil {
+			log.Fatal().Err(err).Msg("cannot write output")
+		}
+		return
+	}
+	if format == "influx" {
+		if err := writeLineProtocol(out, seriesPoints(name, siteid, series)); err != nil {
+			log.Fatal().Err(err).Msg("cannot write output")
+		}
+		return
+	}
+	f, err := export.ParseFormat(format)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot write output")
+	}
+	l, err := export.ParseLayout(layout)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot write output")
+	}
+	if err := series.Table(l).Write(out, f); err != nil {
+		log.Fatal().Err(err).Msg("cannot write output")
+	}
 }
 
 func siteStorageData(start, end time.Time) {
@@ -146,7 +224,7 @@ func siteStorageData(start, end time.Time) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query storage data")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	writeSeries(det, "storage", export.Storage(det))
 }
 
 func sitePowerDetails(start, end time.Time) {
@@ -154,7 +232,7 @@ func sitePowerDetails(start, end time.Time) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query power details")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	writeSeries(det, "power", export.Meters(det.Meters))
 }
 
 func siteEnergyDetails(unit solaredge.TimeUnit, start, end time.Time) {
@@ -162,7 +240,15 @@ func siteEnergyDetails(unit solaredge.TimeUnit, start, end time.Time) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query energy details")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	writeSeries(det, "energy", export.Meters(det.Meters))
+}
+
+func siteInverterData(sn string, start, end time.Time) {
+	det, err := siteClient().InverterData(sn, start, end)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot query inverter data")
+	}
+	writeSeries(det, "inverter", export.Inverter(det))
 }
 
 func sitePowerflow() {
@@ -170,7 +256,7 @@ func sitePowerflow() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query powerflow")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	printSiteOutput(det)
 }
 
 func siteOverview() {
@@ -178,5 +264,13 @@ func siteOverview() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot query overview")
 	}
-	fmt.Printf("%s", dumpAsJson(det))
+	printSiteOutput(det)
+}
+
+func siteDataPeriod() {
+	det, err := siteClient().DataPeriod()
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot query data period")
+	}
+	printSiteOutput(det)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/serveapi"
+)
+
+var (
+	staleAfter  time.Duration
+	staleGauges string
+)
+
+func init() {
+	serveCmd.PersistentFlags().DurationVar(&staleAfter, "stale-after", time.Hour, "the data of a site is stale if the powerflow did not change or the overview was not updated for this long during the daylight, never if 0")
+	serveCmd.PersistentFlags().StringVar(&staleGauges, "stale-gauges", "keep", "the power gauges of stale sites: keep the last values, nan or blank to omit them")
+}
+
+// freshness returns the freshness of data which last changed at changed.
+func freshness(changed, now time.Time) serveapi.Freshness {
+	if changed.IsZero() {
+		return serveapi.Freshness{}
+	}
+	return serveapi.Freshness{
+		Stale:   staleAfter > 0 && now.Sub(changed) > staleAfter,
+		Changed: &changed,
+	}
+}
+
+// daylightFreshness is the freshness of data which last changed at changed,
+// but only the time during the daylight counts.
+func (ss *siteService) daylightFreshness(changed, now time.Time) serveapi.Freshness {
+	res := freshness(changed, now)
+	if res.Stale {
+		start, end := ss.scheduler.Daylight(now)
+		from, to := changed, now
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		res.Stale = to.Sub(from) > staleAfter
+	}
+	return res
+}
+
+// flowFreshness tells if the powerflow did not change for too long, the caller
+// must hold the lock. solaredge repeats the last powerflow while the inverter
+// is offline. The powerflow of a site without a consumption meter does not
+// change at night either, so only the time during the daylight counts.
+func (ss *siteService) flowFreshness(now time.Time) serveapi.Freshness {
+	return ss.daylightFreshness(ss.flowChanged, now)
+}
+
+// overviewFreshness tells if the last update time of the overview is too old,
+// the caller must hold the lock. The inverter sleeps at night and does not
+// update the overview, so only the time during the daylight counts.
+func (ss *siteService) overviewFreshness(now time.Time) serveapi.Freshness {
+	return ss.daylightFreshness(time.Time(ss.currentOverview.LastUpdateTime), now)
+}
+
+// powerFlowResponse is the powerflow with its freshness.
+type powerFlowResponse struct {
+	*solaredge.PowerFlow
+	serveapi.Freshness
+}
+
+// overviewResponse is the overview with its freshness.
+type overviewResponse struct {
+	*solaredge.OverviewData
+	serveapi.Freshness
+}
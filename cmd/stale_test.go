@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+)
+
+func TestFreshness(t *testing.T) {
+	defer func(c []string, w string, d time.Duration) {
+		coordinates, dayWindow, staleAfter = c, w, d
+	}(coordinates, dayWindow, staleAfter)
+	coordinates, dayWindow, staleAfter = nil, "08:00-20:00", time.Hour
+
+	var site solaredge.Site
+	site.Location.TimeZone = "Europe/Vienna"
+	s, err := newScheduler(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wien := mustZone(t, site.Location.TimeZone)
+	at := func(d, h, m int) time.Time { return time.Date(2023, 6, d, h, m, 0, 0, wien) }
+	for _, tc := range []struct {
+		name         string
+		changed, now time.Time
+		stale        bool
+	}{
+		{"never changed", time.Time{}, at(21, 12, 0), false},
+		{"fresh", at(21, 11, 30), at(21, 12, 0), false},
+		{"old", at(21, 10, 0), at(21, 12, 0), true},
+		// the night before the sunrise does not count
+		{"yesterday evening", at(20, 19, 30), at(21, 8, 50), false},
+		{"yesterday", at(20, 19, 30), at(21, 9, 30), true},
+		{"night", at(21, 15, 0), at(21, 23, 0), true},
+		{"evening", at(21, 19, 30), at(21, 23, 0), false},
+		{"early morning", at(20, 12, 0), at(21, 6, 0), false},
+	} {
+		ss := &siteService{scheduler: s, flowChanged: tc.changed}
+		ss.currentOverview.LastUpdateTime = solaredge.SETime(tc.changed)
+		for kind, f := range map[string]func(time.Time) bool{
+			"flow":     func(now time.Time) bool { return ss.flowFreshness(now).Stale },
+			"overview": func(now time.Time) bool { return ss.overviewFreshness(now).Stale },
+		} {
+			if stale := f(tc.now); stale != tc.stale {
+				t.Errorf("%s: %s is stale %v, want %v", tc.name, kind, stale, tc.stale)
+			}
+		}
+	}
+
+	// without a threshold nothing is stale
+	staleAfter = 0
+	ss := &siteService{scheduler: s, flowChanged: at(20, 12, 0)}
+	if ss.flowFreshness(at(21, 12, 0)).Stale {
+		t.Error("flow is stale without a threshold")
+	}
+}
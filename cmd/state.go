@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ulrichSchreiner/solaredge"
+)
+
+const (
+	// stateDetailsMaxAge is the age of restored details after which they are
+	// fetched again
+	stateDetailsMaxAge = 24 * time.Hour
+)
+
+var (
+	stateFile string
+)
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&stateFile, "state", "", "keep the last fetched data in this file and restore it on startup instead of calling the API")
+}
+
+// siteState is the last fetched data of a site with the fetch times.
+type siteState struct {
+	Details         *solaredge.Site         `json:"details,omitempty"`
+	DetailsFetched  time.Time               `json:"detailsFetched"`
+	PowerFlow       *solaredge.PowerFlow    `json:"powerflow,omitempty"`
+	FlowFetched     time.Time               `json:"flowFetched"`
+	FlowChanged     time.Time               `json:"flowChanged"`
+	Overview        *solaredge.OverviewData `json:"overview,omitempty"`
+	OverviewFetched time.Time               `json:"overviewFetched"`
+	// Calls are the API calls of the day of CallsAt
+	Calls   int       `json:"calls"`
+	CallsAt time.Time `json:"callsAt"`
+}
+
+// stateStore keeps the state of all sites in a file. The file is written after
+// every change, so it is current when the process is killed.
+type stateStore struct {
+	lock  sync.Mutex
+	path  string
+	Sites map[string]*siteState `json:"sites"`
+}
+
+// loadState reads the state file, a missing file is an empty state.
+func loadState(path string) (*stateStore, error) {
+	res := &stateStore{path: path, Sites: make(map[string]*siteState)}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state: %w", err)
+	}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("cannot parse state %q: %w", path, err)
+	}
+	if res.Sites == nil {
+		res.Sites = make(map[string]*siteState)
+	}
+	return res, nil
+}
+
+// site returns a copy of the state of the site.
+func (st *stateStore) site(id string) siteState {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if s, ok := st.Sites[id]; ok {
+		return *s
+	}
+	return siteState{}
+}
+
+// update changes the state of the site and writes the file.
+func (st *stateStore) update(id string, change func(s *siteState)) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	s, ok := st.Sites[id]
+	if !ok {
+		s = &siteState{}
+		st.Sites[id] = s
+	}
+	change(s)
+	return st.save()
+}
+
+// save replaces the file atomically, so a crash leaves the old state.
+func (st *stateStore) save() error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("cannot marshal state: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), st.path); err != nil {
+		return fmt.Errorf("cannot write state: %w", err)
+	}
+	return nil
+}
+
+// persist changes the stored state of the site if a state file is used.
+func (ss *siteService) persist(change func(s *siteState)) {
+	if ss.state == nil {
+		return
+	}
+	calls := ss.site.CallsToday(ss.site.ID())
+	err := ss.state.update(ss.site.ID(), func(s *siteState) {
+		change(s)
+		s.Calls = calls
+		s.CallsAt = time.Now()
+	})
+	if err != nil {
+		log.Error().Err(err).Str("site", ss.site.ID()).Msg("cannot save state")
+	}
+}
+
+// restore uses the data of the last run. It returns false if the details are
+// missing or too old, so they must be fetched.
+func (ss *siteService) restore() bool {
+	if ss.state == nil {
+		return false
+	}
+	st := ss.state.site(ss.site.ID())
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	ss.site.AddCalls(ss.site.ID(), st.CallsAt, st.Calls)
+	if st.PowerFlow != nil {
+		ss.currentPowerFlow = *st.PowerFlow
+		ss.flowFetched = st.FlowFetched
+		ss.flowChanged = st.FlowChanged
+		if ss.flowChanged.IsZero() {
+			ss.flowChanged = st.FlowFetched
+		}
+	}
+	if st.Overview != nil {
+		ss.currentOverview = *st.Overview
+		ss.overviewFetched = st.OverviewFetched
+	}
+	restored := st.Details != nil && time.Since(st.DetailsFetched) < stateDetailsMaxAge
+	if restored {
+		ss.staticDetails = *st.Details
+		ss.detailsFetched = st.DetailsFetched
+	}
+	log.Info().
+		Str("site", ss.site.ID()).
+		Bool("details", restored).
+		Time("powerflow", st.FlowFetched).
+		Time("overview", st.OverviewFetched).
+		Int("calls", ss.site.CallsToday(ss.site.ID())).
+		Msg("restored state")
+	return restored
+}
+
+// fetched returns the time of the last fetch of the endpoint.
+func (ss *siteService) fetched(endpoint string) time.Time {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	switch endpoint {
+	case "powerflow":
+		return ss.flowFetched
+	case "overview":
+		return ss.overviewFetched
+	}
+	return time.Time{}
+}
+
+// setAge marks the response with the time of the fetch, restored data can be
+// older than the last poll.
+func setAge(rw http.ResponseWriter, fetched time.Time) {
+	if fetched.IsZero() {
+		return
+	}
+	age := time.Since(fetched)
+	if age < 0 {
+		age = 0
+	}
+	rw.Header().Set("last-modified", fetched.UTC().Format(http.TimeFormat))
+	rw.Header().Set("age", strconv.Itoa(int(age.Seconds())))
+}
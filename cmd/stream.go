@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	streamFlow     = "flow"
+	streamOverview = "overview"
+	// streamBuffer is the number of events a client may lag behind before it is
+	// disconnected
+	streamBuffer = 32
+)
+
+var (
+	streamHeartbeat time.Duration
+	upgrader        = websocket.Upgrader{}
+)
+
+func init() {
+	serveCmd.PersistentFlags().DurationVar(&streamHeartbeat, "stream-heartbeat", 30*time.Second, "the interval of the heartbeats on idle streams")
+}
+
+// streamEvent is a message of the stream with the new data of a site.
+type streamEvent struct {
+	Type string    `json:"type"`
+	Site string    `json:"site"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// streamHub distributes the events to all connected clients.
+type streamHub struct {
+	lock    sync.Mutex
+	clients map[chan streamEvent]bool
+	closed  bool
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{clients: make(map[chan streamEvent]bool)}
+}
+
+func (h *streamHub) subscribe() chan streamEvent {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	c := make(chan streamEvent, streamBuffer)
+	if h.closed {
+		close(c)
+		return c
+	}
+	h.clients[c] = true
+	return c
+}
+
+// close disconnects all clients, new clients are disconnected immediately.
+func (h *streamHub) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+func (h *streamHub) unsubscribe(c chan streamEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+// publish sends the event to all clients. A client which cannot keep up is
+// disconnected, it gets the current state again when it reconnects.
+func (h *streamHub) publish(ev streamEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for c := range h.clients {
+		select {
+		case c <- ev:
+		default:
+			delete(h.clients, c)
+			close(c)
+		}
+	}
+}
+
+// flowEvent returns the current flowdata of the site, ok is false if the
+// powerflow was not fetched yet.
+func (ss *siteService) flowEvent() (streamEvent, bool) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	fd := genFlowData(ss.currentPowerFlow)
+	fd.Freshness = ss.flowFreshness(time.Now())
+	return streamEvent{
+		Type: streamFlow,
+		Site: ss.site.ID(),
+		Name: ss.staticDetails.Name,
+		Time: ss.flowFetched,
+		Data: fd,
+	}, !ss.flowFetched.IsZero()
+}
+
+// overviewEvent returns the current overview of the site, ok is false if the
+// overview was not fetched yet.
+func (ss *siteService) overviewEvent() (streamEvent, bool) {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	ov := ss.currentOverview
+	return streamEvent{
+		Type: streamOverview,
+		Site: ss.site.ID(),
+		Name: ss.staticDetails.Name,
+		Time: ss.overviewFetched,
+		Data: overviewResponse{&ov, ss.overviewFreshness(time.Now())},
+	}, !ss.overviewFetched.IsZero()
+}
+
+// publishStream pushes the current data of the kind to the stream clients.
+func (ss *siteService) publishStream(kind string) {
+	if ss.stream == nil {
+		return
+	}
+	var ev streamEvent
+	var ok bool
+	switch kind {
+	case streamFlow:
+		ev, ok = ss.flowEvent()
+	case streamOverview:
+		ev, ok = ss.overviewEvent()
+	}
+	if ok {
+		ss.stream.publish(ev)
+	}
+}
+
+// currentEvents returns the current state of the sites as the first events of
+// a stream.
+func currentEvents(sites []*siteService) []streamEvent {
+	var res []streamEvent
+	for _, ss := range sites {
+		if ev, ok := ss.flowEvent(); ok {
+			res = append(res, ev)
+		}
+		if ev, ok := ss.overviewEvent(); ok {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// stream pushes the new data of all sites, see serveStream.
+func (ses *solaredgeService) stream(rw http.ResponseWriter, rq *http.Request) {
+	ses.serveStream(rw, rq, ses.sites)
+}
+
+// serveStream pushes the current data and every update of the sites to the
+// client. WebSocket clients get every event as a JSON message, all other
+// clients get server-sent events.
+func (ses *solaredgeService) serveStream(rw http.ResponseWriter, rq *http.Request, sites []*siteService) {
+	filter := make(map[string]bool)
+	for _, ss := range sites {
+		filter[ss.site.ID()] = true
+	}
+	events := ses.streamHub.subscribe()
+	defer ses.streamHub.unsubscribe(events)
+
+	// the first events carry the current state, updates which arrive in the
+	// meantime are sent afterwards
+	initial := currentEvents(sites)
+	if websocket.IsWebSocketUpgrade(rq) {
+		streamWebSocket(rw, rq, filter, initial, events)
+	} else {
+		streamSSE(rw, rq, filter, initial, events)
+	}
+}
+
+func streamSSE(rw http.ResponseWriter, rq *http.Request, filter map[string]bool, initial []streamEvent, events chan streamEvent) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("content-type", "text/event-stream")
+	rw.Header().Set("cache-control", "no-cache")
+	rw.Header().Set("connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+
+	send := func(ev streamEvent) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("cannot marshal %s event: %w", ev.Type, err)
+		}
+		if _, err := fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	for _, ev := range initial {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-rq.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter[ev.Site] {
+				continue
+			}
+			if err := send(ev); err != nil {
+				log.Debug().Err(err).Msg("cannot send event to stream")
+				return
+			}
+		case <-heartbeat.C:
+			// a comment keeps proxies from closing the idle connection
+			if _, err := fmt.Fprint(rw, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func streamWebSocket(rw http.ResponseWriter, rq *http.Request, filter map[string]bool, initial []streamEvent, events chan streamEvent) {
+	conn, err := upgrader.Upgrade(rw, rq, nil)
+	if err != nil {
+		// the upgrader already responded with an error
+		log.Debug().Err(err).Msg("cannot upgrade to websocket")
+		return
+	}
+	defer conn.Close()
+
+	// the client only answers the pings, anything else is ignored; a client
+	// which misses two heartbeats is gone
+	closed := make(chan struct{})
+	_ = conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(ev streamEvent) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(streamHeartbeat))
+		return conn.WriteJSON(ev)
+	}
+	for _, ev := range initial {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reconnect"), time.Now().Add(time.Second))
+				return
+			}
+			if !filter[ev.Site] {
+				continue
+			}
+			if err := send(ev); err != nil {
+				log.Debug().Err(err).Msg("cannot send event to websocket")
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamHeartbeat)); err != nil {
+				return
+			}
+		}
+	}
+}
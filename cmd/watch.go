@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/serveapi"
+)
+
+const (
+	sparkChars   = "▁▂▃▄▅▆▇█"
+	sparkSamples = 40
+	socBarWidth  = 30
+)
+
+var (
+	watchServe         string
+	watchServeToken    string
+	watchInterval      time.Duration
+	watchServeInterval time.Duration
+	watchOverview      time.Duration
+	watchCmd           = &cobra.Command{
+		Use:   "watch",
+		Short: "show a live dashboard of the power flow",
+		Run: func(cmd *cobra.Command, args []string) {
+			siteWatch()
+		},
+	}
+)
+
+func init() {
+	watchCmd.PersistentFlags().StringVar(&watchServe, "serve", "http://localhost:7777", "the URL of a running serve instance, the API is used if it is not reachable")
+	watchCmd.PersistentFlags().StringVar(&watchServeToken, "serve-token", "", "the bearer token for a serve instance with authentication")
+	watchCmd.PersistentFlags().DurationVar(&watchInterval, "interval", 5*time.Minute, "the refresh interval when the API is used")
+	watchCmd.PersistentFlags().DurationVar(&watchServeInterval, "serve-interval", 10*time.Second, "the refresh interval when a serve instance is used")
+	watchCmd.PersistentFlags().DurationVar(&watchOverview, "overview", 15*time.Minute, "the refresh interval of the overview when the API is used")
+}
+
+// watchState contains everything which is shown on the dashboard.
+type watchState struct {
+	site        string
+	source      string
+	serve       *serveapi.Client
+	flow        *solaredge.PowerFlow
+	overview    *solaredge.OverviewData
+	overviewAt  time.Time
+	fetched     time.Time
+	err         error
+	pv          []float64
+	load        []float64
+	grid        []float64
+	battery     []float64
+	lastFlowAPI time.Time
+}
+
+func newServeClient() *serveapi.Client {
+	opts := []serveapi.Opt{serveapi.WithHTTPClient(&http.Client{Timeout: 3 * time.Second})}
+	if watchServeToken != "" {
+		opts = append(opts, serveapi.WithToken(watchServeToken))
+	}
+	return serveapi.NewClient(watchServe, opts...)
+}
+
+// refresh fetches new data from serve if possible, otherwise from the API when
+// the interval is over. It returns the time to wait for the next refresh.
+func (ws *watchState) refresh(sc *solaredge.SiteClient) time.Duration {
+	if watchServe != "" {
+		pf, err := ws.serve.PowerFlow(ws.site)
+		var ov *solaredge.OverviewData
+		if err == nil {
+			ov, err = ws.serve.Overview(ws.site)
+		}
+		if err == nil {
+			ws.source = "serve " + watchServe
+			ws.update(pf)
+			ws.overview = ov
+			ws.err = nil
+			return watchServeInterval
+		}
+	}
+
+	ws.source = "solaredge API"
+	flowInterval, overviewInterval := apiIntervals(sc, time.Now())
+	if time.Since(ws.lastFlowAPI) >= flowInterval {
+		ws.lastFlowAPI = time.Now()
+		pf, err := sc.PowerFlow()
+		ws.err = err
+		if err == nil {
+			ws.update(pf)
+		}
+	}
+	if ws.overview == nil || time.Since(ws.overviewAt) >= overviewInterval {
+		ov, err := sc.Overview()
+		if err != nil {
+			ws.err = err
+		} else {
+			ws.overview = ov
+			ws.overviewAt = time.Now()
+		}
+	}
+	// check for a serve instance more often than the API is called
+	if watchServe != "" && watchServeInterval < flowInterval {
+		return watchServeInterval
+	}
+	return flowInterval
+}
+
+// apiIntervals returns the refresh intervals of the powerflow and the overview
+// when the API is used. They are stretched, so that the remaining quota of the
+// site lasts until the end of the day; only the calls of this process are known.
+func apiIntervals(sc *solaredge.SiteClient, now time.Time) (flow, overview time.Duration) {
+	flow, overview = watchInterval, watchOverview
+	loc, err := time.LoadLocation(solaredge.SiteZone)
+	if err != nil {
+		loc = time.Local
+	}
+	now = now.In(loc)
+	y, m, d := now.Date()
+	left := time.Date(y, m, d+1, 0, 0, 0, 0, loc).Sub(now)
+	need := float64(left)/float64(flow) + float64(left)/float64(overview)
+	remaining := float64(sc.RemainingQuota())
+	if need <= remaining {
+		return flow, overview
+	}
+	if remaining < 1 {
+		return left, left
+	}
+	f := need / remaining
+	return time.Duration(float64(flow) * f), time.Duration(float64(overview) * f)
+}
+
+func appendSample(samples []float64, v float64) []float64 {
+	samples = append(samples, v)
+	if len(samples) > sparkSamples {
+		samples = samples[len(samples)-sparkSamples:]
+	}
+	return samples
+}
+
+func (ws *watchState) update(pf *solaredge.PowerFlow) {
+	// serve returns the same powerflow until it fetched a new one
+	if ws.flow != nil && dumpAsJson(ws.flow) == dumpAsJson(pf) {
+		return
+	}
+	ws.flow = pf
+	ws.fetched = time.Now()
+	fd := genFlowData(*pf)
+	ws.pv = appendSample(ws.pv, fd.PV)
+	ws.load = appendSample(ws.load, pf.Load.CurrentPower*unitFactor(pf.Unit))
+	ws.grid = appendSample(ws.grid, fd.Grid)
+	ws.battery = appendSample(ws.battery, fd.Battery)
+}
+
+func sparkline(samples []float64) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	lo, hi := samples[0], samples[0]
+	for _, s := range samples {
+		if s < lo {
+			lo = s
+		}
+		if s > hi {
+			hi = s
+		}
+	}
+	chars := []rune(sparkChars)
+	var sb strings.Builder
+	for _, s := range samples {
+		idx := 0
+		if hi > lo {
+			idx = int((s - lo) / (hi - lo) * float64(len(chars)-1))
+		}
+		sb.WriteRune(chars[idx])
+	}
+	return sb.String()
+}
+
+func bar(percent, width int) string {
+	full := percent * width / 100
+	if full > width {
+		full = width
+	}
+	if full < 0 {
+		full = 0
+	}
+	return strings.Repeat("█", full) + strings.Repeat("░", width-full)
+}
+
+// elementPower returns the power of a powerflow element in W.
+func elementPower(pf *solaredge.PowerFlow, name string) (float64, bool) {
+	scale := unitFactor(pf.Unit)
+	switch strings.ToUpper(name) {
+	case "PV":
+		if pf.PV != nil {
+			return pf.PV.CurrentPower * scale, true
+		}
+	case "LOAD":
+		return pf.Load.CurrentPower * scale, true
+	case "GRID":
+		return pf.Grid.CurrentPower * scale, true
+	case "STORAGE":
+		if pf.Storage != nil {
+			return pf.Storage.CurrentPower * scale, true
+		}
+	}
+	return 0, false
+}
+
+func (ws *watchState) render() string {
+	var sb strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&sb, format+"\x1b[K\n", args...)
+	}
+	line(" solaredge site %s   %s   source: %s", ws.site, time.Now().Format("15:04:05"), ws.source)
+	line("")
+	if ws.flow == nil {
+		line(" waiting for data ...")
+	} else {
+		pf := ws.flow
+		for _, c := range pf.Connections {
+			from, _ := elementPower(pf, c.From)
+			to, _ := elementPower(pf, c.To)
+			line("   %-8s %12s  ──▶  %-8s %12s", strings.ToUpper(c.From), formatPower(from), strings.ToUpper(c.To), formatPower(to))
+		}
+		if len(pf.Connections) == 0 {
+			line("   no power flow")
+		}
+		line("")
+		if pf.Storage != nil {
+			line("   BATTERY  [%s] %3d %%  %s", bar(pf.Storage.ChargeLevel, socBarWidth), pf.Storage.ChargeLevel, pf.Storage.Status)
+			line("")
+		}
+		line("   PV       %-*s %12s", sparkSamples, sparkline(ws.pv), formatPower(last(ws.pv)))
+		line("   LOAD     %-*s %12s", sparkSamples, sparkline(ws.load), formatPower(last(ws.load)))
+		line("   GRID     %-*s %12s", sparkSamples, sparkline(ws.grid), formatPower(last(ws.grid)))
+		if pf.Storage != nil {
+			line("   BATTERY  %-*s %12s", sparkSamples, sparkline(ws.battery), formatPower(last(ws.battery)))
+		}
+		line("")
+		line("   updated %s", ws.fetched.Format("15:04:05"))
+	}
+	if ov := ws.overview; ov != nil {
+		line("   today %s   month %s   year %s   (site update %s)",
+			formatEnergy(ov.LastDayData.Energy), formatEnergy(ov.LastMonthData.Energy),
+			formatEnergy(ov.LastYearData.Energy), localTime(ov.LastUpdateTime))
+	}
+	if ws.err != nil {
+		line("")
+		line("   error: %v", ws.err)
+	}
+	sb.WriteString("\x1b[J")
+	return sb.String()
+}
+
+func last(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[len(samples)-1]
+}
+
+func siteWatch() {
+	if watchInterval <= 0 || watchOverview <= 0 {
+		log.Fatal().Msgf("invalid refresh intervals %s and %s", watchInterval, watchOverview)
+	}
+	sc := siteClient()
+	ws := &watchState{site: sc.ID(), serve: newServeClient()}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	// use the alternate screen and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	redraw := time.NewTicker(time.Second)
+	defer redraw.Stop()
+	next := time.Now()
+	for {
+		if !time.Now().Before(next) {
+			next = time.Now().Add(ws.refresh(sc))
+		}
+		fmt.Print("\x1b[H" + ws.render())
+		select {
+		case <-sigs:
+			return
+		case <-redraw.C:
+		}
+	}
+}
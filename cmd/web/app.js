@@ -0,0 +1,341 @@
+// The dashboard of solaredge serve. It reads the same endpoints as every other
+// client; a token from the page URL (?access_token=...) is passed on to them.
+"use strict";
+
+const params = new URLSearchParams(location.search);
+const token = params.get("access_token");
+let site = params.get("site") || "";
+let stream = null;
+
+function url(path, query) {
+  const q = new URLSearchParams(query || {});
+  if (token) {
+    q.set("access_token", token);
+  }
+  const s = q.toString();
+  return path + (s ? "?" + s : "");
+}
+
+function sitePath(endpoint) {
+  return site ? "sites/" + encodeURIComponent(site) + "/" + endpoint : endpoint;
+}
+
+async function get(path, query) {
+  const rsp = await fetch(url(path, query), { headers: { accept: "application/json" } });
+  if (!rsp.ok) {
+    throw new Error(rsp.status + " " + (await rsp.text()).trim());
+  }
+  return rsp.json();
+}
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function power(w) {
+  const a = Math.abs(w);
+  if (a >= 1000) {
+    return (a / 1000).toFixed(2) + " kW";
+  }
+  return Math.round(a) + " W";
+}
+
+function energy(wh) {
+  if (wh >= 1000000) {
+    return (wh / 1000000).toFixed(2) + " MWh";
+  }
+  if (wh >= 1000) {
+    return (wh / 1000).toFixed(1) + " kWh";
+  }
+  return Math.round(wh) + " Wh";
+}
+
+function setStatus(text, cls) {
+  const s = $("status");
+  s.textContent = text;
+  s.className = "status " + (cls || "");
+}
+
+// setLine animates a line of the diagram; positive values flow in the
+// direction of the path.
+function setLine(id, w) {
+  const l = $("line-" + id);
+  l.classList.toggle("active", Math.abs(w) >= 1);
+  l.classList.toggle("reverse", w < 0);
+}
+
+// showFlow draws the flowdata: grid is positive when power is taken from the
+// grid, battery is positive while it discharges.
+function showFlow(fd) {
+  if (fd.stale) {
+    setStatus("no new data since " + new Date(fd.changed).toLocaleString(), "error");
+  } else if (stream && stream.readyState === EventSource.OPEN) {
+    setStatus("live", "live");
+  }
+  const load = fd.pv + fd.grid + fd.battery;
+  $("val-pv").textContent = power(fd.pv);
+  $("val-grid").textContent = (fd.grid < 0 ? "↑ " : "") + power(fd.grid);
+  $("val-load").textContent = power(load);
+  // the paths lead from the pv, the grid and the hub to the hub, the hub and
+  // the battery and the load
+  setLine("pv", fd.pv);
+  setLine("grid", fd.grid);
+  setLine("battery", -fd.battery);
+  setLine("load", load);
+
+  const hasBattery = fd.soc > 0 || fd.battery !== 0;
+  $("node-battery").style.display = hasBattery ? "" : "none";
+  $("line-battery").style.display = hasBattery ? "" : "none";
+  $("battery-card").hidden = !hasBattery;
+  if (hasBattery) {
+    $("val-battery").textContent = power(fd.battery);
+    $("soc").textContent = Math.round(fd.soc) + " %";
+    $("soc-bar").style.width = Math.min(100, Math.max(0, fd.soc)) + "%";
+    $("battery-state").textContent =
+      fd.battery < 0 ? "charging with " + power(fd.battery) :
+      fd.battery > 0 ? "discharging with " + power(fd.battery) : "idle";
+  }
+}
+
+function showOverview(ov) {
+  $("energy-day").textContent = energy(ov.lastDayData.energy);
+  $("energy-month").textContent = energy(ov.lastMonthData.energy);
+  $("energy-year").textContent = energy(ov.lastYearData.energy);
+  $("energy-lifetime").textContent = energy(ov.lifeTimeData.energy);
+  $("overview-time").textContent = "updated " + ov.lastUpdateTime;
+}
+
+function showDetails(det) {
+  const loc = det.location || {};
+  const rows = [
+    ["Name", det.name],
+    ["Status", det.status],
+    ["Peak power", det.peakPower ? det.peakPower + " kWp" : ""],
+    ["Location", [loc.city, loc.country].filter(Boolean).join(", ")],
+    ["Timezone", loc.timeZone],
+  ];
+  const dl = $("details");
+  dl.replaceChildren();
+  for (const [k, v] of rows) {
+    if (!v) {
+      continue;
+    }
+    const dt = document.createElement("dt");
+    dt.textContent = k;
+    const dd = document.createElement("dd");
+    dd.textContent = v;
+    dl.append(dt, dd);
+  }
+}
+
+function showInventory(inv) {
+  const ul = $("inventory");
+  ul.replaceChildren();
+  const add = (title, sub) => {
+    const li = document.createElement("li");
+    li.textContent = title;
+    if (sub) {
+      const s = document.createElement("small");
+      s.textContent = sub;
+      li.append(s);
+    }
+    ul.append(li);
+  };
+  for (const i of inv.inverters || []) {
+    add(i.name, [i.manufacturer, i.model, i.connectedOptimizers ? i.connectedOptimizers + " optimizers" : ""].filter(Boolean).join(" · "));
+  }
+  for (const b of inv.batteries || []) {
+    add(b.name, [b.manufacturer, b.model, b.nameplateCapacity ? energy(b.nameplateCapacity) : ""].filter(Boolean).join(" · "));
+  }
+  for (const m of inv.meters || []) {
+    add(m.name, [m.type, m.model].filter(Boolean).join(" · "));
+  }
+  for (const g of inv.gateways || []) {
+    add(g.name, g.firmwareVersion);
+  }
+  if (!ul.children.length) {
+    add("no devices");
+  }
+}
+
+function unavailable(id, err) {
+  const el = $(id);
+  el.replaceChildren();
+  const p = document.createElementNS(el.namespaceURI, el instanceof SVGElement ? "text" : "p");
+  p.textContent = "not available: " + err.message;
+  if (el instanceof SVGElement) {
+    p.setAttribute("x", 10);
+    p.setAttribute("y", 20);
+  } else {
+    p.className = "muted";
+  }
+  el.append(p);
+}
+
+const svgNS = "http://www.w3.org/2000/svg";
+
+function svg(parent, name, attrs, text) {
+  const el = document.createElementNS(svgNS, name);
+  for (const [k, v] of Object.entries(attrs)) {
+    el.setAttribute(k, v);
+  }
+  if (text !== undefined) {
+    el.textContent = text;
+  }
+  parent.append(el);
+  return el;
+}
+
+// parseTime parses the times of the history, which are in the zone of the site
+// without an offset.
+function parseTime(s) {
+  const [d, t] = s.split(" ");
+  const [y, m, day] = d.split("-").map(Number);
+  const [h, min, sec] = (t || "0:0:0").split(":").map(Number);
+  return new Date(y, m - 1, day, h, min, sec);
+}
+
+// chart draws the series of the rows between start and end as lines or bars
+// with one value per step. The y axis starts at 0.
+function chart(id, rows, series, opts) {
+  const el = $(id);
+  el.replaceChildren();
+  const W = 600, H = 200, left = 45, bottom = 20, top = 10;
+  const span = opts.end - opts.start;
+  const x = (t) => left + ((t - opts.start) / span) * (W - left);
+  const slot = (opts.step / span) * (W - left);
+  let max = 0;
+  for (const r of rows) {
+    for (const s of series) {
+      max = Math.max(max, r[s.key] || 0);
+    }
+  }
+  max = max || 1;
+  const y = (v) => H - bottom - (v / max) * (H - bottom - top);
+  svg(el, "line", { class: "axis", x1: left, x2: W, y1: H - bottom, y2: H - bottom });
+  svg(el, "text", { x: left - 5, y: top + 4, "text-anchor": "end" }, opts.format(max));
+  svg(el, "text", { x: left - 5, y: H - bottom, "text-anchor": "end" }, "0");
+  for (let t = opts.start; t < opts.end; t += opts.labelStep) {
+    svg(el, "text", { x: x(t), y: H - 5, "text-anchor": "middle" }, opts.label(new Date(t)));
+  }
+  if (!rows.length) {
+    svg(el, "text", { x: left + 10, y: top + 20 }, "no data");
+    return;
+  }
+
+  if (opts.bars) {
+    const bw = slot / (series.length + 1);
+    for (const r of rows) {
+      const t = parseTime(r.time).getTime();
+      series.forEach((s, j) => {
+        const v = r[s.key] || 0;
+        const bar = svg(el, "rect", { class: s.cls, x: x(t) + bw * (j + 0.5), y: y(v), width: bw, height: H - bottom - y(v) });
+        svg(bar, "title", {}, opts.format(v));
+      });
+    }
+    return;
+  }
+  for (const s of series) {
+    const d = rows.map((r, i) => (i ? "L" : "M") + x(parseTime(r.time).getTime() + opts.step / 2).toFixed(1) + " " + y(r[s.key] || 0).toFixed(1));
+    svg(el, "path", { class: s.cls, d: d.join(" ") });
+  }
+}
+
+function day(d) {
+  return d.getFullYear() + "-" + String(d.getMonth() + 1).padStart(2, "0") + "-" + String(d.getDate()).padStart(2, "0");
+}
+
+const hour = 60 * 60 * 1000;
+
+async function loadCharts() {
+  const now = new Date();
+  const today = new Date(now.getFullYear(), now.getMonth(), now.getDate());
+  const tomorrow = new Date(now.getFullYear(), now.getMonth(), now.getDate() + 1);
+  try {
+    const rows = await get(sitePath("history/flow"), { from: day(today), to: day(tomorrow), step: "15m" });
+    chart("chart-today", rows, [{ key: "pv", cls: "pv" }, { key: "load", cls: "load" }], {
+      start: today.getTime(),
+      end: tomorrow.getTime(),
+      step: hour / 4,
+      labelStep: 3 * hour,
+      format: power,
+      label: (t) => String(t.getHours()).padStart(2, "0") + ":00",
+    });
+  } catch (err) {
+    unavailable("chart-today", err);
+  }
+  try {
+    const from = new Date(now.getFullYear(), now.getMonth(), now.getDate() - 29);
+    const query = { from: day(from), to: day(tomorrow), step: "24h" };
+    let rows;
+    try {
+      // serve does not store the energy itself, missing days are loaded from the
+      // API within the fill budget, which needs the control group
+      rows = await get(sitePath("history/energy"), { ...query, fill: 1 });
+    } catch (err) {
+      if (!/^40[13] /.test(err.message)) {
+        throw err;
+      }
+      rows = await get(sitePath("history/energy"), query);
+    }
+    chart("chart-days", rows, [{ key: "Production", cls: "pv" }, { key: "Consumption", cls: "load" }], {
+      bars: true,
+      start: from.getTime(),
+      end: tomorrow.getTime(),
+      step: 24 * hour,
+      labelStep: 5 * 24 * hour,
+      format: energy,
+      label: (t) => t.getDate() + "." + (t.getMonth() + 1) + ".",
+    });
+  } catch (err) {
+    unavailable("chart-days", err);
+  }
+}
+
+function connect() {
+  if (stream) {
+    stream.close();
+  }
+  stream = new EventSource(url(sitePath("stream")));
+  stream.onopen = () => setStatus("live", "live");
+  stream.onerror = () => setStatus("reconnecting", "error");
+  stream.addEventListener("flow", (e) => showFlow(JSON.parse(e.data).data));
+  stream.addEventListener("overview", (e) => showOverview(JSON.parse(e.data).data));
+}
+
+async function load() {
+  // the stream starts with the current state, the rest is loaded once
+  connect();
+  get(sitePath("details")).then(showDetails, (err) => unavailable("details", err));
+  get(sitePath("inventory")).then(showInventory, (err) => unavailable("inventory", err));
+  loadCharts();
+}
+
+async function init() {
+  try {
+    const sites = await get("sites");
+    const sel = $("site-select");
+    if (!site && sites.length) {
+      site = sites[0].id;
+    }
+    for (const s of sites) {
+      sel.append(new Option(s.name || s.id, s.id, false, s.id === site));
+      if (s.id === site) {
+        $("site-name").textContent = s.name || s.id;
+      }
+    }
+    sel.hidden = sites.length < 2;
+    sel.onchange = () => {
+      params.set("site", sel.value);
+      location.search = params.toString();
+    };
+  } catch (err) {
+    setStatus(err.message, "error");
+    return;
+  }
+  load();
+  // the charts change slowly, the stream updates everything else
+  setInterval(loadCharts, 15 * 60 * 1000);
+}
+
+init();
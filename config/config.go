@@ -0,0 +1,315 @@
+// Package config reads the configuration file of the solaredge commands. The
+// file contains named profiles, every profile has its own API key, sites, poll
+// schedule, outputs and alert rules.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/alert"
+	"gitlab.com/ulrichSchreiner/solaredge/metrics"
+	"gitlab.com/ulrichSchreiner/solaredge/schedule"
+	"gopkg.in/yaml.v2"
+)
+
+// A Site is a site of a profile. The timezone and the coordinates are used for
+// the poll schedule instead of the values of the API. All sites of a profile
+// share one timezone, which is also the zone of the timestamps. The powerflow of a site
+// with a modbus address is read from the inverter instead of the API.
+type Site struct {
+	ID          string `yaml:"id"`
+	Timezone    string `yaml:"timezone,omitempty"`
+	Coordinates string `yaml:"coordinates,omitempty"`
+	Modbus      string `yaml:"modbus,omitempty"`
+}
+
+// Serve contains the poll schedule and the settings of the serve command. Empty
+// values keep the defaults of the flags.
+type Serve struct {
+	Listen                  string        `yaml:"listen,omitempty"`
+	Flow                    time.Duration `yaml:"flow,omitempty"`
+	Poll                    time.Duration `yaml:"poll,omitempty"`
+	Budget                  int           `yaml:"budget,omitempty"`
+	FillBudget              int           `yaml:"fill-budget,omitempty"`
+	Endpoints               []string      `yaml:"endpoints,omitempty"`
+	DayWindow               string        `yaml:"day-window,omitempty"`
+	SunriseMargin           time.Duration `yaml:"sunrise-margin,omitempty"`
+	SunsetMargin            time.Duration `yaml:"sunset-margin,omitempty"`
+	History                 string        `yaml:"history,omitempty"`
+	HistoryRetention        time.Duration `yaml:"history-retention,omitempty"`
+	AllSites                bool          `yaml:"all-sites,omitempty"`
+	TLSCert                 string        `yaml:"tls-cert,omitempty"`
+	TLSKey                  string        `yaml:"tls-key,omitempty"`
+	AuthFile                string        `yaml:"auth-file,omitempty"`
+	State                   string        `yaml:"state,omitempty"`
+	StaleAfter              time.Duration `yaml:"stale-after,omitempty"`
+	StaleGauges             string        `yaml:"stale-gauges,omitempty"`
+	ModbusUnit              int           `yaml:"modbus-unit,omitempty"`
+	ModbusInterval          time.Duration `yaml:"modbus-interval,omitempty"`
+	ModbusInvertMeter       bool          `yaml:"modbus-invert-meter,omitempty"`
+	ModbusListen            string        `yaml:"modbus-listen,omitempty"`
+	ModbusListenInvertMeter bool          `yaml:"modbus-listen-invert-meter,omitempty"`
+}
+
+// MQTT contains the settings of the MQTT publisher.
+type MQTT struct {
+	Broker            string `yaml:"broker"`
+	Username          string `yaml:"username,omitempty"`
+	Password          string `yaml:"password,omitempty"`
+	ClientID          string `yaml:"client-id,omitempty"`
+	Topic             string `yaml:"topic,omitempty"`
+	AvailabilityTopic string `yaml:"availability-topic,omitempty"`
+	DiscoveryPrefix   string `yaml:"discovery-prefix,omitempty"`
+}
+
+// Influx contains the settings of the InfluxDB writer; database selects v1,
+// bucket selects v2.
+type Influx struct {
+	URL             string `yaml:"url"`
+	Database        string `yaml:"database,omitempty"`
+	RetentionPolicy string `yaml:"retention-policy,omitempty"`
+	Username        string `yaml:"username,omitempty"`
+	Password        string `yaml:"password,omitempty"`
+	Org             string `yaml:"org,omitempty"`
+	Bucket          string `yaml:"bucket,omitempty"`
+	Token           string `yaml:"token,omitempty"`
+	Buffer          string `yaml:"buffer,omitempty"`
+}
+
+// Outputs contains the targets of the polled data and the alerts.
+type Outputs struct {
+	MQTT     *MQTT                 `yaml:"mqtt,omitempty"`
+	Influx   *Influx               `yaml:"influx,omitempty"`
+	Webhooks []alert.WebhookConfig `yaml:"webhooks,omitempty"`
+}
+
+// A Profile contains everything which is needed to query and serve a number of
+// sites with one API key.
+type Profile struct {
+	APIKey   string       `yaml:"apikey,omitempty"`
+	BaseURL  string       `yaml:"baseurl,omitempty"`
+	Timezone string       `yaml:"timezone,omitempty"`
+	Sites    []Site       `yaml:"sites,omitempty"`
+	Serve    Serve        `yaml:"serve,omitempty"`
+	Outputs  Outputs      `yaml:"outputs,omitempty"`
+	Alerts   []alert.Rule `yaml:"alerts,omitempty"`
+}
+
+// Config is the content of the configuration file. Profile is the name of the
+// profile which is used if no other profile is selected.
+type Config struct {
+	Profile  string              `yaml:"profile,omitempty"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// DefaultPath returns the path of the configuration file in the user config
+// directory, e.g. ~/.config/solaredge/config.yaml.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "solaredge", "config.yaml")
+}
+
+// Load reads and validates the configuration file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+	var res Config
+	if err := yaml.UnmarshalStrict(data, &res); err != nil {
+		return nil, fmt.Errorf("cannot parse config %q: %w", path, err)
+	}
+	if err := res.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %q: %w", path, err)
+	}
+	return &res, nil
+}
+
+// Errors contains all problems of a configuration.
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Names returns the sorted names of all profiles.
+func (c *Config) Names() []string {
+	res := make([]string, 0, len(c.Profiles))
+	for n := range c.Profiles {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Validate checks all profiles. The error is of type Errors and lists every
+// problem.
+func (c *Config) Validate() error {
+	var errs Errors
+	if len(c.Profiles) == 0 {
+		errs = append(errs, "no profiles")
+	}
+	if c.Profile != "" && c.Profiles[c.Profile] == nil {
+		errs = append(errs, fmt.Sprintf("unknown default profile %q", c.Profile))
+	}
+	for _, n := range c.Names() {
+		for _, e := range c.Profiles[n].validate() {
+			errs = append(errs, fmt.Sprintf("profile %q: %s", n, e))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Select returns the profile with the given name. Without a name it is the
+// default profile or the only profile of the file.
+func (c *Config) Select(name string) (string, *Profile, error) {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		if len(c.Profiles) != 1 {
+			return "", nil, fmt.Errorf("select one of the profiles %s", strings.Join(c.Names(), ", "))
+		}
+		name = c.Names()[0]
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown profile %q, use one of %s", name, strings.Join(c.Names(), ", "))
+	}
+	return name, p, nil
+}
+
+// SiteIDs returns the IDs of the sites of the profile.
+func (p *Profile) SiteIDs() []string {
+	res := make([]string, 0, len(p.Sites))
+	for _, s := range p.Sites {
+		res = append(res, s.ID)
+	}
+	return res
+}
+
+// Zone returns the timezone of the profile, without one it is the timezone of
+// the sites.
+func (p *Profile) Zone() string {
+	if p.Timezone != "" {
+		return p.Timezone
+	}
+	for _, s := range p.Sites {
+		if s.Timezone != "" {
+			return s.Timezone
+		}
+	}
+	return ""
+}
+
+// AlertConfig returns the alert rules together with the webhooks.
+func (p *Profile) AlertConfig() *alert.Config {
+	return &alert.Config{Rules: p.Alerts, Webhooks: p.Outputs.Webhooks}
+}
+
+func (p *Profile) validate() []string {
+	var errs []string
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	if p == nil {
+		return []string{"empty profile"}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			add("unknown timezone %q", p.Timezone)
+		}
+	}
+
+	ids := make(map[string]bool)
+	for i, s := range p.Sites {
+		switch {
+		case s.ID == "":
+			add("site %d: no id", i+1)
+		case ids[s.ID]:
+			add("site %s: duplicate id", s.ID)
+		}
+		ids[s.ID] = true
+		if s.Timezone != "" {
+			if _, err := time.LoadLocation(s.Timezone); err != nil {
+				add("site %s: unknown timezone %q", s.ID, s.Timezone)
+			} else if z := p.Zone(); s.Timezone != z {
+				add("site %s: timezone %q differs from %q, the sites of a profile share one timezone", s.ID, s.Timezone, z)
+			}
+		}
+		if s.Coordinates != "" {
+			if _, err := schedule.ParseCoordinates(s.Coordinates); err != nil {
+				add("site %s: %v", s.ID, err)
+			}
+		}
+		if s.Modbus != "" {
+			if _, _, err := net.SplitHostPort(s.Modbus); err != nil {
+				add("site %s: invalid modbus address: %v", s.ID, err)
+			}
+		}
+	}
+
+	sv := p.Serve
+	if sv.Flow < 0 || sv.Poll < 0 || sv.HistoryRetention < 0 || sv.StaleAfter < 0 || sv.ModbusInterval < 0 {
+		add("serve: negative durations are not allowed")
+	}
+	if sv.Budget < 0 || sv.Budget > solaredge.DailyQuota {
+		add("serve: budget must be between 0 (the default) and %d", solaredge.DailyQuota)
+	}
+	if sv.FillBudget < 0 || sv.Budget+sv.FillBudget > solaredge.DailyQuota {
+		add("serve: budget and fill-budget must not exceed %d", solaredge.DailyQuota)
+	}
+	for _, e := range sv.Endpoints {
+		if _, err := schedule.ParseEndpoint(e); err != nil {
+			add("serve: %v", err)
+		}
+	}
+	if sv.ModbusUnit < 0 || sv.ModbusUnit > 247 {
+		add("serve: modbus-unit must be between 0 (the default) and 247")
+	}
+	if (sv.TLSCert == "") != (sv.TLSKey == "") {
+		add("serve: tls-cert and tls-key must be given together")
+	}
+	if sv.StaleGauges != "" {
+		if _, err := metrics.ParseStaleMode(sv.StaleGauges); err != nil {
+			add("serve: %v", err)
+		}
+	}
+	if sv.DayWindow != "" {
+		if _, err := schedule.ParseWindow(sv.DayWindow); err != nil {
+			add("serve: %v", err)
+		}
+	}
+
+	if m := p.Outputs.MQTT; m != nil {
+		if m.Broker == "" {
+			add("mqtt: no broker")
+		}
+	}
+	if in := p.Outputs.Influx; in != nil {
+		if in.URL == "" {
+			add("influx: no url")
+		}
+		if in.Database == "" && in.Bucket == "" {
+			add("influx: needs a database (v1) or a bucket (v2)")
+		}
+	}
+	if err := p.AlertConfig().Validate(); err != nil {
+		add("alerts: %v", err)
+	}
+	return errs
+}
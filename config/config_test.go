@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/ulrichSchreiner/solaredge/alert"
+)
+
+const validConfig = `
+profile: home
+profiles:
+  home:
+    apikey: "123"
+    timezone: Europe/Berlin
+    sites:
+      - id: "1"
+        timezone: Europe/Berlin
+        coordinates: 48.14,11.58
+        modbus: 192.168.1.50:1502
+      - id: "2"
+    serve:
+      flow: 3m
+      budget: 280
+      fill-budget: 20
+      endpoints: ["powerflow=3,15m", "overview=1,1h"]
+      day-window: 06:00-21:00
+      stale-gauges: nan
+      modbus-unit: 2
+      tls-cert: cert.pem
+      tls-key: key.pem
+    outputs:
+      mqtt:
+        broker: tcp://localhost:1883
+      influx:
+        url: http://localhost:8086
+        bucket: solar
+      webhooks:
+        - url: https://example.com/hook
+    alerts:
+      - name: low-soc
+        metric: soc
+        op: "<"
+        value: 10
+  barn:
+    apikey: "456"
+    sites:
+      - id: "3"
+        timezone: Europe/Vienna
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeConfig(t, validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Join(cfg.Names(), ","); n != "barn,home" {
+		t.Errorf("profiles are %s, want barn,home", n)
+	}
+	p := cfg.Profiles["home"]
+	if ids := strings.Join(p.SiteIDs(), ","); ids != "1,2" {
+		t.Errorf("sites are %s, want 1,2", ids)
+	}
+	if ac := p.AlertConfig(); len(ac.Rules) != 1 || len(ac.Webhooks) != 1 {
+		t.Errorf("alert config is %+v", ac)
+	}
+
+	for _, tc := range []struct {
+		name, content, want string
+	}{
+		{"unknown key", "profiles:\n  home:\n    apikey: x\n    siteid: \"1\"\n", "field siteid not found"},
+		{"unknown serve key", "profiles:\n  home:\n    serve:\n      flows: 3m\n", "field flows not found"},
+		{"duplicate key", "profiles:\n  home:\n    apikey: x\n    apikey: y\n", "already set"},
+		{"invalid duration", "profiles:\n  home:\n    serve:\n      flow: often\n", "cannot parse config"},
+		{"invalid", "profiles:\n  home:\n    timezone: Mars/Olympus\n", "invalid config"},
+	} {
+		if _, err := Load(writeConfig(t, tc.content)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error is %v, want %q", tc.name, err, tc.want)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing config returned %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"empty", Config{}, []string{"no profiles"}},
+		{"unknown default", Config{Profile: "x", Profiles: map[string]*Profile{"home": {}}}, []string{`unknown default profile "x"`}},
+		{"nil profile", Config{Profiles: map[string]*Profile{"home": nil}}, []string{`profile "home": empty profile`}},
+		{"sites", Config{Profiles: map[string]*Profile{"home": {Sites: []Site{
+			{},
+			{ID: "1", Coordinates: "north", Modbus: "inverter"},
+			{ID: "1"},
+			{ID: "2", Timezone: "Mars/Olympus"},
+		}}}}, []string{
+			`profile "home": site 1: no id`,
+			`profile "home": site 1: coordinates "north"`,
+			`profile "home": site 1: invalid modbus address`,
+			`profile "home": site 1: duplicate id`,
+			`profile "home": site 2: unknown timezone "Mars/Olympus"`,
+		}},
+		{"zone of the profile", Config{Profiles: map[string]*Profile{"home": {Timezone: "Europe/Berlin", Sites: []Site{
+			{ID: "1", Timezone: "Europe/Berlin"},
+			{ID: "2", Timezone: "America/New_York"},
+		}}}}, []string{`profile "home": site 2: timezone "America/New_York" differs from "Europe/Berlin"`}},
+		{"zone of the sites", Config{Profiles: map[string]*Profile{"home": {Sites: []Site{
+			{ID: "1"},
+			{ID: "2", Timezone: "Europe/Vienna"},
+			{ID: "3", Timezone: "Europe/Berlin"},
+		}}}}, []string{`profile "home": site 3: timezone "Europe/Berlin" differs from "Europe/Vienna"`}},
+		{"serve", Config{Profiles: map[string]*Profile{"home": {Serve: Serve{
+			Flow:        -1,
+			Budget:      301,
+			Endpoints:   []string{"inventory=1"},
+			ModbusUnit:  248,
+			TLSCert:     "cert.pem",
+			StaleGauges: "zero",
+			DayWindow:   "morning",
+		}}}}, []string{
+			`profile "home": serve: negative durations`,
+			`profile "home": serve: budget must be between 0 (the default) and 300`,
+			`profile "home": serve: budget and fill-budget must not exceed 300`,
+			`profile "home": serve: `,
+			`profile "home": serve: modbus-unit must be between 0 (the default) and 247`,
+			`profile "home": serve: tls-cert and tls-key must be given together`,
+			`profile "home": serve: unknown stale mode "zero"`,
+			`profile "home": serve: window "morning"`,
+		}},
+		{"budgets", Config{Profiles: map[string]*Profile{"home": {Serve: Serve{Budget: -1, FillBudget: -1}}}}, []string{
+			`profile "home": serve: budget must be between 0 (the default) and 300`,
+			`profile "home": serve: budget and fill-budget must not exceed 300`,
+		}},
+		{"outputs", Config{Profiles: map[string]*Profile{"home": {
+			Outputs: Outputs{MQTT: &MQTT{}, Influx: &Influx{}},
+			Alerts:  []alert.Rule{{Name: "x", Metric: "temperature", Op: "<"}},
+		}}}, []string{
+			`profile "home": mqtt: no broker`,
+			`profile "home": influx: no url`,
+			`profile "home": influx: needs a database (v1) or a bucket (v2)`,
+			`profile "home": alerts: rule "x": unknown metric "temperature"`,
+		}},
+		// the errors are sorted by the name of the profile
+		{"profiles", Config{Profiles: map[string]*Profile{"b": {Timezone: "x"}, "a": {Timezone: "y"}}}, []string{
+			`profile "a": unknown timezone "y"`,
+			`profile "b": unknown timezone "x"`,
+		}},
+		{"valid", Config{Profiles: map[string]*Profile{"home": {Serve: Serve{Budget: 300}, Sites: []Site{{ID: "1", Timezone: "UTC"}, {ID: "2", Timezone: "UTC"}}}}}, nil},
+	} {
+		err := tc.cfg.Validate()
+		if tc.want == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var errs Errors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: error is %v, want Errors", tc.name, err)
+			continue
+		}
+		if len(errs) != len(tc.want) {
+			t.Errorf("%s: errors are %q, want %d", tc.name, errs, len(tc.want))
+			continue
+		}
+		for i, w := range tc.want {
+			if !strings.HasPrefix(errs[i], w) {
+				t.Errorf("%s: error %d is %q, want %q", tc.name, i, errs[i], w)
+			}
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	one := &Profile{APIKey: "1"}
+	two := &Profile{APIKey: "2"}
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		profile string
+		want    *Profile
+		err     string
+	}{
+		{"by name", Config{Profile: "one", Profiles: map[string]*Profile{"one": one, "two": two}}, "two", two, ""},
+		{"default", Config{Profile: "one", Profiles: map[string]*Profile{"one": one, "two": two}}, "", one, ""},
+		{"only", Config{Profiles: map[string]*Profile{"two": two}}, "", two, ""},
+		{"ambiguous", Config{Profiles: map[string]*Profile{"one": one, "two": two}}, "", nil, "select one of the profiles one, two"},
+		{"unknown", Config{Profiles: map[string]*Profile{"one": one, "two": two}}, "three", nil, `unknown profile "three", use one of one, two`},
+	} {
+		name, p, err := tc.cfg.Select(tc.profile)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: error is %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || p != tc.want || tc.cfg.Profiles[name] != p {
+			t.Errorf("%s: selected %q %v: %v", tc.name, name, p, err)
+		}
+	}
+}
+
+func TestZone(t *testing.T) {
+	for _, tc := range []struct {
+		p    Profile
+		want string
+	}{
+		{Profile{}, ""},
+		{Profile{Timezone: "UTC", Sites: []Site{{ID: "1", Timezone: "Europe/Berlin"}}}, "UTC"},
+		{Profile{Sites: []Site{{ID: "1"}, {ID: "2", Timezone: "Europe/Berlin"}}}, "Europe/Berlin"},
+	} {
+		if z := tc.p.Zone(); z != tc.want {
+			t.Errorf("zone of %+v is %q, want %q", tc.p, z, tc.want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 const (
 	datetimePattern = "2006-01-02 15:04:05"
+	datePattern     = "2006-01-02"
 )
 
 var (
@@ -50,6 +51,32 @@ func (f *SETime) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// SEDate is a date from solaredge in the zone of the site, like SETime.
+type SEDate time.Time
+
+func (f *SEDate) MarshalJSON() ([]byte, error) {
+	t := time.Time(*f)
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(fmt.Sprintf("%q", t.Format(datePattern))), nil
+}
+
+func (f *SEDate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*f = SEDate(time.Time{})
+		return nil
+	}
+	s := strings.Trim(string(data), `"`)
+	loc, _ := time.LoadLocation(SiteZone)
+	t, err := time.ParseInLocation(datePattern, s, loc)
+	if err != nil {
+		return err
+	}
+	*f = SEDate(t)
+	return nil
+}
+
 //  A Site contains the stored site information, like location, etcpp.
 type Site struct {
 	Id        int     `json:"id"`
@@ -205,6 +232,40 @@ type PowerFlow struct {
 	Storage     *StoragePowerFlowStatus `json:"STORAGE,omitempty"`
 }
 
+// InverterPhaseData contains the AC values of one phase of an inverter.
+type InverterPhaseData struct {
+	ACCurrent     float64 `json:"acCurrent"`
+	ACVoltage     float64 `json:"acVoltage"`
+	ACFrequency   float64 `json:"acFrequency"`
+	ApparentPower float64 `json:"apparentPower"`
+	ActivePower   float64 `json:"activePower"`
+	ReactivePower float64 `json:"reactivePower"`
+	CosPhi        float64 `json:"cosPhi"`
+}
+
+// InverterTelemetry contains the technical data of an inverter at a point in time.
+type InverterTelemetry struct {
+	Date                  SETime             `json:"date"`
+	TotalActivePower      float64            `json:"totalActivePower"`
+	DCVoltage             float64            `json:"dcVoltage"`
+	GroundFaultResistance float64            `json:"groundFaultResistance"`
+	PowerLimit            float64            `json:"powerLimit"`
+	TotalEnergy           float64            `json:"totalEnergy"`
+	Temperature           float64            `json:"temperature"`
+	InverterMode          string             `json:"inverterMode,omitempty"`
+	OperationMode         int                `json:"operationMode"`
+	L1Data                *InverterPhaseData `json:"L1Data,omitempty"`
+	L2Data                *InverterPhaseData `json:"L2Data,omitempty"`
+	L3Data                *InverterPhaseData `json:"L3Data,omitempty"`
+}
+
+// DataPeriod is the range of dates with data of a site. The dates are zero if the
+// site has no data.
+type DataPeriod struct {
+	StartDate SEDate `json:"startDate"`
+	EndDate   SEDate `json:"endDate"`
+}
+
 // OverviewEnergy wraps a energy value
 type OverviewEnergy struct {
 	Energy float64 `json:"energy"`
@@ -0,0 +1,301 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// This is a minimal parquet writer: all rows are written into a single row group
+// with one uncompressed, PLAIN encoded data page per column. The time column is
+// required, all other columns are optional. Times are stored as timestamps in
+// milliseconds which are not adjusted to UTC, i.e. the wall clock of the site.
+
+const (
+	parquetMagic = "PAR1"
+
+	// physical types
+	ptInt64     = 2
+	ptDouble    = 5
+	ptByteArray = 6
+
+	// repetition types
+	repRequired = 0
+	repOptional = 1
+
+	// converted types
+	ctUTF8 = 0
+
+	// encodings
+	encPlain = 0
+	encRLE   = 3
+
+	// thrift compact protocol types
+	tcTrue   = 1
+	tcFalse  = 2
+	tcI32    = 5
+	tcI64    = 6
+	tcBinary = 8
+	tcList   = 9
+	tcStruct = 12
+)
+
+// thriftWriter writes structs with the thrift compact protocol.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID []int16
+}
+
+func (tw *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	tw.buf.Write(b[:n])
+}
+
+func (tw *thriftWriter) zigzag(v int64) {
+	tw.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (tw *thriftWriter) field(id int16, typ byte) {
+	last := tw.lastID[len(tw.lastID)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		tw.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		tw.buf.WriteByte(typ)
+		tw.zigzag(int64(id))
+	}
+	tw.lastID[len(tw.lastID)-1] = id
+}
+
+func (tw *thriftWriter) begin() {
+	tw.lastID = append(tw.lastID, 0)
+}
+
+func (tw *thriftWriter) end() {
+	tw.buf.WriteByte(0)
+	tw.lastID = tw.lastID[:len(tw.lastID)-1]
+}
+
+func (tw *thriftWriter) i32(id int16, v int32) {
+	tw.field(id, tcI32)
+	tw.zigzag(int64(v))
+}
+
+func (tw *thriftWriter) i64(id int16, v int64) {
+	tw.field(id, tcI64)
+	tw.zigzag(v)
+}
+
+func (tw *thriftWriter) bool(id int16, v bool) {
+	if v {
+		tw.field(id, tcTrue)
+	} else {
+		tw.field(id, tcFalse)
+	}
+}
+
+func (tw *thriftWriter) binary(s string) {
+	tw.varint(uint64(len(s)))
+	tw.buf.WriteString(s)
+}
+
+func (tw *thriftWriter) string(id int16, s string) {
+	tw.field(id, tcBinary)
+	tw.binary(s)
+}
+
+func (tw *thriftWriter) list(id int16, typ byte, n int) {
+	tw.field(id, tcList)
+	if n < 15 {
+		tw.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		tw.buf.WriteByte(0xf0 | typ)
+		tw.varint(uint64(n))
+	}
+}
+
+// structField begins a struct which is a field of the current struct.
+func (tw *thriftWriter) structField(id int16) {
+	tw.field(id, tcStruct)
+	tw.begin()
+}
+
+type parquetChunk struct {
+	column Column
+	offset int64
+	size   int64
+}
+
+func physicalType(c Column) int32 {
+	switch c.Type {
+	case TimeColumn:
+		return ptInt64
+	case StringColumn:
+		return ptByteArray
+	}
+	return ptDouble
+}
+
+// columnPage returns the definition levels and the PLAIN encoded values of a column.
+func (t *Table) columnPage(idx int) []byte {
+	var levels []bool
+	var values bytes.Buffer
+	var b [8]byte
+	for _, row := range t.Rows {
+		v := row[idx]
+		levels = append(levels, v != nil)
+		if v == nil {
+			continue
+		}
+		switch val := v.(type) {
+		case time.Time:
+			// wall clock of the site as if it were UTC
+			wall := time.Date(val.Year(), val.Month(), val.Day(), val.Hour(), val.Minute(), val.Second(), val.Nanosecond(), time.UTC)
+			binary.LittleEndian.PutUint64(b[:], uint64(wall.UnixNano()/int64(time.Millisecond)))
+			values.Write(b[:])
+		case string:
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(val)))
+			values.Write(b[:4])
+			values.WriteString(val)
+		case float64:
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(val))
+			values.Write(b[:])
+		}
+	}
+	if t.Columns[idx].Type == TimeColumn {
+		return values.Bytes()
+	}
+
+	// definition levels as RLE runs with a bit width of 1, prefixed by their length
+	var rle thriftWriter
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		rle.varint(uint64(j-i) << 1)
+		if levels[i] {
+			rle.buf.WriteByte(1)
+		} else {
+			rle.buf.WriteByte(0)
+		}
+		i = j
+	}
+	var page bytes.Buffer
+	binary.LittleEndian.PutUint32(b[:4], uint32(rle.buf.Len()))
+	page.Write(b[:4])
+	page.Write(rle.buf.Bytes())
+	page.Write(values.Bytes())
+	return page.Bytes()
+}
+
+// WriteParquet writes the table as a parquet file.
+func (t *Table) WriteParquet(w io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString(parquetMagic)
+
+	chunks := make([]parquetChunk, len(t.Columns))
+	for i, c := range t.Columns {
+		page := t.columnPage(i)
+		var hdr thriftWriter
+		hdr.begin()
+		hdr.i32(1, 0) // DATA_PAGE
+		hdr.i32(2, int32(len(page)))
+		hdr.i32(3, int32(len(page)))
+		hdr.structField(5)
+		hdr.i32(1, int32(len(t.Rows)))
+		hdr.i32(2, encPlain)
+		hdr.i32(3, encRLE)
+		hdr.i32(4, encRLE)
+		hdr.end()
+		hdr.end()
+
+		chunks[i] = parquetChunk{
+			column: c,
+			offset: int64(out.Len()),
+			size:   int64(hdr.buf.Len() + len(page)),
+		}
+		out.Write(hdr.buf.Bytes())
+		out.Write(page)
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, tcStruct, len(t.Columns)+1)
+	meta.begin()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(t.Columns)))
+	meta.end()
+	for _, c := range t.Columns {
+		meta.begin()
+		meta.i32(1, physicalType(c))
+		if c.Type == TimeColumn {
+			meta.i32(3, repRequired)
+		} else {
+			meta.i32(3, repOptional)
+		}
+		meta.string(4, c.Name)
+		switch c.Type {
+		case StringColumn:
+			meta.i32(6, ctUTF8)
+			meta.structField(10)
+			meta.structField(1) // STRING
+			meta.end()
+			meta.end()
+		case TimeColumn:
+			meta.structField(10)
+			meta.structField(8) // TIMESTAMP
+			meta.bool(1, false)
+			meta.structField(2)
+			meta.structField(1) // MILLIS
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(t.Rows)))
+	meta.list(4, tcStruct, 1)
+	meta.begin()
+	meta.list(1, tcStruct, len(chunks))
+	var total int64
+	for _, ch := range chunks {
+		total += ch.size
+		meta.begin()
+		meta.i64(2, ch.offset)
+		meta.structField(3)
+		meta.i32(1, physicalType(ch.column))
+		meta.list(2, tcI32, 2)
+		meta.zigzag(encPlain)
+		meta.zigzag(encRLE)
+		meta.list(3, tcBinary, 1)
+		meta.binary(ch.column.Name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(len(t.Rows)))
+		meta.i64(6, ch.size)
+		meta.i64(7, ch.size)
+		meta.i64(9, ch.offset)
+		meta.end()
+		meta.end()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(t.Rows)))
+	meta.end()
+	meta.string(6, "gitlab.com/ulrichSchreiner/solaredge")
+	meta.end()
+
+	var b [4]byte
+	out.Write(meta.buf.Bytes())
+	binary.LittleEndian.PutUint32(b[:], uint32(meta.buf.Len()))
+	out.Write(b[:])
+	out.WriteString(parquetMagic)
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("cannot write parquet: %w", err)
+	}
+	return nil
+}
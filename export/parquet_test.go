@@ -0,0 +1,245 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// thriftReader reads structs of the thrift compact protocol into maps from the
+// field id to the value.
+type thriftReader struct {
+	r *bufio.Reader
+}
+
+func (tr *thriftReader) varint() uint64 {
+	v, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (tr *thriftReader) zigzag() int64 {
+	v := tr.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (tr *thriftReader) byte() byte {
+	b, err := tr.r.ReadByte()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (tr *thriftReader) value(typ byte) any {
+	switch typ {
+	case tcTrue:
+		return true
+	case tcFalse:
+		return false
+	case tcI32, tcI64:
+		return tr.zigzag()
+	case tcBinary:
+		b := make([]byte, tr.varint())
+		for i := range b {
+			b[i] = tr.byte()
+		}
+		return string(b)
+	case tcList:
+		h := tr.byte()
+		n, et := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(tr.varint())
+		}
+		res := make([]any, n)
+		for i := range res {
+			res[i] = tr.value(et)
+		}
+		return res
+	case tcStruct:
+		return tr.structValue()
+	}
+	panic(fmt.Sprintf("unknown thrift type %d", typ))
+}
+
+func (tr *thriftReader) structValue() map[int16]any {
+	res := make(map[int16]any)
+	var last int16
+	for {
+		h := tr.byte()
+		if h == 0 {
+			return res
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(tr.zigzag())
+		}
+		res[id] = tr.value(h & 0x0f)
+		last = id
+	}
+}
+
+func readThrift(t *testing.T, b []byte) (res map[int16]any) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("cannot read thrift struct: %v", r)
+		}
+	}()
+	return (&thriftReader{r: bufio.NewReader(bytes.NewReader(b))}).structValue()
+}
+
+// field returns the value of the path of field ids and list indices.
+func field(t *testing.T, v any, path ...int) any {
+	t.Helper()
+	for _, p := range path {
+		switch x := v.(type) {
+		case map[int16]any:
+			v = x[int16(p)]
+		case []any:
+			if p >= len(x) {
+				t.Fatalf("index %d of list with %d elements", p, len(x))
+			}
+			v = x[p]
+		default:
+			t.Fatalf("cannot get %d of %T", p, v)
+		}
+	}
+	return v
+}
+
+func TestWriteParquet(t *testing.T) {
+	loc := time.FixedZone("site", 2*60*60)
+	t0 := time.Date(2023, 5, 1, 12, 0, 0, 0, loc)
+	tbl := &Table{
+		Columns: []Column{
+			{Name: "time", Type: TimeColumn},
+			{Name: "meter", Type: StringColumn},
+			{Name: "value", Type: FloatColumn},
+		},
+		Rows: [][]any{
+			{t0, "Production", 1.5},
+			{t0.Add(15 * time.Minute), "Production", nil},
+			{t0.Add(30 * time.Minute), nil, 3.25},
+		},
+	}
+	var buf bytes.Buffer
+	if err := tbl.WriteParquet(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatalf("missing parquet magic: %q", data)
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("invalid footer length %d of %d bytes", footer, len(data))
+	}
+	meta := readThrift(t, data[len(data)-8-footer:len(data)-8])
+
+	if v := field(t, meta, 1); v != int64(1) {
+		t.Errorf("version is %v, want 1", v)
+	}
+	if v := field(t, meta, 3); v != int64(3) {
+		t.Errorf("num_rows is %v, want 3", v)
+	}
+	schema, _ := field(t, meta, 2).([]any)
+	if len(schema) != 4 {
+		t.Fatalf("schema has %d elements, want 4", len(schema))
+	}
+	if v := field(t, schema, 0, 5); v != int64(3) {
+		t.Errorf("root has %v children, want 3", v)
+	}
+	wantSchema := []struct {
+		name string
+		typ  int64
+		rep  int64
+	}{
+		{"time", ptInt64, repRequired},
+		{"meter", ptByteArray, repOptional},
+		{"value", ptDouble, repOptional},
+	}
+	for i, w := range wantSchema {
+		e := field(t, schema, i+1)
+		if field(t, e, 4) != w.name || field(t, e, 1) != w.typ || field(t, e, 3) != w.rep {
+			t.Errorf("schema element %d is %v, want %+v", i+1, e, w)
+		}
+	}
+	if v := field(t, schema, 1, 10, 8, 1); v != false {
+		t.Errorf("time is adjusted to UTC: %v", v)
+	}
+	if _, ok := field(t, schema, 1, 10, 8, 2, 1).(map[int16]any); !ok {
+		t.Errorf("time is not a timestamp in millis: %v", field(t, schema, 1))
+	}
+	if v := field(t, schema, 2, 6); v != int64(ctUTF8) {
+		t.Errorf("meter is not UTF8: %v", v)
+	}
+
+	groups, _ := field(t, meta, 4).([]any)
+	if len(groups) != 1 {
+		t.Fatalf("file has %d row groups, want 1", len(groups))
+	}
+	if v := field(t, groups, 0, 3); v != int64(3) {
+		t.Errorf("row group has %v rows, want 3", v)
+	}
+	chunks, _ := field(t, groups, 0, 1).([]any)
+	if len(chunks) != 3 {
+		t.Fatalf("row group has %d columns, want 3", len(chunks))
+	}
+	var total int64
+	pages := make([][]byte, len(chunks))
+	for i, ch := range chunks {
+		cm := field(t, ch, 3)
+		if path := field(t, cm, 3); fmt.Sprint(path) != fmt.Sprint([]any{wantSchema[i].name}) {
+			t.Errorf("column %d has path %v", i, path)
+		}
+		if v := field(t, cm, 5); v != int64(3) {
+			t.Errorf("column %d has %v values, want 3", i, v)
+		}
+		offset, size := field(t, cm, 9).(int64), field(t, cm, 7).(int64)
+		total += size
+		if offset < 4 || offset+size > int64(len(data)-8-footer) {
+			t.Fatalf("column %d at %d with %d bytes is outside of the data", i, offset, size)
+		}
+		r := &thriftReader{r: bufio.NewReader(bytes.NewReader(data[offset : offset+size]))}
+		hdr := r.structValue()
+		if field(t, hdr, 1) != int64(0) || field(t, hdr, 5, 1) != int64(3) {
+			t.Errorf("column %d has page header %v", i, hdr)
+		}
+		n := field(t, hdr, 3).(int64)
+		pages[i] = make([]byte, n)
+		if _, err := io.ReadFull(r.r, pages[i]); err != nil || r.r.Buffered() != 0 {
+			t.Fatalf("column %d: page is not %d bytes", i, n)
+		}
+	}
+	if v := field(t, groups, 0, 2); v != total {
+		t.Errorf("row group has %v bytes, want %d", v, total)
+	}
+
+	// the times are the wall clock of the site in millis
+	for i, row := range tbl.Rows {
+		want := time.Date(2023, 5, 1, 12, 15*i, 0, 0, time.UTC).UnixMilli()
+		if got := int64(binary.LittleEndian.Uint64(pages[0][8*i:])); got != want {
+			t.Errorf("time %d is %d, want %d of %v", i, got, want, row[0])
+		}
+	}
+	// the definition levels of value are runs of one row each, the second row is
+	// missing
+	levels := int(binary.LittleEndian.Uint32(pages[2]))
+	if rle := pages[2][4 : 4+levels]; !bytes.Equal(rle, []byte{1 << 1, 1, 1 << 1, 0, 1 << 1, 1}) {
+		t.Errorf("value has definition levels %v", rle)
+	}
+	values := pages[2][4+levels:]
+	if len(values) != 16 ||
+		math.Float64frombits(binary.LittleEndian.Uint64(values)) != 1.5 ||
+		math.Float64frombits(binary.LittleEndian.Uint64(values[8:])) != 3.25 {
+		t.Errorf("value has values %v", values)
+	}
+}
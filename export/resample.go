@@ -0,0 +1,95 @@
+package export
+
+import (
+	"time"
+)
+
+// Aggregation selects how the values of an interval are combined.
+type Aggregation string
+
+var (
+	// Mean is the average of the values, e.g. for power.
+	Mean Aggregation = "mean"
+	// Sum is the total of the values, e.g. for energy.
+	Sum Aggregation = "sum"
+	// Last is the newest value, e.g. for counters.
+	Last Aggregation = "last"
+)
+
+// intervalStart returns the beginning of the interval which contains t. Steps of
+// whole days are counted in calendar days in the location of start, so a day
+// with a change of the daylight saving time is still one interval.
+func intervalStart(start time.Time, step time.Duration, t time.Time) time.Time {
+	const day = 24 * time.Hour
+	if step%day == 0 {
+		n := int(step / day)
+		y1, m1, d1 := start.Date()
+		y2, m2, d2 := t.In(start.Location()).Date()
+		days := int(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)) / day)
+		k := days / n
+		if days%n != 0 && days < 0 {
+			k--
+		}
+		res := start.AddDate(0, 0, k*n)
+		if t.Before(res) {
+			res = start.AddDate(0, 0, (k-1)*n)
+		}
+		return res
+	}
+	idx := t.Sub(start) / step
+	if t.Before(start.Add(idx * step)) {
+		idx--
+	}
+	return start.Add(idx * step)
+}
+
+// Resample returns a series with one observation per interval, key and name.
+// The intervals have the length step (steps of whole days are calendar days) and
+// are aligned to start; the time of an observation is the beginning of its
+// interval.
+func (s *Series) Resample(start time.Time, step time.Duration, agg Aggregation) *Series {
+	type bucket struct {
+		t    time.Time
+		key  string
+		name string
+	}
+	type values struct {
+		sum   float64
+		n     int
+		last  float64
+		lastT time.Time
+	}
+	var order []bucket
+	buckets := make(map[bucket]*values)
+	for _, o := range s.Observations {
+		b := bucket{t: intervalStart(start, step, o.Time), key: o.Key, name: o.Name}
+		v, ok := buckets[b]
+		if !ok {
+			v = &values{}
+			buckets[b] = v
+			order = append(order, b)
+		}
+		v.sum += o.Value
+		v.n++
+		if !o.Time.Before(v.lastT) {
+			v.last = o.Value
+			v.lastT = o.Time
+		}
+	}
+
+	res := &Series{KeyColumn: s.KeyColumn, NameColumn: s.NameColumn}
+	for _, b := range order {
+		v := buckets[b]
+		o := Observation{Time: b.t, Key: b.key, Name: b.name}
+		switch agg {
+		case Sum:
+			o.Value = v.sum
+		case Last:
+			o.Value = v.last
+		default:
+			o.Value = v.sum / float64(v.n)
+		}
+		res.Observations = append(res.Observations, o)
+	}
+	return res
+}
@@ -0,0 +1,119 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func observations(s *Series) string {
+	var res []string
+	for _, o := range s.Observations {
+		res = append(res, fmt.Sprintf("%s %s/%s=%v", o.Time.Format("01-02 15:04 MST"), o.Key, o.Name, o.Value))
+	}
+	return strings.Join(res, ", ")
+}
+
+func TestResample(t *testing.T) {
+	loc := time.FixedZone("site", 3600)
+	at := func(h, min int) time.Time { return time.Date(2023, 5, 1, h, min, 0, 0, loc) }
+	s := &Series{KeyColumn: "key", NameColumn: "meter", Observations: []Observation{
+		{Time: at(0, 0), Name: "pv", Value: 1},
+		{Time: at(0, 30), Name: "pv", Value: 3},
+		{Time: at(0, 15), Name: "grid", Value: 10},
+		// the newest value wins for last, not the last one in the series
+		{Time: at(0, 45), Name: "pv", Value: 8},
+		{Time: at(0, 40), Name: "pv", Value: 4},
+		{Time: at(1, 0), Name: "pv", Value: 5},
+		{Time: at(1, 0), Key: "b", Name: "pv", Value: 6},
+		// before the start
+		{Time: at(0, 0).Add(-time.Minute), Name: "pv", Value: 7},
+	}}
+	for _, tc := range []struct {
+		agg  Aggregation
+		want string
+	}{
+		{Mean, "05-01 00:00 site /pv=4, 05-01 00:00 site /grid=10, 05-01 01:00 site /pv=5, 05-01 01:00 site b/pv=6, 04-30 23:00 site /pv=7"},
+		{Sum, "05-01 00:00 site /pv=16, 05-01 00:00 site /grid=10, 05-01 01:00 site /pv=5, 05-01 01:00 site b/pv=6, 04-30 23:00 site /pv=7"},
+		{Last, "05-01 00:00 site /pv=8, 05-01 00:00 site /grid=10, 05-01 01:00 site /pv=5, 05-01 01:00 site b/pv=6, 04-30 23:00 site /pv=7"},
+	} {
+		res := s.Resample(at(0, 0), time.Hour, tc.agg)
+		if got := observations(res); got != tc.want {
+			t.Errorf("%s: resampled to %s, want %s", tc.agg, got, tc.want)
+		}
+		if res.KeyColumn != "key" || res.NameColumn != "meter" {
+			t.Errorf("%s: columns are %q and %q", tc.agg, res.KeyColumn, res.NameColumn)
+		}
+	}
+}
+
+// quarters returns a value of 1 for every quarter of an hour from the start to
+// the end.
+func quarters(start, end time.Time) *Series {
+	res := &Series{}
+	for t := start; t.Before(end); t = t.Add(15 * time.Minute) {
+		res.Observations = append(res.Observations, Observation{Time: t, Name: "Production", Value: 1})
+	}
+	return res
+}
+
+func TestResampleDays(t *testing.T) {
+	wien, err := time.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(m time.Month, d int) time.Time { return time.Date(2023, m, d, 0, 0, 0, 0, wien) }
+	for _, tc := range []struct {
+		name       string
+		start, end time.Time
+		step       time.Duration
+		want       string
+	}{
+		// the days have 24, 23 and 24 hours
+		{"spring", day(3, 25), day(3, 28), 24 * time.Hour, "03-25 00:00 CET /Production=96, 03-26 00:00 CET /Production=92, 03-27 00:00 CEST /Production=96"},
+		// the days have 24, 25 and 24 hours
+		{"autumn", day(10, 28), day(10, 31), 24 * time.Hour, "10-28 00:00 CEST /Production=96, 10-29 00:00 CEST /Production=100, 10-30 00:00 CET /Production=96"},
+		{"two days", day(10, 28), day(11, 1), 48 * time.Hour, "10-28 00:00 CEST /Production=196, 10-30 00:00 CET /Production=192"},
+		{"week", day(3, 20), day(4, 3), 7 * 24 * time.Hour, "03-20 00:00 CET /Production=668, 03-27 00:00 CEST /Production=672"},
+		// the hours are not calendar based
+		{"hours", day(3, 26), day(3, 27), 6 * time.Hour, "03-26 00:00 CET /Production=24, 03-26 07:00 CEST /Production=24, 03-26 13:00 CEST /Production=24, 03-26 19:00 CEST /Production=20"},
+	} {
+		got := observations(quarters(tc.start, tc.end).Resample(tc.start, tc.step, Sum))
+		if got != tc.want {
+			t.Errorf("%s: resampled to %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIntervalStart(t *testing.T) {
+	wien, err := time.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 3, 26, 0, 0, 0, 0, wien)
+	for _, tc := range []struct {
+		t    time.Time
+		step time.Duration
+		want time.Time
+	}{
+		{time.Date(2023, 3, 26, 23, 59, 0, 0, wien), 24 * time.Hour, start},
+		{time.Date(2023, 3, 27, 0, 0, 0, 0, wien), 24 * time.Hour, time.Date(2023, 3, 27, 0, 0, 0, 0, wien)},
+		// the day in the zone of the start, not in the zone of t
+		{time.Date(2023, 3, 26, 23, 30, 0, 0, time.UTC), 24 * time.Hour, time.Date(2023, 3, 27, 0, 0, 0, 0, wien)},
+		{time.Date(2023, 3, 25, 12, 0, 0, 0, wien), 24 * time.Hour, time.Date(2023, 3, 25, 0, 0, 0, 0, wien)},
+		{time.Date(2023, 3, 23, 12, 0, 0, 0, wien), 48 * time.Hour, time.Date(2023, 3, 22, 0, 0, 0, 0, wien)},
+		{time.Date(2023, 3, 24, 0, 0, 0, 0, wien), 48 * time.Hour, time.Date(2023, 3, 24, 0, 0, 0, 0, wien)},
+		{time.Date(2023, 3, 26, 3, 30, 0, 0, wien), time.Hour, time.Date(2023, 3, 26, 3, 0, 0, 0, wien)},
+		{time.Date(2023, 3, 25, 23, 30, 0, 0, wien), time.Hour, time.Date(2023, 3, 25, 23, 0, 0, 0, wien)},
+	} {
+		if got := intervalStart(start, tc.step, tc.t); !got.Equal(tc.want) {
+			t.Errorf("interval of %v with step %v starts at %v, want %v", tc.t, tc.step, got, tc.want)
+		}
+	}
+	// a start with a time of day
+	noon := time.Date(2023, 3, 25, 12, 0, 0, 0, wien)
+	if got := intervalStart(noon, 24*time.Hour, time.Date(2023, 3, 26, 11, 0, 0, 0, wien)); !got.Equal(noon) {
+		t.Errorf("interval of the morning starts at %v", got)
+	}
+}
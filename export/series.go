@@ -0,0 +1,81 @@
+package export
+
+import (
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+)
+
+// Meters returns the values of the meters of power or energy details as series
+// with one name per meter type.
+func Meters(meters []solaredge.MeteredValue) *Series {
+	res := &Series{KeyColumn: "key", NameColumn: "meter"}
+	for _, m := range meters {
+		for _, v := range m.Values {
+			res.Observations = append(res.Observations, Observation{
+				Time:  time.Time(v.Date),
+				Name:  m.Type,
+				Value: v.Value,
+			})
+		}
+	}
+	return res
+}
+
+// Storage returns the telemetries of the batteries as series keyed by the serial
+// number of the battery.
+func Storage(batteries []solaredge.StorageBattery) *Series {
+	res := &Series{KeyColumn: "battery", NameColumn: "metric"}
+	for _, b := range batteries {
+		for _, t := range b.Telemetries {
+			ts := time.Time(t.Timestamp)
+			add := func(name string, v float64) {
+				res.Observations = append(res.Observations, Observation{Time: ts, Key: b.SN, Name: name, Value: v})
+			}
+			add("power", t.Power)
+			add("batteryState", float64(t.State))
+			add("lifeTimeEnergyCharged", float64(t.LifetimeEnergyCharged))
+			add("lifeTimeEnergyDischarged", float64(t.LifetimeEnergyDischarged))
+			add("fullPackEnergyAvailable", t.FullPackEngergyAvailable)
+			add("internalTemp", t.InternalTemp)
+			add("ACGridCharging", t.ACGridCharging)
+			add("batteryPercentageState", t.PercentageState)
+		}
+	}
+	return res
+}
+
+// Inverter returns the numeric telemetries of an inverter as series. The values
+// of the phases are prefixed with the name of the phase, e.g. L1.acVoltage.
+func Inverter(telemetries []solaredge.InverterTelemetry) *Series {
+	res := &Series{KeyColumn: "key", NameColumn: "metric"}
+	for _, t := range telemetries {
+		ts := time.Time(t.Date)
+		add := func(name string, v float64) {
+			res.Observations = append(res.Observations, Observation{Time: ts, Name: name, Value: v})
+		}
+		add("totalActivePower", t.TotalActivePower)
+		add("dcVoltage", t.DCVoltage)
+		add("groundFaultResistance", t.GroundFaultResistance)
+		add("powerLimit", t.PowerLimit)
+		add("totalEnergy", t.TotalEnergy)
+		add("temperature", t.Temperature)
+		add("operationMode", float64(t.OperationMode))
+		for _, ph := range []struct {
+			name string
+			data *solaredge.InverterPhaseData
+		}{{"L1", t.L1Data}, {"L2", t.L2Data}, {"L3", t.L3Data}} {
+			if ph.data == nil {
+				continue
+			}
+			add(ph.name+".acCurrent", ph.data.ACCurrent)
+			add(ph.name+".acVoltage", ph.data.ACVoltage)
+			add(ph.name+".acFrequency", ph.data.ACFrequency)
+			add(ph.name+".apparentPower", ph.data.ApparentPower)
+			add(ph.name+".activePower", ph.data.ActivePower)
+			add(ph.name+".reactivePower", ph.data.ReactivePower)
+			add(ph.name+".cosPhi", ph.data.CosPhi)
+		}
+	}
+	return res
+}
@@ -0,0 +1,165 @@
+// Package export converts the detail series of solaredge into tables and writes
+// them as CSV, JSON lines or Parquet.
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format is an output format for tables.
+type Format string
+
+var (
+	CSV     Format = "csv"
+	JSON    Format = "json"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet"
+)
+
+// Layout selects how observations are arranged in a table.
+type Layout string
+
+var (
+	// Wide tables contain one row per timestamp and key and one column per name.
+	Wide Layout = "wide"
+	// Long tables contain one row per observation with a name and a value column.
+	Long Layout = "long"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSON, JSONL, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, use csv, json, jsonl or parquet", s)
+}
+
+// ParseLayout returns the layout with the given name.
+func ParseLayout(s string) (Layout, error) {
+	switch l := Layout(strings.ToLower(s)); l {
+	case Wide, Long:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown layout %q, use wide or long", s)
+}
+
+// An Observation is a single value of a series. Key distinguishes different
+// devices in the same series, like the serial number of a battery, and is empty
+// if there is only one device.
+type Observation struct {
+	Time  time.Time
+	Key   string
+	Name  string
+	Value float64
+}
+
+// Series is a list of observations together with the column names to use for
+// the key and the name.
+type Series struct {
+	KeyColumn    string
+	NameColumn   string
+	Observations []Observation
+}
+
+// ColumnType is the type of the values of a column.
+type ColumnType int
+
+const (
+	TimeColumn ColumnType = iota
+	StringColumn
+	FloatColumn
+)
+
+// A Column of a table.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// A Table contains rows of values. A value is a time.Time, string or float64 as
+// given by the type of the column or nil if it is missing.
+type Table struct {
+	Columns []Column
+	Rows    [][]any
+}
+
+func (s *Series) hasKeys() bool {
+	for _, o := range s.Observations {
+		if o.Key != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Table returns the observations of the series in the given layout. The rows
+// are sorted by time and key; the value columns of a wide table keep the order
+// in which the names appear in the series.
+func (s *Series) Table(l Layout) *Table {
+	withKey := s.hasKeys()
+	res := &Table{Columns: []Column{{Name: "time", Type: TimeColumn}}}
+	if withKey {
+		res.Columns = append(res.Columns, Column{Name: s.KeyColumn, Type: StringColumn})
+	}
+	obs := make([]Observation, len(s.Observations))
+	copy(obs, s.Observations)
+	sort.SliceStable(obs, func(i, j int) bool {
+		if !obs[i].Time.Equal(obs[j].Time) {
+			return obs[i].Time.Before(obs[j].Time)
+		}
+		return obs[i].Key < obs[j].Key
+	})
+
+	if l == Long {
+		res.Columns = append(res.Columns, Column{Name: s.NameColumn, Type: StringColumn}, Column{Name: "value", Type: FloatColumn})
+		for _, o := range obs {
+			row := []any{o.Time}
+			if withKey {
+				row = append(row, o.Key)
+			}
+			res.Rows = append(res.Rows, append(row, o.Name, o.Value))
+		}
+		return res
+	}
+
+	names := make(map[string]int)
+	for _, o := range s.Observations {
+		if _, ok := names[o.Name]; !ok {
+			names[o.Name] = len(res.Columns)
+			res.Columns = append(res.Columns, Column{Name: o.Name, Type: FloatColumn})
+		}
+	}
+	var row []any
+	for i, o := range obs {
+		if i == 0 || !o.Time.Equal(obs[i-1].Time) || o.Key != obs[i-1].Key {
+			row = make([]any, len(res.Columns))
+			row[0] = o.Time
+			if withKey {
+				row[1] = o.Key
+			}
+			res.Rows = append(res.Rows, row)
+		}
+		row[names[o.Name]] = o.Value
+	}
+	return res
+}
+
+// Write writes the table in the given format.
+func (t *Table) Write(w io.Writer, f Format) error {
+	switch f {
+	case CSV:
+		return t.WriteCSV(w)
+	case JSON:
+		return t.WriteJSON(w)
+	case JSONL:
+		return t.WriteJSONL(w)
+	case Parquet:
+		return t.WriteParquet(w)
+	}
+	return fmt.Errorf("unknown format %q", f)
+}
@@ -0,0 +1,121 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// timePattern is the datetime format of solaredge, the times are written in
+	// the zone of the site.
+	timePattern = "2006-01-02 15:04:05"
+)
+
+func formatValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return val.Format(timePattern)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
+
+// WriteCSV writes the table with a header line as CSV. Missing values are empty.
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	hdr := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		hdr[i] = c.Name
+	}
+	if err := cw.Write(hdr); err != nil {
+		return fmt.Errorf("cannot write csv header: %w", err)
+	}
+	rec := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			rec[i] = formatValue(v)
+		}
+		if err := cw.Write(rec); err != nil {
+			return fmt.Errorf("cannot write csv row: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeObject writes the row as a JSON object. Missing values are omitted.
+func (t *Table) writeObject(bw *bufio.Writer, row []any) error {
+	if err := bw.WriteByte('{'); err != nil {
+		return fmt.Errorf("cannot write json: %w", err)
+	}
+	first := true
+	for i, v := range row {
+		if v == nil {
+			continue
+		}
+		if tv, ok := v.(time.Time); ok {
+			v = tv.Format(timePattern)
+		}
+		name, _ := json.Marshal(t.Columns[i].Name)
+		val, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("cannot marshal value: %w", err)
+		}
+		if !first {
+			_ = bw.WriteByte(',')
+		}
+		first = false
+		_, _ = bw.Write(name)
+		_ = bw.WriteByte(':')
+		_, _ = bw.Write(val)
+	}
+	return bw.WriteByte('}')
+}
+
+// WriteJSONL writes every row of the table as a JSON object in its own line.
+// Missing values are omitted.
+func (t *Table) WriteJSONL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, row := range t.Rows {
+		if err := t.writeObject(bw, row); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return fmt.Errorf("cannot write jsonl: %w", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteJSON writes the rows of the table as a JSON array of objects. Missing
+// values are omitted.
+func (t *Table) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	sep := byte('[')
+	for _, row := range t.Rows {
+		if err := bw.WriteByte(sep); err != nil {
+			return fmt.Errorf("cannot write json: %w", err)
+		}
+		sep = ','
+		if err := t.writeObject(bw, row); err != nil {
+			return err
+		}
+	}
+	if sep == '[' {
+		_ = bw.WriteByte('[')
+	}
+	if _, err := bw.WriteString("]\n"); err != nil {
+		return fmt.Errorf("cannot write json: %w", err)
+	}
+	return bw.Flush()
+}
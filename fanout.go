@@ -0,0 +1,79 @@
+package solaredge
+
+import (
+	"sync"
+)
+
+// SiteResult is the result of a function called for one site by FanOut.
+type SiteResult[T any] struct {
+	SiteID string
+	Value  T
+	Err    error
+}
+
+// FanOutOpt is an option type for FanOut.
+type FanOutOpt func(fo *fanOut)
+
+type fanOut struct {
+	concurrency int
+	progress    func(done, total int, siteid string, err error)
+}
+
+// WithConcurrency sets the number of sites which are queried in parallel. The
+// value is capped at MaxConcurrentCalls.
+func WithConcurrency(n int) FanOutOpt {
+	return func(fo *fanOut) {
+		fo.concurrency = n
+	}
+}
+
+// WithProgress registers a callback which is called after every finished site
+// with the number of finished sites, the total number of sites and the result
+// of the site.
+func WithProgress(cb func(done, total int, siteid string, err error)) FanOutOpt {
+	return func(fo *fanOut) {
+		fo.progress = cb
+	}
+}
+
+// FanOut calls fn for every site in sites with a SiteClient of the given SEClient.
+// The sites are processed in parallel, but never with more than MaxConcurrentCalls
+// calls at the same time. A failing site does not abort the batch; the results
+// are returned in the order of the given sites and carry their own error.
+func FanOut[T any](sec *SEClient, sites []string, fn func(sc *SiteClient) (T, error), opts ...FanOutOpt) []SiteResult[T] {
+	fo := fanOut{concurrency: MaxConcurrentCalls}
+	for _, o := range opts {
+		o(&fo)
+	}
+	if fo.concurrency < 1 || fo.concurrency > MaxConcurrentCalls {
+		fo.concurrency = MaxConcurrentCalls
+	}
+
+	res := make([]SiteResult[T], len(sites))
+	jobs := make(chan int)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	for w := 0; w < fo.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				v, err := fn(sec.NewSite(sites[idx]))
+				res[idx] = SiteResult[T]{SiteID: sites[idx], Value: v, Err: err}
+				if fo.progress != nil {
+					lock.Lock()
+					done++
+					fo.progress(done, len(sites), sites[idx], err)
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range sites {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return res
+}
@@ -0,0 +1,106 @@
+package solaredge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSites(n int) []string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = fmt.Sprint(i + 1)
+	}
+	return res
+}
+
+func TestFanOutConcurrency(t *testing.T) {
+	for _, tc := range []struct {
+		concurrency int
+		want        int
+	}{
+		{0, MaxConcurrentCalls},
+		{1, 1},
+		{2, 2},
+		{10, MaxConcurrentCalls},
+	} {
+		var lock sync.Mutex
+		running, max := 0, 0
+		sites := testSites(12)
+		res := FanOut(NewClient(testKey), sites, func(sc *SiteClient) (string, error) {
+			lock.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			lock.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return sc.ID(), nil
+		}, WithConcurrency(tc.concurrency))
+
+		if max != tc.want {
+			t.Errorf("concurrency %d: %d calls ran at once, want %d", tc.concurrency, max, tc.want)
+		}
+		for i, r := range res {
+			if r.SiteID != sites[i] || r.Value != sites[i] || r.Err != nil {
+				t.Errorf("concurrency %d: unexpected result %d: %+v", tc.concurrency, i, r)
+			}
+		}
+	}
+}
+
+func TestFanOutErrors(t *testing.T) {
+	sites := testSites(7)
+	type progress struct {
+		done, total int
+		siteid      string
+		err         error
+	}
+	var calls []progress
+	res := FanOut(NewClient(testKey), sites, func(sc *SiteClient) (int, error) {
+		if sc.ID() == "3" || sc.ID() == "6" {
+			return 0, errors.New("site " + sc.ID() + " failed")
+		}
+		return len(sc.ID()), nil
+	}, WithProgress(func(done, total int, siteid string, err error) {
+		calls = append(calls, progress{done, total, siteid, err})
+	}))
+
+	if len(res) != len(sites) {
+		t.Fatalf("got %d results, want %d", len(res), len(sites))
+	}
+	for i, r := range res {
+		failed := r.SiteID == "3" || r.SiteID == "6"
+		if r.SiteID != sites[i] || failed != (r.Err != nil) {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+		if failed && r.Err.Error() != "site "+r.SiteID+" failed" {
+			t.Errorf("site %s has error %v", r.SiteID, r.Err)
+		}
+		if !failed && r.Value != 1 {
+			t.Errorf("site %s has value %d", r.SiteID, r.Value)
+		}
+	}
+
+	if len(calls) != len(sites) {
+		t.Fatalf("progress was called %d times, want %d", len(calls), len(sites))
+	}
+	seen := make(map[string]bool)
+	for i, c := range calls {
+		if c.done != i+1 || c.total != len(sites) {
+			t.Errorf("progress %d is %d of %d", i, c.done, c.total)
+		}
+		if failed := c.siteid == "3" || c.siteid == "6"; failed != (c.err != nil) {
+			t.Errorf("progress of site %s has error %v", c.siteid, c.err)
+		}
+		seen[c.siteid] = true
+	}
+	if len(seen) != len(sites) {
+		t.Errorf("progress reported the sites %v", seen)
+	}
+}
@@ -0,0 +1,161 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+)
+
+// PowerFlowSample is a powerflow together with the time it was fetched.
+type PowerFlowSample struct {
+	Time time.Time           `json:"time"`
+	Flow solaredge.PowerFlow `json:"flow"`
+}
+
+// MeterSample is a single value of a meter series.
+type MeterSample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// record marshals v as the data of a record. v should be a pointer, otherwise the
+// SETime fields are not marshaled with their own format.
+func record(t time.Time, v any) (Record, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Record{}, fmt.Errorf("cannot marshal record: %w", err)
+	}
+	return Record{Time: t, Data: data}, nil
+}
+
+// AddPowerFlow stores a powerflow which was fetched at the given time.
+func (s *Store) AddPowerFlow(site string, t time.Time, pf solaredge.PowerFlow) error {
+	r, err := record(t, &pf)
+	if err != nil {
+		return err
+	}
+	_, err = s.Upsert(site, SeriesName(KindPowerFlow, ""), r)
+	return err
+}
+
+// PowerFlows returns the stored powerflows in [from, to).
+func (s *Store) PowerFlows(site string, from, to time.Time) ([]PowerFlowSample, error) {
+	recs, err := s.Range(site, SeriesName(KindPowerFlow, ""), from, to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]PowerFlowSample, len(recs))
+	for i, r := range recs {
+		res[i].Time = r.Time
+		if err := json.Unmarshal(r.Data, &res[i].Flow); err != nil {
+			return nil, fmt.Errorf("cannot parse powerflow: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// AddOverview stores an overview with its last update time as timestamp, so the
+// same overview is only stored once.
+func (s *Store) AddOverview(site string, ov solaredge.OverviewData) error {
+	r, err := record(time.Time(ov.LastUpdateTime), &ov)
+	if err != nil {
+		return err
+	}
+	_, err = s.Upsert(site, SeriesName(KindOverview, ""), r)
+	return err
+}
+
+// Overviews returns the stored overviews in [from, to).
+func (s *Store) Overviews(site string, from, to time.Time) ([]solaredge.OverviewData, error) {
+	recs, err := s.Range(site, SeriesName(KindOverview, ""), from, to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]solaredge.OverviewData, len(recs))
+	for i, r := range recs {
+		if err := json.Unmarshal(r.Data, &res[i]); err != nil {
+			return nil, fmt.Errorf("cannot parse overview: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// AddMeters stores the values of the given meters in one series per meter type.
+// The kind should be KindPower or KindEnergy. It returns the number of new or
+// changed values.
+func (s *Store) AddMeters(site string, k Kind, meters []solaredge.MeteredValue) (int, error) {
+	total := 0
+	for _, m := range meters {
+		recs := make([]Record, 0, len(m.Values))
+		for _, v := range m.Values {
+			r, err := record(time.Time(v.Date), v.Value)
+			if err != nil {
+				return total, err
+			}
+			recs = append(recs, r)
+		}
+		n, err := s.Upsert(site, SeriesName(k, m.Type), recs...)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Meter returns the values of a meter type in [from, to).
+func (s *Store) Meter(site string, k Kind, meterType string, from, to time.Time) ([]MeterSample, error) {
+	recs, err := s.Range(site, SeriesName(k, meterType), from, to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MeterSample, len(recs))
+	for i, r := range recs {
+		res[i].Time = r.Time
+		if err := json.Unmarshal(r.Data, &res[i].Value); err != nil {
+			return nil, fmt.Errorf("cannot parse meter value: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// AddStorage stores the telemetries of the batteries in one series per battery
+// serial number. It returns the number of new or changed telemetries.
+func (s *Store) AddStorage(site string, batteries []solaredge.StorageBattery) (int, error) {
+	total := 0
+	for _, b := range batteries {
+		recs := make([]Record, 0, len(b.Telemetries))
+		for i := range b.Telemetries {
+			t := &b.Telemetries[i]
+			r, err := record(time.Time(t.Timestamp), t)
+			if err != nil {
+				return total, err
+			}
+			recs = append(recs, r)
+		}
+		n, err := s.Upsert(site, SeriesName(KindStorage, b.SN), recs...)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Storage returns the telemetries of the battery with the given serial number in
+// [from, to).
+func (s *Store) Storage(site, sn string, from, to time.Time) ([]solaredge.StorageBatteryTelemetry, error) {
+	recs, err := s.Range(site, SeriesName(KindStorage, sn), from, to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]solaredge.StorageBatteryTelemetry, len(recs))
+	for i, r := range recs {
+		if err := json.Unmarshal(r.Data, &res[i]); err != nil {
+			return nil, fmt.Errorf("cannot parse storage telemetry: %w", err)
+		}
+	}
+	return res, nil
+}
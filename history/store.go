@@ -0,0 +1,339 @@
+// Package history is a small embedded store for historical solaredge data. Every
+// site has a number of series (like the powerflow or the production meter of the
+// power details) which contain records sorted by their timestamp. The series are
+// kept in memory and persisted as JSON lines below a base directory.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the first part of a series name and groups the series for retention.
+type Kind string
+
+var (
+	KindPowerFlow Kind = "powerflow"
+	KindOverview  Kind = "overview"
+	KindPower     Kind = "power"
+	KindEnergy    Kind = "energy"
+	KindStorage   Kind = "storage"
+)
+
+const (
+	fileSuffix = ".jsonl"
+)
+
+// A Record is a single value of a series.
+type Record struct {
+	Time time.Time       `json:"t"`
+	Data json.RawMessage `json:"v"`
+}
+
+// Opt is an option type for the Store.
+type Opt func(s *Store)
+
+// WithRetention removes the records of all series of the given kind which are
+// older than the given duration when the store is pruned.
+func WithRetention(k Kind, d time.Duration) Opt {
+	return func(s *Store) {
+		s.retention[k] = d
+	}
+}
+
+type series struct {
+	records []Record
+	// dead counts the lines in the file which are overwritten by later lines
+	dead int
+}
+
+// A Store holds the series of many sites.
+type Store struct {
+	lock      sync.Mutex
+	dir       string
+	retention map[Kind]time.Duration
+	series    map[string]*series
+}
+
+// Open returns a store which persists its data in the given directory. The
+// directory is created if it does not exist.
+func Open(dir string, opts ...Opt) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create history directory: %w", err)
+	}
+	res := &Store{
+		dir:       dir,
+		retention: make(map[Kind]time.Duration),
+		series:    make(map[string]*series),
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	return res, nil
+}
+
+// SeriesName returns the name of a series with the given kind and an optional
+// sub name, e.g. the meter type.
+func SeriesName(k Kind, name string) string {
+	if name == "" {
+		return string(k)
+	}
+	return string(k) + "/" + name
+}
+
+func seriesKind(name string) Kind {
+	k, _, _ := strings.Cut(name, "/")
+	return Kind(k)
+}
+
+func (s *Store) path(site, name string) string {
+	return filepath.Join(s.dir, site, filepath.FromSlash(name)+fileSuffix)
+}
+
+// load returns the series and reads it from disk on first access. The caller
+// must hold the write lock.
+func (s *Store) load(site, name string) (*series, error) {
+	key := site + "/" + name
+	if se, ok := s.series[key]; ok {
+		return se, nil
+	}
+	se := &series{}
+	f, err := os.Open(s.path(site, name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot open series %q: %w", key, err)
+	}
+	if err == nil {
+		defer f.Close()
+		var recs []Record
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return nil, fmt.Errorf("cannot parse record of series %q: %w", key, err)
+			}
+			recs = append(recs, r)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("cannot read series %q: %w", key, err)
+		}
+		// later lines overwrite earlier lines with the same timestamp
+		sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time.Before(recs[j].Time) })
+		for _, r := range recs {
+			if n := len(se.records); n > 0 && se.records[n-1].Time.Equal(r.Time) {
+				se.records[n-1] = r
+				se.dead++
+				continue
+			}
+			se.records = append(se.records, r)
+		}
+	}
+	s.series[key] = se
+	return se, nil
+}
+
+// Upsert inserts the records into the series of the site. A record with the same
+// timestamp as an existing one replaces it. Records which are equal to the stored
+// ones are skipped, so the number of changed records is returned.
+func (s *Store) Upsert(site, name string, recs ...Record) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	se, err := s.load(site, name)
+	if err != nil {
+		return 0, err
+	}
+	var changed []Record
+	for _, r := range recs {
+		r.Time = r.Time.UTC()
+		idx := sort.Search(len(se.records), func(i int) bool { return !se.records[i].Time.Before(r.Time) })
+		if idx < len(se.records) && se.records[idx].Time.Equal(r.Time) {
+			if string(se.records[idx].Data) == string(r.Data) {
+				continue
+			}
+			se.records[idx] = r
+			se.dead++
+		} else {
+			se.records = append(se.records, Record{})
+			copy(se.records[idx+1:], se.records[idx:])
+			se.records[idx] = r
+		}
+		changed = append(changed, r)
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	return len(changed), s.appendFile(site, name, changed)
+}
+
+func (s *Store) appendFile(site, name string, recs []Record) error {
+	p := s.path(site, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("cannot create series directory: %w", err)
+	}
+	return writeRecords(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, recs)
+}
+
+func writeRecords(p string, flag int, recs []Record) error {
+	f, err := os.OpenFile(p, flag, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open series file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range recs {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return fmt.Errorf("cannot write record: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write series file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close series file: %w", err)
+	}
+	return nil
+}
+
+// Range returns the records of the series with a timestamp in [from, to). A zero
+// from or to is unbounded.
+func (s *Store) Range(site, name string, from, to time.Time) ([]Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	se, err := s.load(site, name)
+	if err != nil {
+		return nil, err
+	}
+	lo := 0
+	if !from.IsZero() {
+		lo = sort.Search(len(se.records), func(i int) bool { return !se.records[i].Time.Before(from) })
+	}
+	hi := len(se.records)
+	if !to.IsZero() {
+		hi = sort.Search(len(se.records), func(i int) bool { return !se.records[i].Time.Before(to) })
+	}
+	if lo >= hi {
+		return nil, nil
+	}
+	res := make([]Record, hi-lo)
+	copy(res, se.records[lo:hi])
+	return res, nil
+}
+
+// Latest returns the newest record of the series.
+func (s *Store) Latest(site, name string) (Record, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	se, err := s.load(site, name)
+	if err != nil || len(se.records) == 0 {
+		return Record{}, false, err
+	}
+	return se.records[len(se.records)-1], true, nil
+}
+
+// Sites returns all sites with stored data.
+func (s *Store) Sites() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history directory: %w", err)
+	}
+	var res []string
+	for _, e := range entries {
+		if e.IsDir() {
+			res = append(res, e.Name())
+		}
+	}
+	return res, nil
+}
+
+// Series returns the names of all series of a site.
+func (s *Store) Series(site string) ([]string, error) {
+	base := filepath.Join(s.dir, site)
+	var res []string
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, fileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(base, strings.TrimSuffix(p, fileSuffix))
+		if err != nil {
+			return err
+		}
+		res = append(res, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list series of site %q: %w", site, err)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Prune removes all records which are older than the retention of their kind and
+// rewrites the files of all series with removed or overwritten records.
+func (s *Store) Prune(now time.Time) error {
+	sites, err := s.Sites()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, site := range sites {
+		names, err := s.Series(site)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			se, err := s.load(site, name)
+			if err != nil {
+				return err
+			}
+			if d, ok := s.retention[seriesKind(name)]; ok {
+				limit := now.Add(-d)
+				idx := sort.Search(len(se.records), func(i int) bool { return !se.records[i].Time.Before(limit) })
+				if idx > 0 {
+					se.records = append([]Record(nil), se.records[idx:]...)
+					se.dead += idx
+				}
+			}
+			if se.dead > 0 {
+				if err := s.rewrite(site, name, se); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite writes the series to a new file and replaces the old one.
+func (s *Store) rewrite(site, name string, se *series) error {
+	p := s.path(site, name)
+	tmp := p + ".tmp"
+	if err := writeRecords(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, se.records); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return fmt.Errorf("cannot replace series file: %w", err)
+	}
+	se.dead = 0
+	return nil
+}
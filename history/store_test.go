@@ -0,0 +1,204 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func rec(min int, v any) Record {
+	data, _ := json.Marshal(v)
+	return Record{Time: t0.Add(time.Duration(min) * time.Minute), Data: data}
+}
+
+func openStore(t *testing.T, dir string, opts ...Opt) *Store {
+	t.Helper()
+	s, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// lines returns the number of lines in the file of the series.
+func lines(t *testing.T, s *Store, site, name string) int {
+	t.Helper()
+	f, err := os.Open(s.path(site, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func values(t *testing.T, recs []Record) string {
+	t.Helper()
+	res := ""
+	for _, r := range recs {
+		res += fmt.Sprintf("%d=%s ", int(r.Time.Sub(t0)/time.Minute), r.Data)
+	}
+	return res
+}
+
+func TestUpsert(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	name := SeriesName(KindPowerFlow, "")
+
+	n, err := s.Upsert("1", name, rec(10, 1), rec(0, 0), rec(20, 2))
+	if err != nil || n != 3 {
+		t.Fatalf("inserted %d records: %v", n, err)
+	}
+	// an equal record is skipped, a changed one replaces the stored one and the
+	// local time is stored as UTC
+	local := rec(10, 5)
+	local.Time = local.Time.In(time.FixedZone("site", 3600))
+	n, err = s.Upsert("1", name, rec(0, 0), local, rec(5, 3))
+	if err != nil || n != 2 {
+		t.Fatalf("changed %d records: %v", n, err)
+	}
+	all, err := s.Range("1", name, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(t, all), "0=0 5=3 10=5 20=2 "; got != want {
+		t.Errorf("series is %q, want %q", got, want)
+	}
+	if all[2].Time.Location() != time.UTC {
+		t.Errorf("record has location %v", all[2].Time.Location())
+	}
+	if l := lines(t, s, "1", name); l != 5 {
+		t.Errorf("file has %d lines, want 5", l)
+	}
+
+	// a new store reads the later lines over the earlier ones
+	reopened := openStore(t, dir)
+	all, err = reopened.Range("1", name, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(t, all), "0=0 5=3 10=5 20=2 "; got != want {
+		t.Errorf("reopened series is %q, want %q", got, want)
+	}
+	if last, ok, err := reopened.Latest("1", name); err != nil || !ok || !last.Time.Equal(t0.Add(20*time.Minute)) {
+		t.Errorf("latest record is %v, %v, %v", last, ok, err)
+	}
+	if _, ok, err := reopened.Latest("2", name); err != nil || ok {
+		t.Errorf("unknown site has a latest record: %v, %v", ok, err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	name := SeriesName(KindEnergy, "Production")
+	if _, err := s.Upsert("1", name, rec(0, 0), rec(15, 1), rec(30, 2), rec(45, 3)); err != nil {
+		t.Fatal(err)
+	}
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	for _, tc := range []struct {
+		from, to time.Time
+		want     string
+	}{
+		{time.Time{}, time.Time{}, "0=0 15=1 30=2 45=3 "},
+		{at(15), at(45), "15=1 30=2 "},
+		{at(14), at(46), "15=1 30=2 45=3 "},
+		{at(16), time.Time{}, "30=2 45=3 "},
+		{time.Time{}, at(15), "0=0 "},
+		{at(30), at(30), ""},
+		{at(45), at(15), ""},
+		{at(60), time.Time{}, ""},
+	} {
+		recs, err := s.Range("1", name, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := values(t, recs); got != tc.want {
+			t.Errorf("range [%v, %v) is %q, want %q", tc.from, tc.to, got, tc.want)
+		}
+	}
+	// the result is a copy
+	recs, _ := s.Range("1", name, time.Time{}, time.Time{})
+	recs[0].Data = json.RawMessage("9")
+	if recs, _ := s.Range("1", name, time.Time{}, at(1)); values(t, recs) != "0=0 " {
+		t.Errorf("range returned the stored records")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithRetention(KindPowerFlow, 30*time.Minute))
+	flow := SeriesName(KindPowerFlow, "")
+	energy := SeriesName(KindEnergy, "Production")
+	if _, err := s.Upsert("1", flow, rec(0, 0), rec(20, 1), rec(40, 2), rec(60, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Upsert("1", energy, rec(0, 0), rec(60, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Upsert("2", energy, rec(0, 0), rec(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Prune(t0.Add(70 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		site, name string
+		want       string
+		lines      int
+	}{
+		// only the kind with a retention loses records
+		{"1", flow, "40=2 60=3 ", 2},
+		{"1", energy, "0=0 60=1 ", 2},
+		// the overwritten record is removed from the file
+		{"2", energy, "0=1 ", 1},
+	} {
+		recs, err := s.Range(tc.site, tc.name, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := values(t, recs); got != tc.want {
+			t.Errorf("%s/%s is %q, want %q", tc.site, tc.name, got, tc.want)
+		}
+		if l := lines(t, s, tc.site, tc.name); l != tc.lines {
+			t.Errorf("%s/%s has %d lines, want %d", tc.site, tc.name, l, tc.lines)
+		}
+		// the rewritten files are read the same way
+		recs, err = openStore(t, dir).Range(tc.site, tc.name, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := values(t, recs); got != tc.want {
+			t.Errorf("reopened %s/%s is %q, want %q", tc.site, tc.name, got, tc.want)
+		}
+	}
+	if _, err := os.Stat(s.path("1", flow) + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left: %v", err)
+	}
+
+	// appending after a rewrite keeps the pruned file
+	if _, err := s.Upsert("1", flow, rec(80, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if l := lines(t, s, "1", flow); l != 3 {
+		t.Errorf("file has %d lines after append, want 3", l)
+	}
+	sites, err := s.Sites()
+	if err != nil || fmt.Sprint(sites) != "[1 2]" {
+		t.Errorf("sites are %v, %v", sites, err)
+	}
+	series, err := s.Series("1")
+	if err != nil || fmt.Sprint(series) != "[energy/Production powerflow]" {
+		t.Errorf("series are %v, %v", series, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package influx writes the data of solaredge sites to InfluxDB with the line
+// protocol. The Writer supports the HTTP write APIs of InfluxDB v1 and v2,
+// batches the points and keeps them in a file while the database is down.
+package influx
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/ulrichSchreiner/solaredge/export"
+)
+
+// A Point is a single line of the line protocol.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func sortedKeys[T any](m map[string]T) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func fieldValue(v any) (string, bool) {
+	switch val := v.(type) {
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(val) + "i", true
+	case int64:
+		return strconv.FormatInt(val, 10) + "i", true
+	case bool:
+		return strconv.FormatBool(val), true
+	case string:
+		return `"` + stringEscaper.Replace(val) + `"`, true
+	}
+	return "", false
+}
+
+// Line returns the point in the line protocol with a timestamp in nanoseconds.
+// Fields with unsupported values like NaN are left out; if no field is left,
+// the line is empty.
+func (p Point) Line() string {
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			continue
+		}
+		sb.WriteString("," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(p.Tags[k]))
+	}
+	sep := " "
+	for _, k := range sortedKeys(p.Fields) {
+		v, ok := fieldValue(p.Fields[k])
+		if !ok {
+			continue
+		}
+		sb.WriteString(sep + keyEscaper.Replace(k) + "=" + v)
+		sep = ","
+	}
+	if sep == " " {
+		return ""
+	}
+	sb.WriteString(" " + strconv.FormatInt(p.Time.UnixNano(), 10))
+	return sb.String()
+}
+
+// SeriesPoints converts the observations of a series to points. All names with
+// the same time and key become the fields of one point; the key is a tag named
+// like the key column of the series.
+func SeriesPoints(measurement string, tags map[string]string, s *export.Series) []Point {
+	// keyed by the instant, equal times can have different locations
+	type pointKey struct {
+		t   int64
+		key string
+	}
+	var res []Point
+	idx := make(map[pointKey]int)
+	for _, o := range s.Observations {
+		pk := pointKey{o.Time.UnixNano(), o.Key}
+		i, ok := idx[pk]
+		if !ok {
+			pt := Point{Measurement: measurement, Tags: map[string]string{}, Fields: map[string]any{}, Time: o.Time}
+			for k, v := range tags {
+				pt.Tags[k] = v
+			}
+			if o.Key != "" {
+				pt.Tags[s.KeyColumn] = o.Key
+			}
+			i = len(res)
+			idx[pk] = i
+			res = append(res, pt)
+		}
+		res[i].Fields[o.Name] = o.Value
+	}
+	return res
+}
@@ -0,0 +1,381 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchSize     = 5000
+	DefaultFlushInterval = 10 * time.Second
+	DefaultRetries       = 3
+)
+
+// Opt is an option type for the Writer.
+type Opt func(w *Writer)
+
+// WithV1 writes to the database and retention policy of InfluxDB v1. The
+// username and password may be empty.
+func WithV1(database, retentionPolicy, username, password string) Opt {
+	return func(w *Writer) {
+		w.path = "/write"
+		w.query = url.Values{"db": {database}, "precision": {"ns"}}
+		if retentionPolicy != "" {
+			w.query.Set("rp", retentionPolicy)
+		}
+		if username != "" {
+			w.query.Set("u", username)
+			w.query.Set("p", password)
+		}
+	}
+}
+
+// WithV2 writes to the bucket of the organization of InfluxDB v2 with the
+// given API token.
+func WithV2(org, bucket, token string) Opt {
+	return func(w *Writer) {
+		w.path = "/api/v2/write"
+		w.query = url.Values{"org": {org}, "bucket": {bucket}, "precision": {"ns"}}
+		w.token = token
+	}
+}
+
+// WithBatchSize writes the points as soon as n points are pending.
+func WithBatchSize(n int) Opt {
+	return func(w *Writer) {
+		w.batchSize = n
+	}
+}
+
+// WithFlushInterval writes the pending points at least every d. With 0 the
+// points are only written when a batch is full or the writer is flushed.
+func WithFlushInterval(d time.Duration) Opt {
+	return func(w *Writer) {
+		w.flushInterval = d
+	}
+}
+
+// WithRetries sets how often a failed write is repeated before the points are
+// buffered.
+func WithRetries(n int) Opt {
+	return func(w *Writer) {
+		w.retries = n
+	}
+}
+
+// WithBuffer keeps the points which cannot be written in the given file. They
+// are written before any new points as soon as the database is back.
+func WithBuffer(file string) Opt {
+	return func(w *Writer) {
+		w.buffer = file
+	}
+}
+
+// WithHTTPClient sets the http client for the writes.
+func WithHTTPClient(c *http.Client) Opt {
+	return func(w *Writer) {
+		w.client = c
+	}
+}
+
+// WithErrorHandler is called with the errors of the writes in the background.
+func WithErrorHandler(f func(error)) Opt {
+	return func(w *Writer) {
+		w.onError = f
+	}
+}
+
+// A permanentError is returned by the database for invalid data; repeating the
+// write would fail again.
+type permanentError struct {
+	status int
+	body   string
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("write rejected with %d: %s", e.status, e.body)
+}
+
+// An authError is returned by the database for invalid credentials. The write
+// is not repeated at once, but the points are kept until the credentials are
+// fixed.
+type authError struct {
+	status int
+	body   string
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("write unauthorized with %d: %s", e.status, e.body)
+}
+
+// A Writer writes points in batches to InfluxDB. The points are written in the
+// background, so a caller never waits for the database.
+type Writer struct {
+	lock          sync.Mutex
+	client        *http.Client
+	base          string
+	path          string
+	query         url.Values
+	token         string
+	batchSize     int
+	flushInterval time.Duration
+	retries       int
+	retryWait     time.Duration
+	buffer        string
+	onError       func(error)
+	pending       []string
+	// sending is held while the points are written to the database or the
+	// buffer, so the order of the points is kept
+	sending sync.Mutex
+	full    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New returns a writer for the InfluxDB at the given URL, e.g.
+// http://localhost:8086. Either WithV1 or WithV2 must be given.
+func New(baseurl string, opts ...Opt) (*Writer, error) {
+	res := &Writer{
+		client:        &http.Client{Timeout: 30 * time.Second},
+		base:          strings.TrimSuffix(baseurl, "/"),
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		retries:       DefaultRetries,
+		retryWait:     time.Second,
+		full:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	if res.path == "" {
+		return nil, fmt.Errorf("influxdb needs a database (v1) or a bucket (v2)")
+	}
+	res.wg.Add(1)
+	go res.flushLoop()
+	return res, nil
+}
+
+// flushLoop writes the pending points when a batch is full and every flush
+// interval.
+func (w *Writer) flushLoop() {
+	defer w.wg.Done()
+	var tick <-chan time.Time
+	if w.flushInterval > 0 {
+		t := time.NewTicker(w.flushInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick:
+		case <-w.full:
+		}
+		if err := w.Flush(); err != nil && w.onError != nil {
+			w.onError(err)
+		}
+	}
+}
+
+// Write adds the points to the pending batch. A full batch is written in the
+// background, the errors go to the error handler.
+func (w *Writer) Write(points ...Point) {
+	w.lock.Lock()
+	for _, p := range points {
+		if l := p.Line(); l != "" {
+			w.pending = append(w.pending, l)
+		}
+	}
+	full := len(w.pending) >= w.batchSize
+	w.lock.Unlock()
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all pending points and waits for the database.
+func (w *Writer) Flush() error {
+	w.sending.Lock()
+	defer w.sending.Unlock()
+
+	lines := w.takePending()
+	if err := w.flushBuffer(); err != nil {
+		// keep the order of the points, the new ones go behind the buffered ones
+		return w.keep(lines, err)
+	}
+	var res error
+	for len(lines) > 0 {
+		n := len(lines)
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		if err := w.send(lines[:n]); err != nil {
+			var perm *permanentError
+			if !errors.As(err, &perm) {
+				return w.keep(lines, err)
+			}
+			// the batch is dropped, the following ones are still written
+			if res == nil {
+				res = fmt.Errorf("cannot write %d points: %w", n, err)
+			}
+		}
+		lines = lines[n:]
+	}
+	return res
+}
+
+// Close writes the pending points and stops the writer.
+func (w *Writer) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return w.Flush()
+}
+
+func (w *Writer) takePending() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	res := w.pending
+	w.pending = nil
+	return res
+}
+
+// putBack puts the lines in front of the points which were written in the
+// meantime, but never keeps more than ten batches.
+func (w *Writer) putBack(lines []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	lines = append(lines, w.pending...)
+	if max := 10 * w.batchSize; len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	w.pending = lines
+}
+
+// keep appends the lines to the buffer file. Without a buffer they stay
+// pending. The caller must hold the sending lock.
+func (w *Writer) keep(lines []string, cause error) error {
+	if w.buffer == "" {
+		w.putBack(lines)
+		return fmt.Errorf("cannot write points: %w", cause)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("cannot write buffered points: %w", cause)
+	}
+	f, err := os.OpenFile(w.buffer, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		w.putBack(lines)
+		return fmt.Errorf("cannot open buffer %s: %w", w.buffer, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		w.putBack(lines)
+		return fmt.Errorf("cannot write buffer %s: %w", w.buffer, err)
+	}
+	return fmt.Errorf("cannot write points, %d points are buffered: %w", len(lines), cause)
+}
+
+// flushBuffer writes the points of the buffer file and removes the file. If a
+// write fails, the file keeps the lines which are not written. The caller must
+// hold the sending lock.
+func (w *Writer) flushBuffer() error {
+	if w.buffer == "" {
+		return nil
+	}
+	f, err := os.Open(w.buffer)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open buffer %s: %w", w.buffer, err)
+	}
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if l := sc.Text(); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	f.Close()
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("cannot read buffer %s: %w", w.buffer, err)
+	}
+
+	for len(lines) > 0 {
+		n := len(lines)
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		err := w.send(lines[:n])
+		var perm *permanentError
+		if err != nil && !errors.As(err, &perm) {
+			rest := []byte(strings.Join(lines, "\n") + "\n")
+			if werr := ioutil.WriteFile(w.buffer, rest, 0644); werr != nil {
+				return fmt.Errorf("cannot rewrite buffer %s: %w", w.buffer, werr)
+			}
+			return err
+		}
+		lines = lines[n:]
+	}
+	if err := os.Remove(w.buffer); err != nil {
+		return fmt.Errorf("cannot remove buffer %s: %w", w.buffer, err)
+	}
+	return nil
+}
+
+// send writes the lines and retries temporary failures.
+func (w *Writer) send(lines []string) error {
+	body := []byte(strings.Join(lines, "\n"))
+	wait := w.retryWait
+	var err error
+	for i := 0; ; i++ {
+		err = w.post(body)
+		var perm *permanentError
+		var auth *authError
+		if err == nil || errors.As(err, &perm) || errors.As(err, &auth) || i >= w.retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (w *Writer) post(body []byte) error {
+	rq, err := http.NewRequest(http.MethodPost, w.base+w.path+"?"+w.query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	rq.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		rq.Header.Set("Authorization", "Token "+w.token)
+	}
+	rsp, err := w.client.Do(rq)
+	if err != nil {
+		return fmt.Errorf("cannot invoke request: %w", err)
+	}
+	defer rsp.Body.Close()
+	data, _ := ioutil.ReadAll(rsp.Body)
+	switch {
+	case rsp.StatusCode/100 == 2:
+		return nil
+	case rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500:
+		return fmt.Errorf("responsecode %d, data: %s", rsp.StatusCode, strings.TrimSpace(string(data)))
+	case rsp.StatusCode == http.StatusUnauthorized || rsp.StatusCode == http.StatusForbidden:
+		return &authError{status: rsp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	return &permanentError{status: rsp.StatusCode, body: strings.TrimSpace(string(data))}
+}
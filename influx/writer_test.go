@@ -0,0 +1,318 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDB records the written batches. While status is set, the writes are
+// answered with it.
+type testDB struct {
+	lock    sync.Mutex
+	status  int
+	reject  string
+	posts   int
+	batches [][]string
+	block   chan struct{}
+	rq      *http.Request
+}
+
+func (db *testDB) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+	if db.block != nil {
+		<-db.block
+	}
+	data, _ := ioutil.ReadAll(rq.Body)
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.posts++
+	db.rq = rq
+	body := string(data)
+	switch {
+	case db.status != 0:
+		http.Error(rw, "failed", db.status)
+	case db.reject != "" && strings.Contains(body, db.reject):
+		http.Error(rw, "invalid line", http.StatusBadRequest)
+	default:
+		db.batches = append(db.batches, strings.Split(body, "\n"))
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (db *testDB) set(status int) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.status = status
+}
+
+// lines returns the written lines in the order of the writes.
+func (db *testDB) lines() []string {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var res []string
+	for _, b := range db.batches {
+		res = append(res, b...)
+	}
+	return res
+}
+
+func testWriter(t *testing.T, opts ...Opt) (*Writer, *testDB) {
+	t.Helper()
+	db := &testDB{}
+	srv := httptest.NewServer(db)
+	t.Cleanup(srv.Close)
+	w, err := New(srv.URL, append([]Opt{WithV1("solar", "", "", ""), WithFlushInterval(0)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.retryWait = time.Millisecond
+	return w, db
+}
+
+func point(i int) Point {
+	return Point{Measurement: "m", Fields: map[string]any{"v": i}, Time: time.Unix(int64(i), 0)}
+}
+
+func points(from, to int) []Point {
+	var res []Point
+	for i := from; i < to; i++ {
+		res = append(res, point(i))
+	}
+	return res
+}
+
+func lines(from, to int) []string {
+	var res []string
+	for i := from; i < to; i++ {
+		res = append(res, point(i).Line())
+	}
+	return res
+}
+
+func TestWriterBatches(t *testing.T) {
+	w, db := testWriter(t, WithBatchSize(3), WithV2("home", "solar", "secret"))
+	w.Write(points(0, 2)...)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(points(2, 9)...)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(0, 9)) {
+		t.Errorf("written lines are %v", got)
+	}
+	for _, b := range db.batches {
+		if len(b) > 3 {
+			t.Errorf("batch has %d lines", len(b))
+		}
+	}
+	if db.rq.URL.Path != "/api/v2/write" || db.rq.URL.Query().Get("bucket") != "solar" || db.rq.URL.Query().Get("org") != "home" || db.rq.Header.Get("Authorization") != "Token secret" {
+		t.Errorf("unexpected request %v %v", db.rq.URL, db.rq.Header)
+	}
+}
+
+func TestWriterInterval(t *testing.T) {
+	w, db := testWriter(t, WithFlushInterval(5*time.Millisecond))
+	defer w.Close()
+	w.Write(point(1))
+	for i := 0; i < 200 && len(db.lines()) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(1, 2)) {
+		t.Errorf("written lines are %v", got)
+	}
+}
+
+func TestWriterDoesNotBlock(t *testing.T) {
+	w, db := testWriter(t, WithBatchSize(1))
+	db.block = make(chan struct{})
+	// the first point is written in the background and waits for the database
+	w.Write(point(0))
+	done := make(chan struct{})
+	go func() {
+		w.Write(points(1, 3)...)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write waits for the database")
+	}
+	close(db.block)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(0, 3)) {
+		t.Errorf("written lines are %v", got)
+	}
+}
+
+func TestWriterRetries(t *testing.T) {
+	w, db := testWriter(t, WithRetries(2))
+	db.set(http.StatusServiceUnavailable)
+	w.Write(points(0, 2)...)
+	if err := w.Flush(); err == nil {
+		t.Fatal("flush did not fail")
+	}
+	if db.posts != 3 {
+		t.Errorf("write was tried %d times, want 3", db.posts)
+	}
+	// the failed points are written before the new ones
+	w.Write(points(2, 4)...)
+	db.set(0)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(0, 4)) {
+		t.Errorf("written lines are %v", got)
+	}
+}
+
+func TestWriterKeepsLimit(t *testing.T) {
+	w, db := testWriter(t, WithBatchSize(2), WithRetries(0))
+	db.set(http.StatusInternalServerError)
+	for i := 0; i < 30; i++ {
+		w.Write(point(i))
+		_ = w.Flush()
+	}
+	db.set(0)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// only the newest ten batches are kept
+	if got := db.lines(); !reflect.DeepEqual(got, lines(10, 30)) {
+		t.Errorf("written lines are %v", got)
+	}
+}
+
+func TestWriterUnauthorized(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		w, db := testWriter(t, WithRetries(3))
+		db.set(status)
+		w.Write(points(0, 2)...)
+		err := w.Flush()
+		var auth *authError
+		if !errors.As(err, &auth) {
+			t.Errorf("%d: flush returned %v", status, err)
+		}
+		if db.posts != 1 {
+			t.Errorf("%d: rejected write was tried %d times", status, db.posts)
+		}
+		// the points are written when the credentials are fixed
+		db.set(0)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := db.lines(); !reflect.DeepEqual(got, lines(0, 2)) {
+			t.Errorf("%d: written lines are %v", status, got)
+		}
+	}
+}
+
+func TestWriterPermanent(t *testing.T) {
+	w, db := testWriter(t, WithBatchSize(2))
+	db.reject = point(0).Line()
+	w.Write(points(0, 5)...)
+	err := w.Flush()
+	var perm *permanentError
+	if !errors.As(err, &perm) {
+		t.Errorf("flush returned %v", err)
+	}
+	// only the rejected batch is dropped
+	if got := db.lines(); !reflect.DeepEqual(got, lines(2, 5)) {
+		t.Errorf("written lines are %v", got)
+	}
+	if err := w.Flush(); err != nil || db.posts != 3 {
+		t.Errorf("second flush posted %d times: %v", db.posts-3, err)
+	}
+}
+
+func TestWriterBuffer(t *testing.T) {
+	buffer := filepath.Join(t.TempDir(), "influx.buf")
+	w, db := testWriter(t, WithBatchSize(2), WithRetries(0), WithBuffer(buffer))
+	db.set(http.StatusServiceUnavailable)
+	w.Write(points(0, 3)...)
+	if err := w.Flush(); err == nil {
+		t.Fatal("flush did not fail")
+	}
+	w.Write(point(3))
+	if err := w.Flush(); err == nil {
+		t.Fatal("flush did not fail")
+	}
+	data, err := ioutil.ReadFile(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(lines(0, 4), "\n") + "\n"; string(data) != want {
+		t.Errorf("buffer is %q, want %q", data, want)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("close did not fail")
+	}
+
+	// a new writer replays the buffer before the new points
+	w, db = testWriter(t, WithBatchSize(2), WithRetries(0), WithBuffer(buffer))
+	w.Write(points(4, 6)...)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(0, 6)) {
+		t.Errorf("written lines are %v", got)
+	}
+	if len(db.batches) != 3 {
+		t.Errorf("written batches are %v", db.batches)
+	}
+	if _, err := os.Stat(buffer); !os.IsNotExist(err) {
+		t.Errorf("buffer is not removed: %v", err)
+	}
+}
+
+func TestWriterBufferPartial(t *testing.T) {
+	buffer := filepath.Join(t.TempDir(), "influx.buf")
+	if err := ioutil.WriteFile(buffer, []byte(strings.Join(lines(0, 4), "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, db := testWriter(t, WithBatchSize(2), WithRetries(0), WithBuffer(buffer))
+	w.Write(point(4))
+	// the second batch of the buffer fails
+	posts := 0
+	w.client.Transport = roundTripFunc(func(rq *http.Request) (*http.Response, error) {
+		if posts++; posts == 2 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return http.DefaultTransport.RoundTrip(rq)
+	})
+	if err := w.Flush(); err == nil {
+		t.Fatal("flush did not fail")
+	}
+	data, err := ioutil.ReadFile(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the written batch is removed from the buffer, the new point is appended
+	if want := strings.Join(append(lines(2, 4), lines(4, 5)...), "\n") + "\n"; string(data) != want {
+		t.Errorf("buffer is %q, want %q", data, want)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.lines(); !reflect.DeepEqual(got, lines(0, 5)) {
+		t.Errorf("written lines are %v", got)
+	}
+}
+
+type roundTripFunc func(rq *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(rq *http.Request) (*http.Response, error) {
+	return f(rq)
+}
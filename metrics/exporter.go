@@ -0,0 +1,228 @@
+// Package metrics exports the data of solaredge sites as prometheus metrics. The
+// Exporter is a prometheus.Collector which asks a source for the current values
+// of all sites when it is collected, so it can be registered in any registry.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const (
+	namespace = "solaredge"
+)
+
+var (
+	siteLabels = []string{"site_id", "site_name"}
+)
+
+// StaleMode selects what happens with the power gauges of a site when its
+// powerflow is stale.
+type StaleMode string
+
+var (
+	// KeepStale exports the last values.
+	KeepStale StaleMode = "keep"
+	// NaNStale exports NaN, so dashboards show a gap.
+	NaNStale StaleMode = "nan"
+	// BlankStale omits the gauges.
+	BlankStale StaleMode = "blank"
+)
+
+// ParseStaleMode returns the StaleMode with the given name.
+func ParseStaleMode(s string) (StaleMode, error) {
+	switch m := StaleMode(s); m {
+	case KeepStale, NaNStale, BlankStale:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown stale mode %q, use keep, nan or blank", s)
+}
+
+// A SiteSnapshot contains the current values of a site. Powers are in W, energies
+// in Wh.
+type SiteSnapshot struct {
+	ID             string
+	Name           string
+	PV             float64
+	Grid           float64
+	Load           float64
+	Battery        float64
+	SoC            float64
+	HasStorage     bool
+	Critical       bool
+	LifetimeEnergy float64
+	YearEnergy     float64
+	MonthEnergy    float64
+	DayEnergy      float64
+	LastUpdate     time.Time
+	RemainingQuota int
+	// FlowChanged is the last time the powerflow changed; FlowStale and
+	// OverviewStale are true if the data did not change for too long.
+	FlowChanged   time.Time
+	FlowStale     bool
+	OverviewStale bool
+}
+
+// Opt is an option for the Exporter.
+type Opt func(e *Exporter)
+
+// WithStaleMode sets what happens with the power gauges of stale sites.
+func WithStaleMode(m StaleMode) Opt {
+	return func(e *Exporter) {
+		e.staleMode = m
+	}
+}
+
+// Exporter is a prometheus.Collector for the values of many sites.
+type Exporter struct {
+	source    func() []SiteSnapshot
+	staleMode StaleMode
+
+	pv             *prometheus.Desc
+	grid           *prometheus.Desc
+	load           *prometheus.Desc
+	battery        *prometheus.Desc
+	soc            *prometheus.Desc
+	critical       *prometheus.Desc
+	energy         *prometheus.Desc
+	lastUpdate     *prometheus.Desc
+	flowChange     *prometheus.Desc
+	stale          *prometheus.Desc
+	remainingQuota *prometheus.Desc
+
+	pollSuccess *prometheus.CounterVec
+	pollFailure *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+}
+
+// NewExporter returns an Exporter which calls source for the values of the sites
+// every time the metrics are collected.
+func NewExporter(source func() []SiteSnapshot, opts ...Opt) *Exporter {
+	desc := func(subsystem, name, help string, extra ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, append(siteLabels, extra...), nil)
+	}
+	pollLabels := append(append([]string(nil), siteLabels...), "endpoint")
+	res := &Exporter{
+		source:         source,
+		staleMode:      KeepStale,
+		pv:             desc("pv", "current_power", "the current power of the pv"),
+		grid:           desc("grid", "current_power", "the current power of the grid"),
+		load:           desc("load", "current_power", "the current power of the load"),
+		battery:        desc("battery", "current_power", "the current power of the battery"),
+		soc:            desc("soc", "current_value", "the current state of charge of the battery"),
+		critical:       desc("battery", "critical", "1 if the battery is in a critical state"),
+		energy:         desc("", "energy_wh_total", "the produced energy of the period given by the period label", "period"),
+		lastUpdate:     desc("", "last_update_timestamp_seconds", "the last update time of the site data"),
+		flowChange:     desc("powerflow", "last_change_timestamp_seconds", "the last time the powerflow changed"),
+		stale:          desc("", "stale", "1 if the data of the kind did not change for too long", "kind"),
+		remainingQuota: desc("api", "remaining_quota", "the number of API calls which are left for the current day"),
+		pollSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "poll",
+			Name:      "success_total",
+			Help:      "the number of successful API calls per endpoint",
+		}, pollLabels),
+		pollFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "poll",
+			Name:      "failure_total",
+			Help:      "the number of failed API calls per endpoint",
+		}, pollLabels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_duration_seconds",
+			Help:      "the duration of the API calls per endpoint",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		}, pollLabels),
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// ObservePoll counts a call of an API endpoint for the site and records its
+// duration.
+func (e *Exporter) ObservePoll(siteID, siteName, endpoint string, d time.Duration, err error) {
+	lbls := prometheus.Labels{"site_id": siteID, "site_name": siteName, "endpoint": endpoint}
+	if err != nil {
+		e.pollFailure.With(lbls).Inc()
+	} else {
+		e.pollSuccess.With(lbls).Inc()
+	}
+	e.latency.With(lbls).Observe(d.Seconds())
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{e.pv, e.grid, e.load, e.battery, e.soc, e.critical, e.energy, e.lastUpdate, e.flowChange, e.stale, e.remainingQuota} {
+		ch <- d
+	}
+	e.pollSuccess.Describe(ch)
+	e.pollFailure.Describe(ch)
+	e.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(d *prometheus.Desc, v float64, lbls ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, lbls...)
+	}
+	flag := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	for _, s := range e.source() {
+		if !s.FlowStale || e.staleMode != BlankStale {
+			value := func(v float64) float64 {
+				if s.FlowStale && e.staleMode == NaNStale {
+					return math.NaN()
+				}
+				return v
+			}
+			gauge(e.pv, value(s.PV), s.ID, s.Name)
+			gauge(e.grid, value(s.Grid), s.ID, s.Name)
+			gauge(e.load, value(s.Load), s.ID, s.Name)
+			gauge(e.battery, value(s.Battery), s.ID, s.Name)
+			gauge(e.soc, value(s.SoC), s.ID, s.Name)
+			if s.HasStorage {
+				gauge(e.critical, value(flag(s.Critical)), s.ID, s.Name)
+			}
+		}
+		for _, p := range []struct {
+			period string
+			value  float64
+		}{{"lifetime", s.LifetimeEnergy}, {"year", s.YearEnergy}, {"month", s.MonthEnergy}, {"day", s.DayEnergy}} {
+			ch <- prometheus.MustNewConstMetric(e.energy, prometheus.CounterValue, p.value, s.ID, s.Name, p.period)
+		}
+		if !s.LastUpdate.IsZero() {
+			gauge(e.lastUpdate, float64(s.LastUpdate.Unix()), s.ID, s.Name)
+		}
+		if !s.FlowChanged.IsZero() {
+			gauge(e.flowChange, float64(s.FlowChanged.Unix()), s.ID, s.Name)
+		}
+		gauge(e.stale, flag(s.FlowStale), s.ID, s.Name, "powerflow")
+		gauge(e.stale, flag(s.OverviewStale), s.ID, s.Name, "overview")
+		gauge(e.remainingQuota, float64(s.RemainingQuota), s.ID, s.Name)
+	}
+	e.pollSuccess.Collect(ch)
+	e.pollFailure.Collect(ch)
+	e.latency.Collect(ch)
+}
+
+// NewRegistry returns a registry with the exporter and the standard go and
+// process collectors.
+func NewRegistry(e *Exporter) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return reg
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testSnapshots() []SiteSnapshot {
+	return []SiteSnapshot{
+		{
+			ID:             "1",
+			Name:           "home",
+			PV:             3000,
+			Grid:           -500,
+			Load:           1500,
+			Battery:        -1000,
+			SoC:            80,
+			HasStorage:     true,
+			LifetimeEnergy: 1e6,
+			YearEnergy:     1e5,
+			MonthEnergy:    1e4,
+			DayEnergy:      1e3,
+			LastUpdate:     time.Unix(1700000000, 0),
+			RemainingQuota: 250,
+			FlowChanged:    time.Unix(1700000100, 0),
+		},
+		{
+			ID:             "2",
+			Name:           "barn",
+			PV:             100,
+			Load:           100,
+			RemainingQuota: 10,
+			FlowStale:      true,
+		},
+	}
+}
+
+const powerHeader = `
+# HELP solaredge_pv_current_power the current power of the pv
+# TYPE solaredge_pv_current_power gauge
+`
+
+const siteMetrics = `
+# HELP solaredge_api_remaining_quota the number of API calls which are left for the current day
+# TYPE solaredge_api_remaining_quota gauge
+solaredge_api_remaining_quota{site_id="1",site_name="home"} 250
+solaredge_api_remaining_quota{site_id="2",site_name="barn"} 10
+# HELP solaredge_api_request_duration_seconds the duration of the API calls per endpoint
+# TYPE solaredge_api_request_duration_seconds histogram
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="0.1"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="0.25"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="0.5"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="1"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="2"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="5"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="10"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="30"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="overview",site_id="1",site_name="home",le="+Inf"} 1
+solaredge_api_request_duration_seconds_sum{endpoint="overview",site_id="1",site_name="home"} 1
+solaredge_api_request_duration_seconds_count{endpoint="overview",site_id="1",site_name="home"} 1
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="0.1"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="0.25"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="0.5"} 0
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="1"} 2
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="2"} 2
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="5"} 2
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="10"} 2
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="30"} 2
+solaredge_api_request_duration_seconds_bucket{endpoint="powerflow",site_id="1",site_name="home",le="+Inf"} 2
+solaredge_api_request_duration_seconds_sum{endpoint="powerflow",site_id="1",site_name="home"} 2
+solaredge_api_request_duration_seconds_count{endpoint="powerflow",site_id="1",site_name="home"} 2
+# HELP solaredge_battery_critical 1 if the battery is in a critical state
+# TYPE solaredge_battery_critical gauge
+solaredge_battery_critical{site_id="1",site_name="home"} 0
+# HELP solaredge_battery_current_power the current power of the battery
+# TYPE solaredge_battery_current_power gauge
+solaredge_battery_current_power{site_id="1",site_name="home"} -1000
+solaredge_battery_current_power{site_id="2",site_name="barn"} 0
+# HELP solaredge_energy_wh_total the produced energy of the period given by the period label
+# TYPE solaredge_energy_wh_total counter
+solaredge_energy_wh_total{period="day",site_id="1",site_name="home"} 1000
+solaredge_energy_wh_total{period="day",site_id="2",site_name="barn"} 0
+solaredge_energy_wh_total{period="lifetime",site_id="1",site_name="home"} 1e+06
+solaredge_energy_wh_total{period="lifetime",site_id="2",site_name="barn"} 0
+solaredge_energy_wh_total{period="month",site_id="1",site_name="home"} 10000
+solaredge_energy_wh_total{period="month",site_id="2",site_name="barn"} 0
+solaredge_energy_wh_total{period="year",site_id="1",site_name="home"} 100000
+solaredge_energy_wh_total{period="year",site_id="2",site_name="barn"} 0
+# HELP solaredge_grid_current_power the current power of the grid
+# TYPE solaredge_grid_current_power gauge
+solaredge_grid_current_power{site_id="1",site_name="home"} -500
+solaredge_grid_current_power{site_id="2",site_name="barn"} 0
+# HELP solaredge_last_update_timestamp_seconds the last update time of the site data
+# TYPE solaredge_last_update_timestamp_seconds gauge
+solaredge_last_update_timestamp_seconds{site_id="1",site_name="home"} 1.7e+09
+# HELP solaredge_load_current_power the current power of the load
+# TYPE solaredge_load_current_power gauge
+solaredge_load_current_power{site_id="1",site_name="home"} 1500
+solaredge_load_current_power{site_id="2",site_name="barn"} 100
+# HELP solaredge_powerflow_last_change_timestamp_seconds the last time the powerflow changed
+# TYPE solaredge_powerflow_last_change_timestamp_seconds gauge
+solaredge_powerflow_last_change_timestamp_seconds{site_id="1",site_name="home"} 1.7000001e+09
+# HELP solaredge_pv_current_power the current power of the pv
+# TYPE solaredge_pv_current_power gauge
+solaredge_pv_current_power{site_id="1",site_name="home"} 3000
+solaredge_pv_current_power{site_id="2",site_name="barn"} 100
+# HELP solaredge_soc_current_value the current state of charge of the battery
+# TYPE solaredge_soc_current_value gauge
+solaredge_soc_current_value{site_id="1",site_name="home"} 80
+solaredge_soc_current_value{site_id="2",site_name="barn"} 0
+# HELP solaredge_stale 1 if the data of the kind did not change for too long
+# TYPE solaredge_stale gauge
+solaredge_stale{kind="overview",site_id="1",site_name="home"} 0
+solaredge_stale{kind="overview",site_id="2",site_name="barn"} 0
+solaredge_stale{kind="powerflow",site_id="1",site_name="home"} 0
+solaredge_stale{kind="powerflow",site_id="2",site_name="barn"} 1
+# HELP solaredge_poll_success_total the number of successful API calls per endpoint
+# TYPE solaredge_poll_success_total counter
+solaredge_poll_success_total{endpoint="powerflow",site_id="1",site_name="home"} 2
+# HELP solaredge_poll_failure_total the number of failed API calls per endpoint
+# TYPE solaredge_poll_failure_total counter
+solaredge_poll_failure_total{endpoint="overview",site_id="1",site_name="home"} 1
+`
+
+func TestExporter(t *testing.T) {
+	e := NewExporter(testSnapshots)
+	e.ObservePoll("1", "home", "powerflow", time.Second, nil)
+	e.ObservePoll("1", "home", "powerflow", time.Second, nil)
+	e.ObservePoll("1", "home", "overview", time.Second, errors.New("failed"))
+	if err := testutil.CollectAndCompare(e, strings.NewReader(siteMetrics)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporterStale(t *testing.T) {
+	for _, tc := range []struct {
+		mode StaleMode
+		want string
+	}{
+		{KeepStale, `solaredge_pv_current_power{site_id="1",site_name="home"} 3000
+solaredge_pv_current_power{site_id="2",site_name="barn"} 100
+`},
+		{NaNStale, `solaredge_pv_current_power{site_id="1",site_name="home"} 3000
+solaredge_pv_current_power{site_id="2",site_name="barn"} NaN
+`},
+		{BlankStale, `solaredge_pv_current_power{site_id="1",site_name="home"} 3000
+`},
+	} {
+		e := NewExporter(testSnapshots, WithStaleMode(tc.mode))
+		if err := testutil.CollectAndCompare(e, strings.NewReader(powerHeader+tc.want), "solaredge_pv_current_power"); err != nil {
+			t.Errorf("%s: %v", tc.mode, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+)
+
+// Client reads the registers of a device with Modbus TCP. The connection is
+// opened on the first read and again after a failed read.
+type Client struct {
+	lock        sync.Mutex
+	addr        string
+	timeout     time.Duration
+	conn        net.Conn
+	transaction uint16
+}
+
+// Opt is an option type for the Client.
+type Opt func(c *Client)
+
+// WithTimeout sets the timeout of the connect and of every read.
+func WithTimeout(d time.Duration) Opt {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// NewClient returns a client for the device at addr, which is host:port.
+func NewClient(addr string, opts ...Opt) *Client {
+	res := &Client{addr: addr, timeout: defaultTimeout}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// Close closes the connection to the device.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.disconnect()
+}
+
+func (c *Client) disconnect() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// ReadHoldingRegisters reads count registers starting at addr from the unit.
+func (c *Client) ReadHoldingRegisters(unit byte, addr, count uint16) ([]uint16, error) {
+	if count == 0 || count > MaxRegisters {
+		return nil, fmt.Errorf("cannot read %d registers, at most %d", count, MaxRegisters)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pdu := make([]byte, 5)
+	pdu[0] = FuncReadHoldingRegisters
+	binary.BigEndian.PutUint16(pdu[1:], addr)
+	binary.BigEndian.PutUint16(pdu[3:], count)
+	rsp, err := c.call(unit, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp) < 2 || int(rsp[1]) != 2*int(count) || len(rsp) != 2+2*int(count) {
+		return nil, fmt.Errorf("cannot read registers from %s: invalid answer length %d", c.addr, len(rsp))
+	}
+	res := make([]uint16, count)
+	for i := range res {
+		res[i] = binary.BigEndian.Uint16(rsp[2+2*i:])
+	}
+	return res, nil
+}
+
+// call sends the request and returns the answer, exceptions are returned as
+// error. The connection is closed on all other errors, as the next answer
+// could belong to the failed request.
+func (c *Client) call(unit byte, pdu []byte) ([]byte, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to %s: %w", c.addr, err)
+		}
+		c.conn = conn
+	}
+	c.transaction++
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot set deadline: %w", err)
+	}
+	if err := writeFrame(c.conn, &frame{transaction: c.transaction, unit: unit, pdu: pdu}); err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot send request to %s: %w", c.addr, err)
+	}
+	rsp, err := readFrame(c.conn)
+	if err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot read answer from %s: %w", c.addr, err)
+	}
+	if rsp.transaction != c.transaction || rsp.unit != unit {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot read answer from %s: unexpected transaction %d of unit %d", c.addr, rsp.transaction, rsp.unit)
+	}
+	if rsp.pdu[0] == pdu[0]|0x80 {
+		if len(rsp.pdu) < 2 {
+			c.disconnect()
+			return nil, fmt.Errorf("cannot read answer from %s: short exception", c.addr)
+		}
+		return nil, &Exception{Function: pdu[0], Code: rsp.pdu[1]}
+	}
+	if rsp.pdu[0] != pdu[0] {
+		c.disconnect()
+		return nil, fmt.Errorf("cannot read answer from %s: unexpected function 0x%02x", c.addr, rsp.pdu[0])
+	}
+	return rsp.pdu, nil
+}
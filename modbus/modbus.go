@@ -0,0 +1,105 @@
+// Package modbus reads and serves holding registers with Modbus TCP. Only the
+// function read holding registers is supported, which is all that is needed to
+// read SunSpec devices.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// FuncReadHoldingRegisters is the function code to read holding registers.
+	FuncReadHoldingRegisters = 0x03
+	// MaxRegisters is the maximum number of registers of a single read.
+	MaxRegisters = 125
+
+	// the MBAP header is the transaction id, the protocol id, the length of the
+	// rest of the frame and the unit id
+	headerSize = 7
+	// the longest PDU is the answer of a read with MaxRegisters
+	maxPDUSize = 2 + 2*MaxRegisters
+)
+
+// The exception codes of a device.
+const (
+	IllegalFunction        = 0x01
+	IllegalDataAddress     = 0x02
+	IllegalDataValue       = 0x03
+	ServerDeviceFailure    = 0x04
+	GatewayPathUnavailable = 0x0A
+	GatewayTargetFailed    = 0x0B
+)
+
+// Exception is the error answer of a device.
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	switch e.Code {
+	case IllegalFunction:
+		return fmt.Sprintf("modbus function 0x%02x: illegal function", e.Function)
+	case IllegalDataAddress:
+		return fmt.Sprintf("modbus function 0x%02x: illegal data address", e.Function)
+	case IllegalDataValue:
+		return fmt.Sprintf("modbus function 0x%02x: illegal data value", e.Function)
+	case ServerDeviceFailure:
+		return fmt.Sprintf("modbus function 0x%02x: server device failure", e.Function)
+	case GatewayPathUnavailable:
+		return fmt.Sprintf("modbus function 0x%02x: gateway path unavailable", e.Function)
+	case GatewayTargetFailed:
+		return fmt.Sprintf("modbus function 0x%02x: gateway target device failed to respond", e.Function)
+	}
+	return fmt.Sprintf("modbus function 0x%02x: exception 0x%02x", e.Function, e.Code)
+}
+
+// IsException tests if the error is an exception with the code.
+func IsException(err error, code byte) bool {
+	var e *Exception
+	return errors.As(err, &e) && e.Code == code
+}
+
+// frame is a Modbus TCP frame.
+type frame struct {
+	transaction uint16
+	unit        byte
+	pdu         []byte
+}
+
+func readFrame(r io.Reader) (*frame, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if p := binary.BigEndian.Uint16(hdr[2:]); p != 0 {
+		return nil, fmt.Errorf("unknown protocol id %d", p)
+	}
+	// the length contains the unit id
+	l := int(binary.BigEndian.Uint16(hdr[4:]))
+	if l < 2 || l > maxPDUSize+1 {
+		return nil, fmt.Errorf("invalid frame length %d", l)
+	}
+	res := &frame{
+		transaction: binary.BigEndian.Uint16(hdr[0:]),
+		unit:        hdr[6],
+		pdu:         make([]byte, l-1),
+	}
+	if _, err := io.ReadFull(r, res.pdu); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func writeFrame(w io.Writer, f *frame) error {
+	buf := make([]byte, headerSize+len(f.pdu))
+	binary.BigEndian.PutUint16(buf[0:], f.transaction)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(f.pdu)+1))
+	buf[6] = f.unit
+	copy(buf[headerSize:], f.pdu)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,212 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// A Handler answers the reads of holding registers. Errors which are not an
+// Exception are answered as server device failure.
+type Handler interface {
+	ReadHoldingRegisters(unit byte, addr, count uint16) ([]uint16, error)
+}
+
+// HandlerFunc is a function which is a Handler.
+type HandlerFunc func(unit byte, addr, count uint16) ([]uint16, error)
+
+// ReadHoldingRegisters calls f.
+func (f HandlerFunc) ReadHoldingRegisters(unit byte, addr, count uint16) ([]uint16, error) {
+	return f(unit, addr, count)
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// Server answers Modbus TCP requests with a Handler.
+type Server struct {
+	handler   Handler
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a server which answers all units with the handler.
+func NewServer(h Handler) *Server {
+	return &Server{
+		handler:   h,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves the connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections of the listener until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("cannot accept connection: %w", err)
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops all listeners and closes all connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+	for {
+		// clients simply close the connection when they are done, invalid
+		// frames cannot be answered
+		rq, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		rsp := &frame{transaction: rq.transaction, unit: rq.unit, pdu: s.answer(rq)}
+		if err := writeFrame(conn, rsp); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the response PDU of the request.
+func (s *Server) answer(rq *frame) []byte {
+	fn := rq.pdu[0]
+	if fn != FuncReadHoldingRegisters {
+		return []byte{fn | 0x80, IllegalFunction}
+	}
+	if len(rq.pdu) != 5 {
+		return []byte{fn | 0x80, IllegalDataValue}
+	}
+	addr := binary.BigEndian.Uint16(rq.pdu[1:])
+	count := binary.BigEndian.Uint16(rq.pdu[3:])
+	if count == 0 || count > MaxRegisters {
+		return []byte{fn | 0x80, IllegalDataValue}
+	}
+	regs, err := s.handler.ReadHoldingRegisters(rq.unit, addr, count)
+	if err != nil {
+		var e *Exception
+		if errors.As(err, &e) {
+			return []byte{fn | 0x80, e.Code}
+		}
+		return []byte{fn | 0x80, ServerDeviceFailure}
+	}
+	if len(regs) != int(count) {
+		return []byte{fn | 0x80, ServerDeviceFailure}
+	}
+	res := make([]byte, 2+2*len(regs))
+	res[0] = fn
+	res[1] = byte(2 * len(regs))
+	for i, r := range regs {
+		binary.BigEndian.PutUint16(res[2+2*i:], r)
+	}
+	return res
+}
+
+// Registers is a block of holding registers which is safe for concurrent use.
+// It answers the reads of every unit.
+type Registers struct {
+	lock   sync.RWMutex
+	base   uint16
+	values []uint16
+}
+
+// NewRegisters returns a block of count registers starting at base.
+func NewRegisters(base uint16, count int) *Registers {
+	return &Registers{base: base, values: make([]uint16, count)}
+}
+
+// Set writes the values starting at addr. Values outside of the block are
+// dropped.
+func (r *Registers) Set(addr uint16, values []uint16) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.set(addr, values)
+}
+
+// Update calls f with a function which writes values like Set. All writes of f
+// happen under one lock, so a read sees either none or all of them.
+func (r *Registers) Update(f func(set func(addr uint16, values []uint16))) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	f(r.set)
+}
+
+func (r *Registers) set(addr uint16, values []uint16) {
+	for i, v := range values {
+		a := int(addr) + i - int(r.base)
+		if a >= 0 && a < len(r.values) {
+			r.values[a] = v
+		}
+	}
+}
+
+// ReadHoldingRegisters returns the registers, reads outside of the block are an
+// illegal data address.
+func (r *Registers) ReadHoldingRegisters(unit byte, addr, count uint16) ([]uint16, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	start := int(addr) - int(r.base)
+	if start < 0 || start+int(count) > len(r.values) {
+		return nil, &Exception{Function: FuncReadHoldingRegisters, Code: IllegalDataAddress}
+	}
+	res := make([]uint16, count)
+	copy(res, r.values[start:])
+	return res, nil
+}
@@ -0,0 +1,351 @@
+// Package mqtt publishes the data of solaredge sites to a MQTT broker. The
+// publisher announces the values with the MQTT discovery of Home Assistant, so
+// the entities appear automatically, and maintains an availability topic with
+// a last will.
+package mqtt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// DefaultTopic is the topic template of the states.
+	DefaultTopic = "solaredge/{site}/{kind}"
+	// DefaultAvailabilityTopic is the topic of the availability of the publisher.
+	DefaultAvailabilityTopic = "solaredge/status"
+	// DefaultDiscoveryPrefix is the discovery prefix of Home Assistant.
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	online  = "online"
+	offline = "offline"
+	timeout = 10 * time.Second
+)
+
+// Kind is the kind of a published state.
+type Kind string
+
+var (
+	KindFlow     Kind = "flow"
+	KindOverview Kind = "overview"
+	KindBattery  Kind = "battery"
+)
+
+// A Site identifies the device of the published states.
+type Site struct {
+	ID   string
+	Name string
+}
+
+// FlowState is the current power flow of a site in W.
+type FlowState struct {
+	PV      float64 `json:"pv"`
+	Grid    float64 `json:"grid"`
+	Load    float64 `json:"load"`
+	Battery float64 `json:"battery"`
+	SoC     float64 `json:"soc"`
+}
+
+// OverviewState contains the produced energy of a site in Wh.
+type OverviewState struct {
+	CurrentPower   float64   `json:"current_power"`
+	LifetimeEnergy float64   `json:"lifetime_energy"`
+	YearEnergy     float64   `json:"year_energy"`
+	MonthEnergy    float64   `json:"month_energy"`
+	DayEnergy      float64   `json:"day_energy"`
+	LastUpdate     time.Time `json:"last_update"`
+}
+
+// BatteryState is the current state of the battery of a site.
+type BatteryState struct {
+	SoC      float64 `json:"soc"`
+	Power    float64 `json:"power"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+}
+
+// A sensor is an entity of Home Assistant which shows a value of a state.
+type sensor struct {
+	key         string
+	name        string
+	deviceClass string
+	unit        string
+	stateClass  string
+	binary      bool
+}
+
+var sensors = map[Kind][]sensor{
+	KindFlow: {
+		{key: "pv", name: "PV power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+		{key: "grid", name: "Grid power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+		{key: "load", name: "Load power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+		{key: "battery", name: "Battery power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+	},
+	KindOverview: {
+		{key: "current_power", name: "Current power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+		{key: "lifetime_energy", name: "Lifetime energy", deviceClass: "energy", unit: "Wh", stateClass: "total_increasing"},
+		{key: "year_energy", name: "Energy this year", deviceClass: "energy", unit: "Wh", stateClass: "total_increasing"},
+		{key: "month_energy", name: "Energy this month", deviceClass: "energy", unit: "Wh", stateClass: "total_increasing"},
+		{key: "day_energy", name: "Energy today", deviceClass: "energy", unit: "Wh", stateClass: "total_increasing"},
+		{key: "last_update", name: "Last update", deviceClass: "timestamp"},
+	},
+	KindBattery: {
+		{key: "soc", name: "Battery level", deviceClass: "battery", unit: "%", stateClass: "measurement"},
+		{key: "status", name: "Battery status"},
+		{key: "critical", name: "Battery critical", deviceClass: "problem", binary: true},
+	},
+}
+
+// Opt is an option type for the Publisher.
+type Opt func(p *Publisher)
+
+// WithCredentials sets the username and password for the broker.
+func WithCredentials(username, password string) Opt {
+	return func(p *Publisher) {
+		p.opts.SetUsername(username)
+		p.opts.SetPassword(password)
+	}
+}
+
+// WithClientID sets the client ID of the connection, the default is
+// DefaultClientID.
+func WithClientID(id string) Opt {
+	return func(p *Publisher) {
+		p.opts.SetClientID(id)
+	}
+}
+
+// WithTopic sets the template of the state topics. The placeholders {site}
+// and {kind} are replaced with the site ID and the kind of the state.
+func WithTopic(tmpl string) Opt {
+	return func(p *Publisher) {
+		p.topic = tmpl
+	}
+}
+
+// WithAvailabilityTopic sets the topic which contains "online" while the
+// publisher is connected and "offline" otherwise.
+func WithAvailabilityTopic(topic string) Opt {
+	return func(p *Publisher) {
+		p.availability = topic
+	}
+}
+
+// WithDiscoveryPrefix sets the discovery prefix of Home Assistant. An empty
+// prefix disables the discovery.
+func WithDiscoveryPrefix(prefix string) Opt {
+	return func(p *Publisher) {
+		p.discovery = prefix
+	}
+}
+
+// WithQoS sets the quality of service of all messages.
+func WithQoS(qos byte) Opt {
+	return func(p *Publisher) {
+		p.qos = qos
+	}
+}
+
+// A Publisher publishes the states of sites to a MQTT broker.
+type Publisher struct {
+	lock         sync.Mutex
+	opts         *paho.ClientOptions
+	client       paho.Client
+	topic        string
+	availability string
+	discovery    string
+	qos          byte
+	// the kinds of the sites which were published, they are announced again
+	// after a reconnect or a restart of Home Assistant
+	announced map[Site]map[Kind]bool
+}
+
+// DefaultClientID returns a client ID with the host name and the process ID,
+// so two publishers on the same broker do not take over each others session.
+// Without a host name the ID is random.
+func DefaultClientID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return fmt.Sprintf("solaredge-%s-%d", objectID(host), os.Getpid())
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "solaredge-" + hex.EncodeToString(b)
+}
+
+// New connects to the broker, e.g. "tcp://localhost:1883", and returns a
+// publisher. The connection is reestablished automatically.
+func New(broker string, opts ...Opt) (*Publisher, error) {
+	res := newPublisher(broker, opts...)
+	res.client = paho.NewClient(res.opts)
+	if err := wait(res.client.Connect()); err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", broker, err)
+	}
+	return res, nil
+}
+
+// newPublisher returns a publisher without a client.
+func newPublisher(broker string, opts ...Opt) *Publisher {
+	res := &Publisher{
+		opts:         paho.NewClientOptions().AddBroker(broker).SetClientID(DefaultClientID()).SetAutoReconnect(true),
+		topic:        DefaultTopic,
+		availability: DefaultAvailabilityTopic,
+		discovery:    DefaultDiscoveryPrefix,
+		qos:          1,
+		announced:    make(map[Site]map[Kind]bool),
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	res.opts.SetWill(res.availability, offline, res.qos, true)
+	res.opts.SetOnConnectHandler(res.onConnect)
+	return res
+}
+
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(timeout) {
+		return fmt.Errorf("timeout after %s", timeout)
+	}
+	return t.Error()
+}
+
+// onConnect is called after every (re)connect.
+func (p *Publisher) onConnect(c paho.Client) {
+	c.Publish(p.availability, p.qos, true, online)
+	if p.discovery == "" {
+		return
+	}
+	// Home Assistant forgets the entities when it restarts without a
+	// persistent broker, so it is announced again when it comes online
+	c.Subscribe(p.discovery+"/status", p.qos, func(c paho.Client, m paho.Message) {
+		if string(m.Payload()) == online {
+			go p.announceAll()
+		}
+	})
+	go p.announceAll()
+}
+
+func (p *Publisher) announceAll() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for site, kinds := range p.announced {
+		for k := range kinds {
+			_ = p.announce(site, k)
+		}
+	}
+}
+
+// Topic returns the state topic of the kind of the site.
+func (p *Publisher) Topic(siteID string, k Kind) string {
+	return strings.NewReplacer("{site}", siteID, "{kind}", string(k)).Replace(p.topic)
+}
+
+// objectID returns a valid object or node ID for the discovery.
+func objectID(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// announce publishes the discovery configs of the sensors of the kind.
+func (p *Publisher) announce(site Site, k Kind) error {
+	node := "solaredge_" + objectID(site.ID)
+	name := site.Name
+	if name == "" {
+		name = "SolarEdge " + site.ID
+	}
+	device := map[string]any{
+		"identifiers":  []string{node},
+		"name":         name,
+		"manufacturer": "SolarEdge",
+	}
+	for _, s := range sensors[k] {
+		cfg := map[string]any{
+			"name":               s.name,
+			"unique_id":          node + "_" + s.key,
+			"object_id":          node + "_" + s.key,
+			"state_topic":        p.Topic(site.ID, k),
+			"availability_topic": p.availability,
+			"device":             device,
+		}
+		component := "sensor"
+		if s.binary {
+			component = "binary_sensor"
+			cfg["value_template"] = fmt.Sprintf("{{ 'ON' if value_json.%s else 'OFF' }}", s.key)
+		} else {
+			cfg["value_template"] = fmt.Sprintf("{{ value_json.%s }}", s.key)
+		}
+		if s.deviceClass != "" {
+			cfg["device_class"] = s.deviceClass
+		}
+		if s.unit != "" {
+			cfg["unit_of_measurement"] = s.unit
+		}
+		if s.stateClass != "" {
+			cfg["state_class"] = s.stateClass
+		}
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return fmt.Errorf("cannot marshal discovery config: %w", err)
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.discovery, component, node, s.key)
+		if err := wait(p.client.Publish(topic, p.qos, true, data)); err != nil {
+			return fmt.Errorf("cannot publish discovery config %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) publish(site Site, k Kind, state any) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != "" && !p.announced[site][k] {
+		if err := p.announce(site, k); err != nil {
+			return err
+		}
+	}
+	if p.announced[site] == nil {
+		p.announced[site] = make(map[Kind]bool)
+	}
+	p.announced[site][k] = true
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s state: %w", k, err)
+	}
+	if err := wait(p.client.Publish(p.Topic(site.ID, k), p.qos, true, data)); err != nil {
+		return fmt.Errorf("cannot publish %s state of site %s: %w", k, site.ID, err)
+	}
+	return nil
+}
+
+// PublishFlow publishes the power flow of the site.
+func (p *Publisher) PublishFlow(site Site, state FlowState) error {
+	return p.publish(site, KindFlow, state)
+}
+
+// PublishOverview publishes the overview of the site.
+func (p *Publisher) PublishOverview(site Site, state OverviewState) error {
+	return p.publish(site, KindOverview, state)
+}
+
+// PublishBattery publishes the battery state of the site.
+func (p *Publisher) PublishBattery(site Site, state BatteryState) error {
+	return p.publish(site, KindBattery, state)
+}
+
+// Close marks the publisher as offline and disconnects from the broker.
+func (p *Publisher) Close() {
+	_ = wait(p.client.Publish(p.availability, p.qos, true, offline))
+	p.client.Disconnect(250)
+}
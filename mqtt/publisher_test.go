@@ -0,0 +1,259 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  string
+}
+
+// token is a finished paho.Token.
+type token struct {
+	err error
+}
+
+func (t token) Wait() bool                       { return true }
+func (t token) WaitTimeout(d time.Duration) bool { return true }
+func (t token) Error() error                     { return t.err }
+func (t token) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// fakeClient records the published messages and subscriptions instead of
+// talking to a broker.
+type fakeClient struct {
+	paho.Client
+	lock sync.Mutex
+	msgs []message
+	subs []string
+	fail error
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload any) paho.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var data string
+	switch p := payload.(type) {
+	case string:
+		data = p
+	case []byte:
+		data = string(p)
+	}
+	c.msgs = append(c.msgs, message{topic: topic, qos: qos, retained: retained, payload: data})
+	return token{err: c.fail}
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, cb paho.MessageHandler) paho.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.subs = append(c.subs, topic)
+	return token{}
+}
+
+// take returns the recorded messages and forgets them.
+func (c *fakeClient) take() []message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := c.msgs
+	c.msgs = nil
+	return res
+}
+
+func testPublisher(opts ...Opt) (*Publisher, *fakeClient) {
+	c := &fakeClient{}
+	p := newPublisher("tcp://localhost:1883", opts...)
+	p.client = c
+	return p, c
+}
+
+func topics(msgs []message) []string {
+	var res []string
+	for _, m := range msgs {
+		res = append(res, m.topic)
+	}
+	return res
+}
+
+func TestDefaultClientID(t *testing.T) {
+	id := DefaultClientID()
+	if !strings.HasPrefix(id, "solaredge-") || !strings.HasSuffix(id, fmt.Sprintf("-%d", os.Getpid())) {
+		t.Errorf("client ID is %q", id)
+	}
+	if objectID(id) != id {
+		t.Errorf("client ID %q contains invalid characters", id)
+	}
+	if p, _ := testPublisher(); p.opts.ClientID != id {
+		t.Errorf("publisher has client ID %q, want %q", p.opts.ClientID, id)
+	}
+	if p, _ := testPublisher(WithClientID("fixed")); p.opts.ClientID != "fixed" {
+		t.Errorf("publisher has client ID %q, want fixed", p.opts.ClientID)
+	}
+}
+
+func TestTopic(t *testing.T) {
+	p, _ := testPublisher()
+	if got := p.Topic("12345", KindFlow); got != "solaredge/12345/flow" {
+		t.Errorf("default topic is %q", got)
+	}
+	p, _ = testPublisher(WithTopic("home/{kind}/pv-{site}"))
+	if got := p.Topic("12345", KindOverview); got != "home/overview/pv-12345" {
+		t.Errorf("topic is %q", got)
+	}
+	if !p.opts.WillEnabled || p.opts.WillTopic != DefaultAvailabilityTopic || string(p.opts.WillPayload) != offline || !p.opts.WillRetained {
+		t.Errorf("unexpected last will %q %q", p.opts.WillTopic, p.opts.WillPayload)
+	}
+}
+
+func TestPublishDiscovery(t *testing.T) {
+	p, c := testPublisher()
+	site := Site{ID: "1", Name: "home"}
+	if err := p.PublishFlow(site, FlowState{PV: 3000, Grid: -500, Load: 1500, Battery: -1000, SoC: 80}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := c.take()
+	want := []string{
+		"homeassistant/sensor/solaredge_1/pv/config",
+		"homeassistant/sensor/solaredge_1/grid/config",
+		"homeassistant/sensor/solaredge_1/load/config",
+		"homeassistant/sensor/solaredge_1/battery/config",
+		"solaredge/1/flow",
+	}
+	if got := topics(msgs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	for _, m := range msgs {
+		if m.qos != 1 || !m.retained {
+			t.Errorf("%s is published with qos %d, retained %v", m.topic, m.qos, m.retained)
+		}
+	}
+	var cfg map[string]any
+	if err := json.Unmarshal([]byte(msgs[0].payload), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	wantCfg := map[string]any{
+		"name":                "PV power",
+		"unique_id":           "solaredge_1_pv",
+		"object_id":           "solaredge_1_pv",
+		"state_topic":         "solaredge/1/flow",
+		"availability_topic":  "solaredge/status",
+		"value_template":      "{{ value_json.pv }}",
+		"device_class":        "power",
+		"unit_of_measurement": "W",
+		"state_class":         "measurement",
+		"device": map[string]any{
+			"identifiers":  []any{"solaredge_1"},
+			"name":         "home",
+			"manufacturer": "SolarEdge",
+		},
+	}
+	if !reflect.DeepEqual(cfg, wantCfg) {
+		t.Errorf("discovery config is %v, want %v", cfg, wantCfg)
+	}
+	if want := `{"pv":3000,"grid":-500,"load":1500,"battery":-1000,"soc":80}`; msgs[4].payload != want {
+		t.Errorf("state is %s, want %s", msgs[4].payload, want)
+	}
+
+	// the configs are only sent once
+	if err := p.PublishFlow(site, FlowState{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := topics(c.take()); !reflect.DeepEqual(got, []string{"solaredge/1/flow"}) {
+		t.Errorf("second publish sent %v", got)
+	}
+
+	// the critical flag of the battery is a binary sensor, a site without a
+	// name gets a default device name
+	if err := p.PublishBattery(Site{ID: "2"}, BatteryState{SoC: 5, Status: "Idle", Critical: true}); err != nil {
+		t.Fatal(err)
+	}
+	msgs = c.take()
+	want = []string{
+		"homeassistant/sensor/solaredge_2/soc/config",
+		"homeassistant/sensor/solaredge_2/status/config",
+		"homeassistant/binary_sensor/solaredge_2/critical/config",
+		"solaredge/2/battery",
+	}
+	if got := topics(msgs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	cfg = nil
+	if err := json.Unmarshal([]byte(msgs[2].payload), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg["value_template"] != "{{ 'ON' if value_json.critical else 'OFF' }}" || cfg["device_class"] != "problem" || cfg["device"].(map[string]any)["name"] != "SolarEdge 2" {
+		t.Errorf("unexpected binary sensor config %v", cfg)
+	}
+	if _, ok := cfg["unit_of_measurement"]; ok {
+		t.Errorf("binary sensor has a unit: %v", cfg)
+	}
+
+	// after a reconnect all published kinds are announced again
+	p.announceAll()
+	if n := len(c.take()); n != 4+3 {
+		t.Errorf("announced %d configs again, want 7", n)
+	}
+}
+
+func TestPublishOptions(t *testing.T) {
+	// without discovery only the states are published, the IDs of the nodes
+	// contain only valid characters
+	p, c := testPublisher(WithDiscoveryPrefix(""), WithTopic("pv/{site}/{kind}"), WithQoS(0))
+	if err := p.PublishOverview(Site{ID: "a/b"}, OverviewState{DayEnergy: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := c.take()
+	if got := topics(msgs); !reflect.DeepEqual(got, []string{"pv/a/b/overview"}) || msgs[0].qos != 0 {
+		t.Errorf("published %v", msgs)
+	}
+
+	p, c = testPublisher(WithDiscoveryPrefix("ha"))
+	if err := p.PublishOverview(Site{ID: "a/b"}, OverviewState{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := topics(c.take()); len(got) != 7 || got[0] != "ha/sensor/solaredge_a_b/current_power/config" || got[5] != "ha/sensor/solaredge_a_b/last_update/config" {
+		t.Errorf("published %v", got)
+	}
+
+	// a failed announcement is repeated with the next state
+	p, c = testPublisher()
+	c.fail = errors.New("broker down")
+	if err := p.PublishFlow(Site{ID: "1"}, FlowState{}); err == nil {
+		t.Error("publish did not fail")
+	}
+	c.take()
+	c.fail = nil
+	if err := p.PublishFlow(Site{ID: "1"}, FlowState{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.take()); n != 5 {
+		t.Errorf("published %d messages after a failure, want 5", n)
+	}
+}
+
+func TestOnConnect(t *testing.T) {
+	p, c := testPublisher()
+	p.onConnect(c)
+	msgs := c.take()
+	if len(msgs) == 0 || msgs[0] != (message{topic: "solaredge/status", qos: 1, retained: true, payload: online}) {
+		t.Errorf("published %v on connect", msgs)
+	}
+	if !reflect.DeepEqual(c.subs, []string{"homeassistant/status"}) {
+		t.Errorf("subscribed %v", c.subs)
+	}
+}
@@ -0,0 +1,94 @@
+package solaredge
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DailyQuota is the number of API calls solaredge allows per site and day.
+	DailyQuota = 300
+)
+
+// quotaCounter counts the calls per site of the current day. Calls which are
+// not related to a site are counted for the empty site-ID.
+type quotaCounter struct {
+	lock  sync.Mutex
+	day   string
+	calls map[string]int
+}
+
+func today() string {
+	return day(time.Now())
+}
+
+func day(t time.Time) string {
+	loc, err := time.LoadLocation(SiteZone)
+	if err != nil {
+		loc = time.Local
+	}
+	return t.In(loc).Format(datePattern)
+}
+
+func (q *quotaCounter) count(siteid string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if d := today(); d != q.day || q.calls == nil {
+		q.day = d
+		q.calls = make(map[string]int)
+	}
+	q.calls[siteid]++
+}
+
+func (q *quotaCounter) add(siteid string, at time.Time, n int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	d := today()
+	if day(at) != d {
+		return
+	}
+	if d != q.day || q.calls == nil {
+		q.day = d
+		q.calls = make(map[string]int)
+	}
+	q.calls[siteid] += n
+}
+
+func (q *quotaCounter) used(siteid string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if today() != q.day {
+		return 0
+	}
+	return q.calls[siteid]
+}
+
+// CallsToday returns the number of API calls this client made for the site on
+// the current day in the zone of the site.
+func (sec *SEClient) CallsToday(siteid string) int {
+	return sec.quota.used(siteid)
+}
+
+// AddCalls counts n calls for the site which were made at the given time, e.g.
+// by an earlier run of the program. Calls of other days are ignored.
+func (sec *SEClient) AddCalls(siteid string, at time.Time, n int) {
+	sec.quota.add(siteid, at, n)
+}
+
+// RemainingQuota returns the number of API calls which are left for the site on
+// the current day. Only the calls of this client are known, so the value is too
+// high if other clients use the same site.
+func (sc *SiteClient) RemainingQuota() int {
+	res := DailyQuota - sc.CallsToday(sc.siteid)
+	if res < 0 {
+		return 0
+	}
+	return res
+}
+
+// get counts the call for the site and invokes the API.
+func (sc *SiteClient) get(path string, parms url.Values, target any) error {
+	sc.quota.count(sc.siteid)
+	return sc.SEClient.get(path, parms, target)
+}
@@ -0,0 +1,256 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Endpoint is an API call which is polled regularly. The data of the endpoint
+// is never older than Freshness if the budget allows it, and the endpoint is
+// never polled more often than every MinInterval. The calls which are left after
+// all endpoints are fresh enough are shared by priority during daylight.
+type Endpoint struct {
+	Name        string        `json:"name"`
+	Priority    int           `json:"priority"`
+	Freshness   time.Duration `json:"freshness"`
+	MinInterval time.Duration `json:"minInterval"`
+}
+
+// ParseEndpoint parses an endpoint in the form "name=priority,freshness".
+func ParseEndpoint(s string) (Endpoint, error) {
+	name, spec, ok := strings.Cut(s, "=")
+	prio, fresh, ok2 := strings.Cut(spec, ",")
+	if !ok || !ok2 {
+		return Endpoint{}, fmt.Errorf("endpoint %q is not in the form name=priority,freshness", s)
+	}
+	res := Endpoint{Name: strings.TrimSpace(name)}
+	var err error
+	if res.Priority, err = strconv.Atoi(strings.TrimSpace(prio)); err != nil || res.Priority < 0 {
+		return Endpoint{}, fmt.Errorf("cannot parse priority %q of endpoint %q", prio, res.Name)
+	}
+	if res.Freshness, err = time.ParseDuration(strings.TrimSpace(fresh)); err != nil || res.Freshness <= 0 {
+		return Endpoint{}, fmt.Errorf("cannot parse freshness %q of endpoint %q", fresh, res.Name)
+	}
+	return res, nil
+}
+
+// An EndpointPlan contains the poll intervals of an endpoint for the rest of
+// the day.
+type EndpointPlan struct {
+	Endpoint
+	DayInterval   time.Duration `json:"dayInterval"`
+	NightInterval time.Duration `json:"nightInterval"`
+	Calls         int           `json:"calls"`
+}
+
+// MarshalJSON writes the durations in a readable form.
+func (e EndpointPlan) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name          string `json:"name"`
+		Priority      int    `json:"priority"`
+		Freshness     string `json:"freshness"`
+		MinInterval   string `json:"minInterval"`
+		DayInterval   string `json:"dayInterval"`
+		NightInterval string `json:"nightInterval"`
+		Calls         int    `json:"calls"`
+	}{e.Name, e.Priority, e.Freshness.String(), e.MinInterval.String(), e.DayInterval.String(), e.NightInterval.String(), e.Calls})
+}
+
+// A Plan distributes the remaining calls of a day to the endpoints.
+type Plan struct {
+	Created       time.Time      `json:"created"`
+	End           time.Time      `json:"end"`
+	DaylightStart time.Time      `json:"daylightStart"`
+	DaylightEnd   time.Time      `json:"daylightEnd"`
+	Budget        int            `json:"budget"`
+	Used          int            `json:"used"`
+	Planned       int            `json:"planned"`
+	Feasible      bool           `json:"feasible"`
+	Endpoints     []EndpointPlan `json:"endpoints"`
+}
+
+// A Planner computes plans which use a daily budget of calls.
+type Planner struct {
+	sched     *Scheduler
+	budget    int
+	endpoints []Endpoint
+}
+
+// NewPlanner returns a planner which distributes budget calls per day to the
+// endpoints. The daylight is taken from the scheduler.
+func NewPlanner(sched *Scheduler, budget int, endpoints []Endpoint) *Planner {
+	return &Planner{sched: sched, budget: budget, endpoints: endpoints}
+}
+
+// calls returns the number of polls with the interval which fit into d.
+func calls(d, interval time.Duration) int {
+	if d <= 0 || interval <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(d) / float64(interval)))
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// apportion splits n calls in proportion to the needed calls of the endpoints.
+// The calls which are left after rounding down go to the largest remainders,
+// on a tie to the higher priority. The sum of the shares is never more than n.
+func apportion(n int, needs []int, endpoints []Endpoint) []int {
+	total := 0
+	for _, need := range needs {
+		total += need
+	}
+	res := make([]int, len(needs))
+	if total == 0 {
+		return res
+	}
+	left := n
+	order := make([]int, len(needs))
+	for i, need := range needs {
+		res[i] = n * need / total
+		left -= res[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		ri, rj := n*needs[order[i]]%total, n*needs[order[j]]%total
+		if ri != rj {
+			return ri > rj
+		}
+		return endpoints[order[i]].Priority > endpoints[order[j]].Priority
+	})
+	for _, i := range order[:left] {
+		res[i]++
+	}
+	return res
+}
+
+// Plan computes the plan for the rest of the day of now when used calls of the
+// budget are already spent.
+func (p *Planner) Plan(now time.Time, used int) *Plan {
+	now = now.In(p.sched.zone)
+	y, m, d := now.Date()
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, p.sched.zone)
+	daylightStart, daylightEnd := p.sched.Daylight(now)
+	res := &Plan{
+		Created:       now,
+		End:           end,
+		DaylightStart: daylightStart,
+		DaylightEnd:   daylightEnd,
+		Budget:        p.budget,
+		Used:          used,
+		Feasible:      true,
+	}
+	remaining := p.budget - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	// the remaining day is split into the night before the daylight, the
+	// remaining daylight and the night after it
+	start, stop := daylightStart, daylightEnd
+	if start.Before(now) {
+		start = now
+	}
+	if stop.Before(start) {
+		stop = start
+	}
+	if start.After(end) {
+		start, stop = end, end
+	}
+	before, day, after := start.Sub(now), stop.Sub(start), end.Sub(stop)
+	nightCalls := func(interval time.Duration) int {
+		return calls(before, interval) + calls(after, interval)
+	}
+
+	base, prios := 0, 0
+	needs := make([]int, len(p.endpoints))
+	for i, e := range p.endpoints {
+		interval := maxDuration(e.Freshness, e.MinInterval)
+		needs[i] = nightCalls(interval) + calls(day, interval)
+		base += needs[i]
+		prios += e.Priority
+	}
+
+	if base > remaining {
+		// the budget does not suffice, the remaining calls are shared in
+		// proportion to the needed calls and the intervals are stretched to them
+		res.Feasible = false
+		total := end.Sub(now)
+		shares := apportion(remaining, needs, p.endpoints)
+		for i, e := range p.endpoints {
+			ep := EndpointPlan{Endpoint: e, DayInterval: total, NightInterval: total}
+			if n := time.Duration(shares[i]); n > 0 {
+				ep.DayInterval = maxDuration((total+n-1)/n, e.MinInterval)
+				ep.NightInterval = ep.DayInterval
+				ep.Calls = calls(total, ep.DayInterval)
+			}
+			res.Endpoints = append(res.Endpoints, ep)
+		}
+	} else {
+		extra := remaining - base
+		for _, e := range p.endpoints {
+			night := maxDuration(e.Freshness, e.MinInterval)
+			ep := EndpointPlan{Endpoint: e, DayInterval: night, NightInterval: night}
+			if prios > 0 && day > 0 {
+				n := time.Duration(calls(day, night) + extra*e.Priority/prios)
+				ep.DayInterval = maxDuration((day+n-1)/n, e.MinInterval)
+			}
+			ep.Calls = nightCalls(ep.NightInterval) + calls(day, ep.DayInterval)
+			res.Endpoints = append(res.Endpoints, ep)
+		}
+	}
+	for _, e := range res.Endpoints {
+		res.Planned += e.Calls
+	}
+	sort.SliceStable(res.Endpoints, func(i, j int) bool { return res.Endpoints[i].Priority > res.Endpoints[j].Priority })
+	return res
+}
+
+// Exhausted returns a plan without any calls for the rest of the day.
+func (p *Planner) Exhausted(now time.Time) *Plan {
+	return p.Plan(now, p.budget)
+}
+
+// Next returns the time of the next poll of the endpoint after a poll at last.
+// The time is never after the end of the plan, so a new plan can be made for
+// the next day.
+func (p *Plan) Next(name string, last time.Time) time.Time {
+	for _, e := range p.Endpoints {
+		if e.Name != name {
+			continue
+		}
+		if e.Calls == 0 {
+			return p.End
+		}
+		interval := e.NightInterval
+		change := p.DaylightStart
+		if !last.Before(p.DaylightStart) && last.Before(p.DaylightEnd) {
+			interval = e.DayInterval
+			change = p.DaylightEnd
+		}
+		next := last.Add(interval)
+		// the interval changes with the daylight
+		if e.DayInterval != e.NightInterval && change.After(last) && change.Before(next) {
+			next = change
+		}
+		if next.After(p.End) {
+			next = p.End
+		}
+		return next
+	}
+	return p.End
+}
+
+// Endpoints returns the endpoints of the planner.
+func (p *Planner) Endpoints() []Endpoint {
+	return p.endpoints
+}
@@ -0,0 +1,185 @@
+package schedule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testEndpoints = []Endpoint{
+	{Name: "overview", Priority: 1, Freshness: time.Hour, MinInterval: 15 * time.Minute},
+	{Name: "powerflow", Priority: 3, Freshness: 15 * time.Minute, MinInterval: 3 * time.Minute},
+}
+
+func ceilDiv(d time.Duration, n int) time.Duration {
+	return (d + time.Duration(n) - 1) / time.Duration(n)
+}
+
+func endpointPlan(t *testing.T, p *Plan, name string) EndpointPlan {
+	t.Helper()
+	for _, e := range p.Endpoints {
+		if e.Name == name {
+			return e
+		}
+	}
+	t.Fatalf("plan has no endpoint %q", name)
+	return EndpointPlan{}
+}
+
+func TestPlan(t *testing.T) {
+	wien := zone(t, "Europe/Vienna")
+	at := func(d, h, min int) time.Time { return time.Date(2023, 3, d, h, min, 0, 0, wien) }
+	type want struct {
+		calls      int
+		day, night time.Duration
+	}
+	for _, tc := range []struct {
+		name     string
+		now      time.Time
+		budget   int
+		used     int
+		feasible bool
+		planned  int
+		end      time.Time
+		want     map[string]want
+	}{
+		// 96 calls for the powerflow and 24 for the overview are needed, the
+		// 180 calls which are left go to the daylight by priority
+		{"feasible", at(20, 0, 0), 300, 0, true, 291, at(21, 0, 0), map[string]want{
+			"powerflow": {231, ceilDiv(12*time.Hour, 183), 15 * time.Minute},
+			"overview":  {60, 15 * time.Minute, time.Hour},
+		}},
+		{"feasible without extra calls", at(20, 0, 0), 120, 0, true, 120, at(21, 0, 0), map[string]want{
+			"powerflow": {96, 15 * time.Minute, 15 * time.Minute},
+			"overview":  {24, time.Hour, time.Hour},
+		}},
+		// the calls are shared 4:1 like the needed calls
+		{"infeasible", at(20, 0, 0), 50, 0, false, 50, at(21, 0, 0), map[string]want{
+			"powerflow": {40, 36 * time.Minute, 36 * time.Minute},
+			"overview":  {10, 144 * time.Minute, 144 * time.Minute},
+		}},
+		// a single call is not rounded up to one call per endpoint
+		{"infeasible single call", at(20, 0, 0), 121, 120, false, 1, at(21, 0, 0), map[string]want{
+			"powerflow": {1, 24 * time.Hour, 24 * time.Hour},
+			"overview":  {0, 24 * time.Hour, 24 * time.Hour},
+		}},
+		// 48 and 12 calls are needed, the powerflow has the larger remainder
+		{"infeasible after noon", at(20, 12, 0), 61, 30, false, 31, at(21, 0, 0), map[string]want{
+			"powerflow": {25, 12 * time.Hour / 25, 12 * time.Hour / 25},
+			"overview":  {6, 2 * time.Hour, 2 * time.Hour},
+		}},
+		{"exhausted", at(20, 10, 0), 100, 100, false, 0, at(21, 0, 0), map[string]want{
+			"powerflow": {0, 14 * time.Hour, 14 * time.Hour},
+			"overview":  {0, 14 * time.Hour, 14 * time.Hour},
+		}},
+		{"overused", at(20, 10, 0), 100, 130, false, 0, at(21, 0, 0), map[string]want{
+			"powerflow": {0, 14 * time.Hour, 14 * time.Hour},
+			"overview":  {0, 14 * time.Hour, 14 * time.Hour},
+		}},
+		// after the daylight only the night is left until midnight
+		{"before midnight", at(20, 23, 50), 300, 200, true, 2, at(21, 0, 0), map[string]want{
+			"powerflow": {1, 15 * time.Minute, 15 * time.Minute},
+			"overview":  {1, time.Hour, time.Hour},
+		}},
+		// the day of the change to daylight saving time has 23 hours
+		{"dst day", at(26, 0, 0), 46, 0, false, 46, at(27, 0, 0), map[string]want{
+			"powerflow": {37, ceilDiv(23*time.Hour, 37), ceilDiv(23*time.Hour, 37)},
+			"overview":  {9, 23 * time.Hour / 9, 23 * time.Hour / 9},
+		}},
+	} {
+		p := NewPlanner(New(wien), tc.budget, testEndpoints).Plan(tc.now, tc.used)
+		if p.Feasible != tc.feasible || p.Planned != tc.planned || !p.End.Equal(tc.end) {
+			t.Errorf("%s: plan is feasible %v with %d calls until %v, want %v with %d until %v", tc.name, p.Feasible, p.Planned, p.End, tc.feasible, tc.planned, tc.end)
+		}
+		if remaining := tc.budget - tc.used; p.Planned > remaining && remaining >= 0 {
+			t.Errorf("%s: %d calls are planned, only %d are left", tc.name, p.Planned, remaining)
+		}
+		if p.Endpoints[0].Name != "powerflow" {
+			t.Errorf("%s: endpoints are not sorted by priority: %v", tc.name, p.Endpoints)
+		}
+		for name, w := range tc.want {
+			e := endpointPlan(t, p, name)
+			if e.Calls != w.calls || e.DayInterval != w.day || e.NightInterval != w.night {
+				t.Errorf("%s: %s has %d calls with %v/%v, want %d with %v/%v", tc.name, name, e.Calls, e.DayInterval, e.NightInterval, w.calls, w.day, w.night)
+			}
+		}
+	}
+}
+
+func TestPlanNext(t *testing.T) {
+	wien := zone(t, "Europe/Vienna")
+	at := func(d, h, min int) time.Time { return time.Date(2023, 3, d, h, min, 0, 0, wien) }
+	planner := NewPlanner(New(wien), 300, testEndpoints)
+	p := planner.Plan(at(20, 0, 0), 0)
+	day := endpointPlan(t, p, "powerflow").DayInterval
+	for _, tc := range []struct {
+		name string
+		last time.Time
+		want time.Time
+	}{
+		{"night", at(20, 2, 0), at(20, 2, 15)},
+		// the night interval ends at the start of the daylight
+		{"sunrise", at(20, 7, 50), at(20, 8, 0)},
+		{"day", at(20, 12, 0), at(20, 12, 0).Add(day)},
+		{"sunset", at(20, 19, 59), at(20, 20, 0)},
+		// the plan ends at midnight
+		{"midnight", at(20, 23, 50), at(21, 0, 0)},
+	} {
+		if got := p.Next("powerflow", tc.last); !got.Equal(tc.want) {
+			t.Errorf("%s: next poll after %v is %v, want %v", tc.name, tc.last, got, tc.want)
+		}
+	}
+	if got := p.Next("unknown", at(20, 12, 0)); !got.Equal(p.End) {
+		t.Errorf("next poll of an unknown endpoint is %v", got)
+	}
+	if got := planner.Exhausted(at(20, 12, 0)).Next("powerflow", at(20, 12, 0)); !got.Equal(at(21, 0, 0)) {
+		t.Errorf("next poll of an exhausted plan is %v", got)
+	}
+}
+
+func TestApportion(t *testing.T) {
+	for _, tc := range []struct {
+		n     int
+		needs []int
+		prios []int
+		want  []int
+	}{
+		{50, []int{24, 96}, []int{1, 3}, []int{10, 40}},
+		{1, []int{24, 96}, []int{1, 3}, []int{0, 1}},
+		// a tie goes to the higher priority
+		{1, []int{10, 10}, []int{1, 3}, []int{0, 1}},
+		{3, []int{10, 10, 10, 10}, []int{1, 2, 3, 4}, []int{0, 1, 1, 1}},
+		{7, []int{3, 3, 3}, []int{1, 1, 1}, []int{3, 2, 2}},
+		{0, []int{3, 3}, []int{1, 1}, []int{0, 0}},
+		{5, []int{0, 0}, []int{1, 1}, []int{0, 0}},
+	} {
+		var eps []Endpoint
+		for _, p := range tc.prios {
+			eps = append(eps, Endpoint{Priority: p})
+		}
+		if got := apportion(tc.n, tc.needs, eps); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("apportion(%d, %v) is %v, want %v", tc.n, tc.needs, got, tc.want)
+		}
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Endpoint
+		err  bool
+	}{
+		{"powerflow=3,15m", Endpoint{Name: "powerflow", Priority: 3, Freshness: 15 * time.Minute}, false},
+		{" overview = 0 , 1h ", Endpoint{Name: "overview", Freshness: time.Hour}, false},
+		{"powerflow", Endpoint{}, true},
+		{"powerflow=3", Endpoint{}, true},
+		{"powerflow=-1,15m", Endpoint{}, true},
+		{"powerflow=3,0s", Endpoint{}, true},
+		{"powerflow=high,15m", Endpoint{}, true},
+	} {
+		got, err := ParseEndpoint(tc.s)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseEndpoint(%q) is %+v, %v", tc.s, got, err)
+		}
+	}
+}
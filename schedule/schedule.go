@@ -0,0 +1,120 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Window is a fixed part of the day, given as minutes since midnight.
+type Window struct {
+	Start int
+	End   int
+}
+
+// DefaultWindow is the daylight when no coordinates are known.
+var DefaultWindow = Window{Start: 8 * 60, End: 20 * 60}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse time of day %q: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseWindow parses a window in the form "08:00-20:00".
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q is not in the form hh:mm-hh:mm", s)
+	}
+	var res Window
+	var err error
+	if res.Start, err = parseClock(start); err != nil {
+		return Window{}, err
+	}
+	if res.End, err = parseClock(end); err != nil {
+		return Window{}, err
+	}
+	if res.End <= res.Start {
+		return Window{}, fmt.Errorf("the window %q ends before it starts", s)
+	}
+	return res, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Opt is an option type for the Scheduler.
+type Opt func(s *Scheduler)
+
+// WithCoordinates computes the daylight from sunrise and sunset at the given
+// coordinates.
+func WithCoordinates(c Coordinates) Opt {
+	return func(s *Scheduler) {
+		s.coords = &c
+	}
+}
+
+// WithMargins starts the daylight the given duration after sunrise and ends it
+// the given duration before sunset. Negative margins extend the daylight.
+func WithMargins(sunrise, sunset time.Duration) Opt {
+	return func(s *Scheduler) {
+		s.sunriseMargin = sunrise
+		s.sunsetMargin = sunset
+	}
+}
+
+// WithWindow sets the fixed daylight which is used when no coordinates are set.
+func WithWindow(w Window) Opt {
+	return func(s *Scheduler) {
+		s.window = w
+	}
+}
+
+// A Scheduler returns the daylight of a site. All computations are done in the
+// zone of the site, not in the zone of the host.
+type Scheduler struct {
+	zone          *time.Location
+	coords        *Coordinates
+	sunriseMargin time.Duration
+	sunsetMargin  time.Duration
+	window        Window
+}
+
+// New returns a scheduler for a site in the given zone.
+func New(zone *time.Location, opts ...Opt) *Scheduler {
+	res := &Scheduler{
+		zone:   zone,
+		window: DefaultWindow,
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// Daylight returns the start and the end of the daylight at the calendar day
+// of t in the zone of the site.
+func (s *Scheduler) Daylight(t time.Time) (start, end time.Time) {
+	t = t.In(s.zone)
+	if s.coords == nil {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, s.window.Start/60, s.window.Start%60, 0, 0, s.zone),
+			time.Date(y, m, d, s.window.End/60, s.window.End%60, 0, 0, s.zone)
+	}
+	rise, set := s.coords.SunTimes(t)
+	start, end = rise.Add(s.sunriseMargin), set.Add(-s.sunsetMargin)
+	if end.Before(start) {
+		end = start
+	}
+	return start, end
+}
+
+// IsDay returns true if t is within the daylight.
+func (s *Scheduler) IsDay(t time.Time) bool {
+	start, end := s.Daylight(t)
+	return !t.Before(start) && t.Before(end)
+}
@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Window
+		err  bool
+	}{
+		{"08:00-20:00", Window{Start: 480, End: 1200}, false},
+		{" 6:30 - 21:15 ", Window{Start: 390, End: 1275}, false},
+		{"00:00-23:59", Window{Start: 0, End: 1439}, false},
+		{"08:00", Window{}, true},
+		{"8-20", Window{}, true},
+		{"08:00-24:00", Window{}, true},
+		{"20:00-08:00", Window{}, true},
+		{"08:00-08:00", Window{}, true},
+	} {
+		got, err := ParseWindow(tc.s)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseWindow(%q) is %v, %v", tc.s, got, err)
+			continue
+		}
+		if err == nil {
+			if again, _ := ParseWindow(got.String()); again != got {
+				t.Errorf("window %v is printed as %q", got, got.String())
+			}
+		}
+	}
+}
+
+func TestDaylight(t *testing.T) {
+	wien := zone(t, "Europe/Vienna")
+	at := func(m time.Month, d, h, min int) time.Time { return time.Date(2023, m, d, h, min, 0, 0, wien) }
+	for _, tc := range []struct {
+		name       string
+		s          *Scheduler
+		t          time.Time
+		start, end time.Time
+	}{
+		{"default window", New(wien), at(6, 21, 3, 0), at(6, 21, 8, 0), at(6, 21, 20, 0)},
+		// the day in the zone of the site, not of the given time
+		{"window in site zone", New(wien), time.Date(2023, 6, 20, 23, 0, 0, 0, time.UTC), at(6, 21, 8, 0), at(6, 21, 20, 0)},
+		{"window on dst change", New(wien, WithWindow(Window{Start: 60, End: 23 * 60})), at(3, 26, 12, 0), at(3, 26, 1, 0), at(3, 26, 23, 0)},
+		{"coordinates", New(wien, WithCoordinates(vienna)), at(6, 21, 12, 0), at(6, 21, 4, 53), at(6, 21, 20, 58)},
+		{"margins", New(wien, WithCoordinates(vienna), WithMargins(time.Hour, 30*time.Minute)), at(6, 21, 12, 0), at(6, 21, 5, 53), at(6, 21, 20, 28)},
+		{"negative margins", New(wien, WithCoordinates(vienna), WithMargins(-time.Hour, -time.Hour)), at(12, 21, 12, 0), at(12, 21, 6, 42), at(12, 21, 17, 3)},
+		// margins larger than the day leave no daylight
+		{"margins beyond the day", New(wien, WithCoordinates(vienna), WithMargins(5*time.Hour, 5*time.Hour)), at(12, 21, 12, 0), at(12, 21, 12, 42), at(12, 21, 12, 42)},
+		// the window is ignored with coordinates
+		{"coordinates win", New(wien, WithCoordinates(vienna), WithWindow(Window{Start: 0, End: 60})), at(6, 21, 12, 0), at(6, 21, 4, 53), at(6, 21, 20, 58)},
+	} {
+		start, end := tc.s.Daylight(tc.t)
+		if !near(start, tc.start) || !near(end, tc.end) {
+			t.Errorf("%s: daylight is %v - %v, want %v - %v", tc.name, start, end, tc.start, tc.end)
+		}
+		if start.Location() != wien || end.Location() != wien {
+			t.Errorf("%s: daylight is in %v", tc.name, start.Location())
+		}
+	}
+}
+
+func TestIsDay(t *testing.T) {
+	wien := zone(t, "Europe/Vienna")
+	s := New(wien, WithWindow(Window{Start: 8 * 60, End: 20 * 60}))
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2023, 6, 21, 7, 59, 0, 0, wien), false},
+		{time.Date(2023, 6, 21, 8, 0, 0, 0, wien), true},
+		{time.Date(2023, 6, 21, 19, 59, 0, 0, wien), true},
+		{time.Date(2023, 6, 21, 20, 0, 0, 0, wien), false},
+		// 07:00 UTC is 09:00 in Vienna
+		{time.Date(2023, 6, 21, 7, 0, 0, 0, time.UTC), true},
+	} {
+		if got := s.IsDay(tc.t); got != tc.want {
+			t.Errorf("IsDay(%v) is %v, want %v", tc.t, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package schedule computes when the data of a site should be polled. During
+// daylight the powerflow changes quickly and is polled more often than at night.
+// The daylight is computed from the position of the sun at the coordinates of
+// the site or given as a fixed window of the day.
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// julian day of 2000-01-01 12:00 UTC
+	j2000 = 2451545.0
+	// julian day of the unix epoch
+	jUnix = 2440587.5
+	// the altitude of the sun at sunrise and sunset, corrected for refraction
+	// and the diameter of the sun
+	sunAltitude = -0.833
+	// the obliquity of the earth
+	obliquity = 23.4397
+)
+
+// Coordinates are the geographic position of a site in degrees. The longitude
+// is positive east of Greenwich.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ParseCoordinates parses coordinates in the form "lat,lon".
+func ParseCoordinates(s string) (Coordinates, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return Coordinates{}, fmt.Errorf("coordinates %q are not in the form lat,lon", s)
+	}
+	var res Coordinates
+	var err error
+	if res.Latitude, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return Coordinates{}, fmt.Errorf("cannot parse latitude %q: %w", lat, err)
+	}
+	if res.Longitude, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil {
+		return Coordinates{}, fmt.Errorf("cannot parse longitude %q: %w", lon, err)
+	}
+	if math.Abs(res.Latitude) > 90 || math.Abs(res.Longitude) > 180 {
+		return Coordinates{}, fmt.Errorf("coordinates %q are out of range", s)
+	}
+	return res, nil
+}
+
+func sin(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cos(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+
+func julianTime(j float64) time.Time {
+	secs := (j - jUnix) * 86400
+	return time.Unix(0, int64(secs*float64(time.Second)))
+}
+
+// SunTimes returns the sunrise and the sunset of the calendar day of t in the
+// zone of t. If the sun does not set on that day, the whole day is returned; if
+// it does not rise, sunrise and sunset are both the solar noon.
+func (c Coordinates) SunTimes(t time.Time) (sunrise, sunset time.Time) {
+	y, m, d := t.Date()
+	dayStart := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	// the days since 2000-01-01 of the calendar day
+	n := math.Round(float64(time.Date(y, m, d, 12, 0, 0, 0, time.UTC).Unix())/86400 + jUnix - j2000)
+	// the mean solar noon
+	jstar := n - c.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*jstar, 360)
+	center := 1.9148*sin(anomaly) + 0.02*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := j2000 + jstar + 0.0053*sin(anomaly) - 0.0069*sin(2*ecliptic)
+	declination := math.Asin(sin(ecliptic)*sin(obliquity)) * 180 / math.Pi
+
+	cosHour := (sin(sunAltitude) - sin(c.Latitude)*sin(declination)) / (cos(c.Latitude) * cos(declination))
+	switch {
+	case cosHour < -1:
+		return dayStart, dayStart.AddDate(0, 0, 1)
+	case cosHour > 1:
+		noon := julianTime(transit).In(t.Location())
+		return noon, noon
+	}
+	hourAngle := math.Acos(cosHour) * 180 / math.Pi
+	return julianTime(transit - hourAngle/360).In(t.Location()), julianTime(transit + hourAngle/360).In(t.Location())
+}
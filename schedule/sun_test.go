@@ -0,0 +1,88 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+var vienna = Coordinates{Latitude: 48.2082, Longitude: 16.3738}
+
+func zone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// near returns true if a and b differ by less than two minutes.
+func near(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -2*time.Minute && d < 2*time.Minute
+}
+
+func TestSunTimes(t *testing.T) {
+	wien, sydney := zone(t, "Europe/Vienna"), zone(t, "Australia/Sydney")
+	at := func(loc *time.Location, m time.Month, d, h, min int) time.Time {
+		return time.Date(2023, m, d, h, min, 0, 0, loc)
+	}
+	for _, tc := range []struct {
+		c                       Coordinates
+		t                       time.Time
+		wantSunrise, wantSunset time.Time
+	}{
+		{vienna, at(wien, 6, 21, 12, 0), at(wien, 6, 21, 4, 53), at(wien, 6, 21, 20, 58)},
+		{vienna, at(wien, 12, 21, 0, 30), at(wien, 12, 21, 7, 42), at(wien, 12, 21, 16, 3)},
+		// the day of the change to daylight saving time
+		{vienna, at(wien, 3, 26, 23, 0), at(wien, 3, 26, 6, 46), at(wien, 3, 26, 19, 14)},
+		{Coordinates{Latitude: -33.8688, Longitude: 151.2093}, at(sydney, 6, 21, 12, 0), at(sydney, 6, 21, 7, 0), at(sydney, 6, 21, 16, 54)},
+		// the calendar day is the day in the zone of t, not in UTC
+		{Coordinates{Latitude: -33.8688, Longitude: 151.2093}, at(sydney, 6, 21, 1, 0), at(sydney, 6, 21, 7, 0), at(sydney, 6, 21, 16, 54)},
+	} {
+		rise, set := tc.c.SunTimes(tc.t)
+		if !near(rise, tc.wantSunrise) || !near(set, tc.wantSunset) {
+			t.Errorf("sun times at %v of %v are %v - %v, want %v - %v", tc.c, tc.t, rise, set, tc.wantSunrise, tc.wantSunset)
+		}
+		if rise.Location() != tc.t.Location() || set.Location() != tc.t.Location() {
+			t.Errorf("sun times at %v are in %v and %v", tc.t, rise.Location(), set.Location())
+		}
+	}
+}
+
+func TestSunTimesPolar(t *testing.T) {
+	oslo := zone(t, "Europe/Oslo")
+	tromso := Coordinates{Latitude: 69.6492, Longitude: 18.9553}
+
+	// the midnight sun is the whole calendar day
+	rise, set := tromso.SunTimes(time.Date(2023, 6, 21, 12, 0, 0, 0, oslo))
+	if !rise.Equal(time.Date(2023, 6, 21, 0, 0, 0, 0, oslo)) || !set.Equal(time.Date(2023, 6, 22, 0, 0, 0, 0, oslo)) {
+		t.Errorf("polar day is %v - %v", rise, set)
+	}
+	// the polar night has no daylight at the solar noon
+	rise, set = tromso.SunTimes(time.Date(2023, 12, 21, 12, 0, 0, 0, oslo))
+	if !rise.Equal(set) || !near(rise, time.Date(2023, 12, 21, 11, 42, 0, 0, oslo)) {
+		t.Errorf("polar night is %v - %v", rise, set)
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Coordinates
+		err  bool
+	}{
+		{"48.2082,16.3738", vienna, false},
+		{" -33.5 , 151 ", Coordinates{Latitude: -33.5, Longitude: 151}, false},
+		{"48.2", Coordinates{}, true},
+		{"north,16", Coordinates{}, true},
+		{"48,east", Coordinates{}, true},
+		{"91,0", Coordinates{}, true},
+		{"0,-181", Coordinates{}, true},
+	} {
+		got, err := ParseCoordinates(tc.s)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseCoordinates(%q) is %v, %v", tc.s, got, err)
+		}
+	}
+}
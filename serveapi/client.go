@@ -0,0 +1,186 @@
+package serveapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+)
+
+// ResponseError is returned when serve answers with a non 2xx statuscode.
+type ResponseError struct {
+	StatusCode int
+	Data       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("responsecode %d, data: %s", e.StatusCode, strings.TrimSpace(e.Data))
+}
+
+// Opt is an option for the Client.
+type Opt func(c *Client)
+
+// A Client calls the API of a serve instance. The methods take the ID of a site;
+// an empty ID selects the first site of the instance.
+type Client struct {
+	baseurl  string
+	client   *http.Client
+	token    string
+	username string
+	password string
+}
+
+// NewClient returns a client for the serve instance at the given URL, e.g.
+// http://localhost:7777.
+func NewClient(baseurl string, opts ...Opt) *Client {
+	res := &Client{
+		baseurl: strings.TrimSuffix(baseurl, "/"),
+		client:  http.DefaultClient,
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// WithHTTPClient sends the requests with the given client, e.g. to set a
+// timeout or a TLS configuration.
+func WithHTTPClient(hc *http.Client) Opt {
+	return func(c *Client) {
+		c.client = hc
+	}
+}
+
+// WithToken authenticates with a bearer token.
+func WithToken(token string) Opt {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithBasicAuth authenticates with a username and a password.
+func WithBasicAuth(username, password string) Opt {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+func sitePath(site, endpoint string) string {
+	if site == "" {
+		return "/" + endpoint
+	}
+	return "/sites/" + url.PathEscape(site) + "/" + endpoint
+}
+
+// call sends the request and decodes the JSON response into target if it is not
+// nil. Responses with one of the accepted statuscodes are no errors.
+func (c *Client) call(method, path string, target any, accepted ...int) error {
+	rq, err := http.NewRequest(method, c.baseurl+path, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	rq.Header.Set("accept", "application/json")
+	switch {
+	case c.token != "":
+		rq.Header.Set("authorization", "Bearer "+c.token)
+	case c.username != "":
+		rq.SetBasicAuth(c.username, c.password)
+	}
+	rsp, err := c.client.Do(rq)
+	if err != nil {
+		return fmt.Errorf("cannot invoke request: %w", err)
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read body response: %w", err)
+	}
+	ok := rsp.StatusCode/100 == 2
+	for _, s := range accepted {
+		ok = ok || rsp.StatusCode == s
+	}
+	if !ok {
+		return &ResponseError{StatusCode: rsp.StatusCode, Data: string(data)}
+	}
+	if target == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("cannot parse %q as json: %w", string(data), err)
+	}
+	return nil
+}
+
+// Sites returns the sites of the instance.
+func (c *Client) Sites() ([]Site, error) {
+	var res []Site
+	if err := c.call(http.MethodGet, "/sites", &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Flow returns the simplified power flow of the site.
+func (c *Client) Flow(site string) (*FlowData, error) {
+	var res FlowData
+	if err := c.call(http.MethodGet, sitePath(site, "flow"), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PowerFlow returns the power flow of the site as sent by the solaredge API.
+func (c *Client) PowerFlow(site string) (*solaredge.PowerFlow, error) {
+	var res solaredge.PowerFlow
+	if err := c.call(http.MethodGet, sitePath(site, "powerflow"), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Overview returns the energy overview of the site. The last update time is in
+// solaredge.SiteZone.
+func (c *Client) Overview(site string) (*solaredge.OverviewData, error) {
+	var res solaredge.OverviewData
+	if err := c.call(http.MethodGet, sitePath(site, "overview"), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Details returns the details of the site.
+func (c *Client) Details(site string) (*solaredge.Site, error) {
+	var res solaredge.Site
+	if err := c.call(http.MethodGet, sitePath(site, "details"), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Inventory returns the devices of the site.
+func (c *Client) Inventory(site string) (*solaredge.Inventory, error) {
+	var res solaredge.Inventory
+	if err := c.call(http.MethodGet, sitePath(site, "inventory"), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Refresh asks the instance to poll the site now.
+func (c *Client) Refresh(site string) error {
+	return c.call(http.MethodPost, sitePath(site, "refresh"), nil)
+}
+
+// Ready returns the readiness of the instance and its sites.
+func (c *Client) Ready() (*Readiness, error) {
+	var res Readiness
+	if err := c.call(http.MethodGet, "/readyz", &res, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
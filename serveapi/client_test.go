@@ -0,0 +1,156 @@
+package serveapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testServer answers the API of a serve instance with the site "1" and records
+// the requests.
+type testServer struct {
+	requests []string
+	auth     []string
+	ready    bool
+}
+
+func (ts *testServer) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+	ts.requests = append(ts.requests, rq.Method+" "+rq.URL.EscapedPath())
+	ts.auth = append(ts.auth, rq.Header.Get("authorization"))
+	if rq.Header.Get("accept") != "application/json" {
+		http.Error(rw, "json only", http.StatusNotAcceptable)
+		return
+	}
+	changed := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var res any
+	switch rq.Method + " " + rq.URL.EscapedPath() {
+	case "GET /sites":
+		res = []Site{{ID: "1", Name: "home"}}
+	case "GET /flow", "GET /sites/1/flow":
+		res = FlowData{PV: 3000, Grid: -500, Battery: -1000, SoC: 55, Freshness: Freshness{Changed: &changed}}
+	case "GET /sites/1/powerflow":
+		fmt.Fprint(rw, `{"unit":"kW","PV":{"status":"Active","currentPower":3.0},"connections":[{"from":"PV","to":"Load"}],"stale":true}`)
+		return
+	case "GET /sites/1/overview":
+		fmt.Fprint(rw, `{"lastUpdateTime":"2023-05-01 14:00:00","lastDayData":{"energy":12000},"currentPower":{"power":3000}}`)
+		return
+	case "GET /sites/1/details":
+		fmt.Fprint(rw, `{"id":1,"name":"home","location":{"timeZone":"Europe/Vienna"}}`)
+		return
+	case "GET /sites/1/inventory":
+		fmt.Fprint(rw, `{"inverters":[{"name":"Inverter 1","SN":"SN-1"}]}`)
+		return
+	case "POST /sites/1/refresh":
+		rw.WriteHeader(http.StatusAccepted)
+		return
+	case "GET /sites/a%2Fb/flow":
+		fmt.Fprint(rw, `{"pv":`)
+		return
+	case "GET /readyz":
+		res = Readiness{Ready: ts.ready, Sites: []SiteHealth{{ID: "1", Name: "home", Ready: ts.ready, Reason: "no data"}}}
+		if !ts.ready {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	default:
+		http.Error(rw, "unknown site", http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(rw).Encode(res)
+}
+
+func TestClient(t *testing.T) {
+	ts := &testServer{}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+	c := NewClient(srv.URL+"/", WithToken("secret"))
+
+	sites, err := c.Sites()
+	if err != nil || len(sites) != 1 || sites[0] != (Site{ID: "1", Name: "home"}) {
+		t.Errorf("sites are %v: %v", sites, err)
+	}
+	for _, site := range []string{"", "1"} {
+		f, err := c.Flow(site)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.PV != 3000 || f.Grid != -500 || f.Battery != -1000 || f.SoC != 55 || f.Stale || f.Changed == nil || f.Changed.Hour() != 12 {
+			t.Errorf("flow of %q is %+v", site, *f)
+		}
+	}
+	pf, err := c.PowerFlow("1")
+	if err != nil || pf.Unit != "kW" || pf.PV.CurrentPower != 3.0 || len(pf.Connections) != 1 {
+		t.Errorf("powerflow is %+v: %v", pf, err)
+	}
+	ov, err := c.Overview("1")
+	if err != nil || ov.LastDayData.Energy != 12000 || ov.CurrentPower.Power != 3000 || time.Time(ov.LastUpdateTime).Hour() != 14 {
+		t.Errorf("overview is %+v: %v", ov, err)
+	}
+	d, err := c.Details("1")
+	if err != nil || d.Id != 1 || d.Location.TimeZone != "Europe/Vienna" {
+		t.Errorf("details are %+v: %v", d, err)
+	}
+	inv, err := c.Inventory("1")
+	if err != nil || len(inv.Inverters) != 1 || inv.Inverters[0].SN != "SN-1" {
+		t.Errorf("inventory is %+v: %v", inv, err)
+	}
+	if err := c.Refresh("1"); err != nil {
+		t.Errorf("refresh failed: %v", err)
+	}
+	// a not ready instance answers with 503
+	for _, ready := range []bool{false, true} {
+		ts.ready = ready
+		r, err := c.Ready()
+		if err != nil || r.Ready != ready || len(r.Sites) != 1 || r.Sites[0].Ready != ready || r.Sites[0].Reason != "no data" {
+			t.Errorf("readiness is %+v: %v", r, err)
+		}
+	}
+
+	want := []string{
+		"GET /sites", "GET /flow", "GET /sites/1/flow", "GET /sites/1/powerflow", "GET /sites/1/overview",
+		"GET /sites/1/details", "GET /sites/1/inventory", "POST /sites/1/refresh", "GET /readyz", "GET /readyz",
+	}
+	if fmt.Sprint(ts.requests) != fmt.Sprint(want) {
+		t.Errorf("requests are %v, want %v", ts.requests, want)
+	}
+	for _, a := range ts.auth {
+		if a != "Bearer secret" {
+			t.Errorf("authorization is %q", a)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ts := &testServer{}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+	c := NewClient(srv.URL, WithBasicAuth("me", "pw"))
+
+	_, err := c.Flow("2")
+	var re *ResponseError
+	if !errors.As(err, &re) || re.StatusCode != http.StatusNotFound || re.Data != "unknown site\n" {
+		t.Errorf("unknown site returned %v", err)
+	}
+	if err == nil || err.Error() != "responsecode 404, data: unknown site" {
+		t.Errorf("error is %v", err)
+	}
+	// the site is escaped, the answer is no valid json
+	if _, err := c.Flow("a/b"); err == nil || errors.As(err, &re) {
+		t.Errorf("invalid json returned %v", err)
+	}
+	basic, _ := http.NewRequest(http.MethodGet, "/", nil)
+	basic.SetBasicAuth("me", "pw")
+	for _, a := range ts.auth {
+		if a != basic.Header.Get("authorization") {
+			t.Errorf("authorization is %q", a)
+		}
+	}
+
+	srv.Close()
+	if _, err := c.Sites(); err == nil {
+		t.Error("closed server answered")
+	}
+}
@@ -0,0 +1,55 @@
+// Package serveapi contains the types of the HTTP API of the serve command and a
+// typed client for it. The API is described as OpenAPI 3 in openapi.json, which
+// serve also answers at /openapi.json.
+package serveapi
+
+import (
+	_ "embed"
+	"time"
+)
+
+// OpenAPI is the OpenAPI 3 description of the serve API.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Freshness tells if the data of a response is stale.
+type Freshness struct {
+	// Stale is true if the data did not change for longer than the stale
+	// threshold of serve.
+	Stale bool `json:"stale"`
+	// Changed is the last time the data changed; for the overview it is the last
+	// update time of the site.
+	Changed *time.Time `json:"changed,omitempty"`
+}
+
+// FlowData is the simplified power flow of a site in W; grid is negative when
+// power is fed into the grid, battery is negative while it is charging.
+type FlowData struct {
+	PV      float64 `json:"pv"`
+	Grid    float64 `json:"grid"`
+	Battery float64 `json:"battery"`
+	SoC     float64 `json:"soc"`
+	Freshness
+}
+
+// Site is the entry of a site in the site list.
+type Site struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SiteHealth is the readiness of a site.
+type SiteHealth struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Ready     bool       `json:"ready"`
+	LastFetch *time.Time `json:"lastFetch,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// Readiness is the answer of /readyz.
+type Readiness struct {
+	Ready bool         `json:"ready"`
+	Sites []SiteHealth `json:"sites"`
+}
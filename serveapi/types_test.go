@@ -0,0 +1,172 @@
+package serveapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// schema is the part of an OpenAPI schema which is compared with the types.
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Properties map[string]*schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *schema            `json:"items"`
+	AllOf      []*schema          `json:"allOf"`
+}
+
+// property is a property of an object schema or a field of a type.
+type property struct {
+	schema   *schema
+	typ      reflect.Type
+	required bool
+}
+
+func loadSchemas(t *testing.T) map[string]*schema {
+	t.Helper()
+	var doc struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatalf("cannot parse openapi.json: %v", err)
+	}
+	return doc.Components.Schemas
+}
+
+// schemaProperties returns the properties of the schema including the
+// properties of all schemas of allOf.
+func schemaProperties(t *testing.T, schemas map[string]*schema, s *schema) map[string]property {
+	t.Helper()
+	if s.Ref != "" {
+		ref, ok := schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			t.Fatalf("unknown schema %s", s.Ref)
+		}
+		s = ref
+	}
+	res := make(map[string]property)
+	for _, a := range s.AllOf {
+		for n, p := range schemaProperties(t, schemas, a) {
+			res[n] = p
+		}
+	}
+	for n, p := range s.Properties {
+		res[n] = property{schema: p}
+	}
+	for _, n := range s.Required {
+		p := res[n]
+		p.required = true
+		res[n] = p
+	}
+	return res
+}
+
+// fields returns the JSON fields of the struct type including the fields of
+// embedded structs. Fields without omitempty are required.
+func fields(typ reflect.Type) map[string]property {
+	res := make(map[string]property)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, ok := f.Tag.Lookup("json")
+		if f.Anonymous && !ok {
+			for n, p := range fields(f.Type) {
+				res[n] = p
+			}
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		res[name] = property{typ: f.Type, required: !strings.Contains(opts, "omitempty")}
+	}
+	return res
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaType returns the type and the format of the schema of a go type.
+func schemaType(typ reflect.Type) (string, string) {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == timeType:
+		return "string", "date-time"
+	case typ.Kind() == reflect.String:
+		return "string", ""
+	case typ.Kind() == reflect.Bool:
+		return "boolean", ""
+	case typ.Kind() == reflect.Float64:
+		return "number", ""
+	case typ.Kind() == reflect.Int:
+		return "integer", ""
+	case typ.Kind() == reflect.Slice:
+		return "array", ""
+	}
+	return "object", ""
+}
+
+func names(m map[string]property) string {
+	var res []string
+	for n := range m {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := loadSchemas(t)
+	for _, tc := range []struct {
+		name string
+		typ  reflect.Type
+	}{
+		{"FlowData", reflect.TypeOf(FlowData{})},
+		{"SiteHealth", reflect.TypeOf(SiteHealth{})},
+		{"Readiness", reflect.TypeOf(Readiness{})},
+		{"Site", reflect.TypeOf(Site{})},
+		{"Freshness", reflect.TypeOf(Freshness{})},
+	} {
+		s, ok := schemas[tc.name]
+		if !ok {
+			t.Errorf("openapi.json has no schema %s", tc.name)
+			continue
+		}
+		props, fs := schemaProperties(t, schemas, s), fields(tc.typ)
+		if names(props) != names(fs) {
+			t.Errorf("%s: schema has the properties %s, the type the fields %s", tc.name, names(props), names(fs))
+			continue
+		}
+		for n, f := range fs {
+			p := props[n]
+			if p.required != f.required {
+				t.Errorf("%s.%s: required is %v in the schema, omitempty is %v", tc.name, n, p.required, !f.required)
+			}
+			typ, format := schemaType(f.typ)
+			if p.schema.Type != typ || p.schema.Format != format {
+				t.Errorf("%s.%s: schema is %s %s, want %s %s", tc.name, n, p.schema.Type, p.schema.Format, typ, format)
+			}
+			if typ == "array" {
+				item, _ := schemaType(f.typ.Elem())
+				if item != "object" {
+					if p.schema.Items == nil || p.schema.Items.Type != item {
+						t.Errorf("%s.%s: items are %+v, want %s", tc.name, n, p.schema.Items, item)
+					}
+				} else if p.schema.Items == nil || p.schema.Items.Ref != "#/components/schemas/"+f.typ.Elem().Name() {
+					t.Errorf("%s.%s: items are %+v, want %s", tc.name, n, p.schema.Items, f.typ.Elem().Name())
+				}
+			}
+		}
+	}
+}
@@ -10,6 +10,39 @@ type storageData struct {
 	Batteries []StorageBattery `json:"batteries,omitempty"`
 }
 
+type inverterData struct {
+	Telemetries []InverterTelemetry `json:"telemetries,omitempty"`
+}
+
+// Sites returns the details of all sites which are visible with the API key.
+func (sec *SEClient) Sites() ([]Site, error) {
+	const pageSize = 100
+	var res []Site
+	for {
+		var page struct {
+			Count int    `json:"count"`
+			Site  []Site `json:"site"`
+		}
+		details := struct {
+			Sites any `json:"sites"`
+		}{
+			Sites: &page,
+		}
+		parms := url.Values{
+			"size":       []string{fmt.Sprint(pageSize)},
+			"startIndex": []string{fmt.Sprint(len(res))},
+		}
+		sec.quota.count("")
+		if err := sec.get("/sites/list.json", parms, &details); err != nil {
+			return res, err
+		}
+		res = append(res, page.Site...)
+		if len(page.Site) == 0 || len(res) >= page.Count {
+			return res, nil
+		}
+	}
+}
+
 // Details returns site information.
 func (sc *SiteClient) Details() (*Site, error) {
 	var res Site
@@ -47,6 +80,21 @@ func (sc *SiteClient) StorageData(start, end time.Time) ([]StorageBattery, error
 	return res.Batteries, sc.get(fmt.Sprintf("/site/%s/storageData.json", sc.siteid), parms, &details)
 }
 
+// InverterData returns the telemetries of the inverter with the given serial number.
+func (sc *SiteClient) InverterData(sn string, start, end time.Time) ([]InverterTelemetry, error) {
+	var res inverterData
+	details := struct {
+		Data *inverterData `json:"data"`
+	}{
+		Data: &res,
+	}
+	parms := url.Values{
+		"startTime": []string{start.Format(datetimePattern)},
+		"endTime":   []string{end.Format(datetimePattern)},
+	}
+	return res.Telemetries, sc.get(fmt.Sprintf("/equipment/%s/%s/data.json", sc.siteid, url.PathEscape(sn)), parms, &details)
+}
+
 // PowerDetails returns the power details.
 func (sc *SiteClient) PowerDetails(start, end time.Time) (*PowerDetails, error) {
 	var res PowerDetails
@@ -99,3 +147,14 @@ func (sc *SiteClient) Overview() (*OverviewData, error) {
 	}
 	return &res, sc.get(fmt.Sprintf("/site/%s/overview.json", sc.siteid), nil, &details)
 }
+
+// DataPeriod returns the first and last day with data of the site.
+func (sc *SiteClient) DataPeriod() (*DataPeriod, error) {
+	var res DataPeriod
+	details := struct {
+		Data *DataPeriod `json:"dataPeriod"`
+	}{
+		Data: &res,
+	}
+	return &res, sc.get(fmt.Sprintf("/site/%s/dataPeriod.json", sc.siteid), nil, &details)
+}
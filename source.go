@@ -0,0 +1,9 @@
+package solaredge
+
+// DataSource reads the current powerflow of a site. The SiteClient reads it from
+// the monitoring API, other sources read the devices of the site directly.
+type DataSource interface {
+	PowerFlow() (*PowerFlow, error)
+}
+
+var _ DataSource = (*SiteClient)(nil)
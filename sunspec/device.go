@@ -0,0 +1,123 @@
+package sunspec
+
+import (
+	"fmt"
+
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+)
+
+// Device is the register map of a SunSpec device for a modbus.Server. It starts
+// with the marker at the BaseAddress, followed by the common model and the
+// models of the device.
+type Device struct {
+	regs   *modbus.Registers
+	models map[int]uint16
+}
+
+// NewDevice returns a device with the models, which must be inverter, meter or
+// battery models. All values are zero until they are set.
+func NewDevice(common Common, unit byte, models ...int) (*Device, error) {
+	ids := append([]int{ModelCommon}, models...)
+	size := 2 + 2
+	for _, id := range ids {
+		l, ok := modelLength[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown SunSpec model %d", id)
+		}
+		size += 2 + l
+	}
+	res := &Device{
+		regs:   modbus.NewRegisters(BaseAddress, size),
+		models: make(map[int]uint16),
+	}
+	addr := uint16(BaseAddress)
+	res.regs.Set(addr, []uint16{marker0, marker1})
+	addr += 2
+	for _, id := range ids {
+		if _, ok := res.models[id]; ok {
+			return nil, fmt.Errorf("duplicate SunSpec model %d", id)
+		}
+		l := modelLength[id]
+		res.regs.Set(addr, []uint16{uint16(id), uint16(l)})
+		res.models[id] = addr + 2
+		addr += 2 + uint16(l)
+	}
+	res.regs.Set(addr, []uint16{EndModel, 0})
+	res.regs.Set(res.models[ModelCommon], encodeCommon(common, unit))
+	for _, id := range models {
+		switch {
+		case id >= ModelInverterSinglePhase && id <= ModelInverterThreePhase:
+			res.regs.Set(res.models[id], encodeInverter(Inverter{}, id))
+		case id >= ModelMeterSinglePhase && id <= ModelMeterDelta:
+			res.regs.Set(res.models[id], encodeMeter(Meter{}, id))
+		case id == ModelBattery:
+			res.regs.Set(res.models[id], encodeBattery(Battery{}))
+		default:
+			return nil, fmt.Errorf("unsupported SunSpec model %d", id)
+		}
+	}
+	return res, nil
+}
+
+// Registers returns the registers of the device, which answer the reads of a
+// modbus.Server.
+func (d *Device) Registers() *modbus.Registers {
+	return d.regs
+}
+
+// SetInverter sets the values of the inverter models of the device.
+func (d *Device) SetInverter(inv Inverter) {
+	d.regs.Update(func(set func(uint16, []uint16)) {
+		d.setInverter(set, inv)
+	})
+}
+
+// SetMeter sets the values of the meter models of the device; W is positive when
+// power is imported.
+func (d *Device) SetMeter(m Meter) {
+	d.regs.Update(func(set func(uint16, []uint16)) {
+		d.setMeter(set, m)
+	})
+}
+
+// SetBattery sets the values of the battery model of the device.
+func (d *Device) SetBattery(b Battery) {
+	d.regs.Update(func(set func(uint16, []uint16)) {
+		d.setBattery(set, b)
+	})
+}
+
+// Update sets the values of the inverter, the meter and the battery at once, so
+// a read never mixes the values of different updates. The battery is kept if b
+// is nil.
+func (d *Device) Update(inv Inverter, m Meter, b *Battery) {
+	d.regs.Update(func(set func(uint16, []uint16)) {
+		d.setInverter(set, inv)
+		d.setMeter(set, m)
+		if b != nil {
+			d.setBattery(set, *b)
+		}
+	})
+}
+
+func (d *Device) setInverter(set func(uint16, []uint16), inv Inverter) {
+	for _, id := range []int{ModelInverterSinglePhase, ModelInverterSplitPhase, ModelInverterThreePhase} {
+		if a, ok := d.models[id]; ok {
+			set(a, encodeInverter(inv, id))
+		}
+	}
+}
+
+func (d *Device) setMeter(set func(uint16, []uint16), m Meter) {
+	for _, id := range []int{ModelMeterSinglePhase, ModelMeterSplitPhase, ModelMeterWye, ModelMeterDelta} {
+		if a, ok := d.models[id]; ok {
+			set(a, encodeMeter(m, id))
+		}
+	}
+}
+
+func (d *Device) setBattery(set func(uint16, []uint16), b Battery) {
+	if a, ok := d.models[ModelBattery]; ok {
+		set(a, encodeBattery(b))
+	}
+}
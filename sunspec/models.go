@@ -0,0 +1,375 @@
+// Package sunspec reads and serves the SunSpec information models of inverters,
+// meters and batteries with Modbus. The values are scaled with the scale
+// factors of the models; values which are not implemented by a device are NaN.
+package sunspec
+
+import (
+	"math"
+)
+
+// The SunSpec marker "SunS" is at one of the base addresses, followed by the
+// models. Every model starts with its id and the length of its data, the last
+// model has the id EndModel.
+const (
+	BaseAddress = 40000
+	EndModel    = 0xFFFF
+	marker0     = 0x5375
+	marker1     = 0x6e53
+)
+
+// The supported models.
+const (
+	ModelCommon              = 1
+	ModelInverterSinglePhase = 101
+	ModelInverterSplitPhase  = 102
+	ModelInverterThreePhase  = 103
+	ModelMeterSinglePhase    = 201
+	ModelMeterSplitPhase     = 202
+	ModelMeterWye            = 203
+	ModelMeterDelta          = 204
+	ModelBattery             = 802
+)
+
+// The charge states of a battery.
+const (
+	ChargeOff         = 1
+	ChargeEmpty       = 2
+	ChargeDischarging = 3
+	ChargeCharging    = 4
+	ChargeFull        = 5
+	ChargeHolding     = 6
+	ChargeTesting     = 7
+)
+
+// The operating states of an inverter.
+const (
+	InverterOff          = 1
+	InverterSleeping     = 2
+	InverterStarting     = 3
+	InverterMPPT         = 4
+	InverterThrottled    = 5
+	InverterShuttingDown = 6
+	InverterFault        = 7
+	InverterStandby      = 8
+)
+
+// the lengths of the model data without id and length
+var modelLength = map[int]int{
+	ModelCommon:              66,
+	ModelInverterSinglePhase: 50,
+	ModelInverterSplitPhase:  50,
+	ModelInverterThreePhase:  50,
+	ModelMeterSinglePhase:    105,
+	ModelMeterSplitPhase:     105,
+	ModelMeterWye:            105,
+	ModelMeterDelta:          105,
+	ModelBattery:             62,
+}
+
+// the offsets of the used points in the model data
+const (
+	commonMn = 0
+	commonMd = 16
+	commonVr = 40
+	commonSN = 48
+	commonDA = 64
+
+	inverterA     = 0
+	inverterASF   = 4
+	inverterPhV   = 8
+	inverterVSF   = 11
+	inverterW     = 12
+	inverterWSF   = 13
+	inverterHz    = 14
+	inverterHzSF  = 15
+	inverterWH    = 22
+	inverterWHSF  = 24
+	inverterDCW   = 29
+	inverterDCWSF = 30
+	inverterSt    = 36
+
+	meterA      = 0
+	meterAph    = 1
+	meterASF    = 4
+	meterPhV    = 5
+	meterPhVph  = 6
+	meterVSF    = 13
+	meterHz     = 14
+	meterHzSF   = 15
+	meterW      = 16
+	meterWph    = 17
+	meterWSF    = 20
+	meterWhExp  = 36
+	meterWhImp  = 44
+	meterTotWSF = 52
+
+	batteryWHRtg   = 1
+	batterySoC     = 9
+	batteryChaSt   = 14
+	batteryW       = 45
+	batteryWHRtgSF = 51
+	batterySoCSF   = 54
+	batteryWSF     = 61
+)
+
+// the values of points which are not implemented
+const (
+	notImplementedInt16  = 0x8000
+	notImplementedUint16 = 0xFFFF
+	notImplementedAcc32  = 0
+)
+
+// Common is the common model which describes the device.
+type Common struct {
+	Manufacturer string
+	Model        string
+	Version      string
+	Serial       string
+}
+
+// Inverter are the values of the inverter models 101 to 103. W is the AC power
+// and DCW the DC power of the inverter, WH the energy it produced.
+type Inverter struct {
+	W     float64
+	DCW   float64
+	WH    float64
+	State int
+}
+
+// Meter are the values of the meter models 201 to 204. W is positive when power
+// is imported from the grid.
+type Meter struct {
+	W          float64
+	WhImported float64
+	WhExported float64
+}
+
+// Battery are the values of the battery model 802. W is positive while the
+// battery discharges, SoC is the state of charge in percent.
+type Battery struct {
+	W           float64
+	SoC         float64
+	ChargeState int
+	WHRtg       float64
+}
+
+func int16Value(v, sf uint16) float64 {
+	if v == notImplementedInt16 || sf == notImplementedInt16 {
+		return math.NaN()
+	}
+	return float64(int16(v)) * math.Pow10(int(int16(sf)))
+}
+
+func uint16Value(v, sf uint16) float64 {
+	if v == notImplementedUint16 || sf == notImplementedInt16 {
+		return math.NaN()
+	}
+	return float64(v) * math.Pow10(int(int16(sf)))
+}
+
+func acc32Value(hi, lo, sf uint16) float64 {
+	if sf == notImplementedInt16 {
+		return math.NaN()
+	}
+	return float64(uint32(hi)<<16|uint32(lo)) * math.Pow10(int(int16(sf)))
+}
+
+func stringValue(regs []uint16) string {
+	b := make([]byte, 0, 2*len(regs))
+	for _, r := range regs {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	for len(b) > 0 && (b[len(b)-1] == 0 || b[len(b)-1] == ' ') {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+// The served points have fixed scale factors, so a client which reads a scale
+// factor apart from its values gets them right. Values out of the range of a
+// register are clamped.
+const (
+	sfA     = -2
+	sfW     = 0
+	sfWHRtg = 1
+)
+
+func putInt16(regs []uint16, v float64, sf int) {
+	if math.IsNaN(v) {
+		regs[0] = notImplementedInt16
+		return
+	}
+	// -32768 is not implemented
+	v = math.Max(-math.MaxInt16, math.Min(math.MaxInt16, math.Round(v*math.Pow10(-sf))))
+	regs[0] = uint16(int16(v))
+}
+
+func putUint16(regs []uint16, v float64, sf int) {
+	if math.IsNaN(v) || v < 0 {
+		regs[0] = notImplementedUint16
+		return
+	}
+	// 65535 is not implemented
+	v = math.Min(math.MaxUint16-1, math.Round(v*math.Pow10(-sf)))
+	regs[0] = uint16(v)
+}
+
+func putAcc32(regs []uint16, v float64) {
+	if math.IsNaN(v) || v < 0 {
+		v = notImplementedAcc32
+	}
+	a := uint32(math.Round(v))
+	regs[0], regs[1] = uint16(a>>16), uint16(a)
+}
+
+func putSF(regs []uint16, sf int) {
+	regs[0] = uint16(int16(sf))
+}
+
+func putString(regs []uint16, s string) {
+	for i := range regs {
+		var hi, lo byte
+		if 2*i < len(s) {
+			hi = s[2*i]
+		}
+		if 2*i+1 < len(s) {
+			lo = s[2*i+1]
+		}
+		regs[i] = uint16(hi)<<8 | uint16(lo)
+	}
+}
+
+func decodeCommon(regs []uint16) Common {
+	return Common{
+		Manufacturer: stringValue(regs[commonMn : commonMn+16]),
+		Model:        stringValue(regs[commonMd : commonMd+16]),
+		Version:      stringValue(regs[commonVr : commonVr+8]),
+		Serial:       stringValue(regs[commonSN : commonSN+16]),
+	}
+}
+
+func encodeCommon(c Common, unit byte) []uint16 {
+	res := make([]uint16, modelLength[ModelCommon])
+	putString(res[commonMn:commonMn+16], c.Manufacturer)
+	putString(res[commonMd:commonMd+16], c.Model)
+	putString(res[commonVr:commonVr+8], c.Version)
+	putString(res[commonSN:commonSN+16], c.Serial)
+	res[commonDA] = uint16(unit)
+	return res
+}
+
+func decodeInverter(regs []uint16) Inverter {
+	return Inverter{
+		W:     int16Value(regs[inverterW], regs[inverterWSF]),
+		DCW:   int16Value(regs[inverterDCW], regs[inverterDCWSF]),
+		WH:    acc32Value(regs[inverterWH], regs[inverterWH+1], regs[inverterWHSF]),
+		State: int(regs[inverterSt]),
+	}
+}
+
+// encodeInverter returns the data of an inverter model; the current and
+// voltage are derived from the power.
+func encodeInverter(inv Inverter, model int) []uint16 {
+	res := notImplemented(model)
+	phases := 1
+	if model == ModelInverterThreePhase {
+		phases = 3
+	}
+	const volt = 230.0
+	amps := inv.W / volt
+	putUint16(res[inverterA:], math.Abs(amps), sfA)
+	for i := 0; i < phases; i++ {
+		putUint16(res[inverterA+1+i:], math.Abs(amps)/float64(phases), sfA)
+	}
+	putSF(res[inverterASF:], sfA)
+	for i := 0; i < phases; i++ {
+		putUint16(res[inverterPhV+i:], volt, 0)
+	}
+	putSF(res[inverterVSF:], 0)
+	putInt16(res[inverterW:], inv.W, sfW)
+	putSF(res[inverterWSF:], sfW)
+	putUint16(res[inverterHz:], 50, -2)
+	putSF(res[inverterHzSF:], -2)
+	putAcc32(res[inverterWH:], inv.WH)
+	putSF(res[inverterWHSF:], 0)
+	putInt16(res[inverterDCW:], inv.DCW, sfW)
+	putSF(res[inverterDCWSF:], sfW)
+	res[inverterSt] = uint16(inv.State)
+	return res
+}
+
+func decodeMeter(regs []uint16) Meter {
+	return Meter{
+		W:          int16Value(regs[meterW], regs[meterWSF]),
+		WhImported: acc32Value(regs[meterWhImp], regs[meterWhImp+1], regs[meterTotWSF]),
+		WhExported: acc32Value(regs[meterWhExp], regs[meterWhExp+1], regs[meterTotWSF]),
+	}
+}
+
+// encodeMeter returns the data of a meter model, the power is split evenly
+// across the phases.
+func encodeMeter(m Meter, model int) []uint16 {
+	res := notImplemented(model)
+	phases := 1
+	switch model {
+	case ModelMeterSplitPhase:
+		phases = 2
+	case ModelMeterWye, ModelMeterDelta:
+		phases = 3
+	}
+	const volt = 230.0
+	amps := m.W / volt
+	putInt16(res[meterA:], amps, sfA)
+	for i := 0; i < phases; i++ {
+		putInt16(res[meterAph+i:], amps/float64(phases), sfA)
+	}
+	putSF(res[meterASF:], sfA)
+	putInt16(res[meterPhV:], volt, 0)
+	for i := 0; i < phases; i++ {
+		putInt16(res[meterPhVph+i:], volt, 0)
+	}
+	putSF(res[meterVSF:], 0)
+	putInt16(res[meterHz:], 50, -2)
+	putSF(res[meterHzSF:], -2)
+	putInt16(res[meterW:], m.W, sfW)
+	for i := 0; i < phases; i++ {
+		putInt16(res[meterWph+i:], m.W/float64(phases), sfW)
+	}
+	putSF(res[meterWSF:], sfW)
+	putAcc32(res[meterWhExp:], m.WhExported)
+	putAcc32(res[meterWhImp:], m.WhImported)
+	putSF(res[meterTotWSF:], 0)
+	return res
+}
+
+func decodeBattery(regs []uint16) Battery {
+	return Battery{
+		W:           int16Value(regs[batteryW], regs[batteryWSF]),
+		SoC:         uint16Value(regs[batterySoC], regs[batterySoCSF]),
+		ChargeState: int(regs[batteryChaSt]),
+		WHRtg:       uint16Value(regs[batteryWHRtg], regs[batteryWHRtgSF]),
+	}
+}
+
+func encodeBattery(b Battery) []uint16 {
+	res := notImplemented(ModelBattery)
+	putUint16(res[batteryWHRtg:], b.WHRtg, sfWHRtg)
+	putSF(res[batteryWHRtgSF:], sfWHRtg)
+	putUint16(res[batterySoC:], b.SoC, -1)
+	putSF(res[batterySoCSF:], -1)
+	res[batteryChaSt] = uint16(b.ChargeState)
+	putInt16(res[batteryW:], b.W, sfW)
+	putSF(res[batteryWSF:], sfW)
+	return res
+}
+
+// notImplemented returns the data of the model with all points set to not
+// implemented. Most points of the models are signed, so the int16 value is
+// used for all of them.
+func notImplemented(model int) []uint16 {
+	res := make([]uint16, modelLength[model])
+	for i := range res {
+		res[i] = notImplementedInt16
+	}
+	return res
+}
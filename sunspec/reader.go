@@ -0,0 +1,274 @@
+package sunspec
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"gitlab.com/ulrichSchreiner/solaredge"
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+)
+
+// the base addresses which are searched for the SunSpec marker
+var baseAddresses = []uint16{BaseAddress, 0, 50000}
+
+// maxModels limits the walk through the models of a device
+const maxModels = 64
+
+// Reading are the values of the models of a device. The meter and the battery
+// are nil if the device has no such model.
+type Reading struct {
+	Inverter Inverter
+	Meter    *Meter
+	Battery  *Battery
+}
+
+// Reader reads the models of a SunSpec device. The models are discovered on the
+// first read and again after a failed read.
+type Reader struct {
+	lock     sync.Mutex
+	device   modbus.Handler
+	unit     byte
+	invert   bool
+	models   map[int]uint16
+	inverter int
+	meter    int
+	common   Common
+}
+
+// ReaderOpt is an option type for the Reader.
+type ReaderOpt func(r *Reader)
+
+// WithUnit reads the device with the unit id, the default is 1.
+func WithUnit(unit byte) ReaderOpt {
+	return func(r *Reader) {
+		r.unit = unit
+	}
+}
+
+// WithExportPositive is for meters which report the power fed into the grid as
+// positive value, like the meters of SolarEdge.
+func WithExportPositive() ReaderOpt {
+	return func(r *Reader) {
+		r.invert = true
+	}
+}
+
+// NewReader returns a reader for the device, which is usually a modbus.Client.
+func NewReader(device modbus.Handler, opts ...ReaderOpt) *Reader {
+	res := &Reader{device: device, unit: 1}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// Common returns the description of the device; it is empty before the first
+// successful read.
+func (r *Reader) Common() Common {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.common
+}
+
+// discover finds the addresses of the models.
+func (r *Reader) discover() error {
+	base, err := r.findBase()
+	if err != nil {
+		return err
+	}
+	models := make(map[int]uint16)
+	addr := base + 2
+	for i := 0; i < maxModels; i++ {
+		hdr, err := r.device.ReadHoldingRegisters(r.unit, addr, 2)
+		if err != nil {
+			return fmt.Errorf("cannot read model header at %d: %w", addr, err)
+		}
+		id, l := int(hdr[0]), hdr[1]
+		if id == EndModel {
+			break
+		}
+		// the first model of a kind wins, further meters are usually
+		// production meters
+		if _, ok := models[id]; !ok {
+			models[id] = addr + 2
+		}
+		addr += 2 + l
+	}
+	inverter, meter := 0, 0
+	for id := range models {
+		switch {
+		case id >= ModelInverterSinglePhase && id <= ModelInverterThreePhase:
+			inverter = id
+		case id >= ModelMeterSinglePhase && id <= ModelMeterDelta:
+			if meter == 0 || id < meter {
+				meter = id
+			}
+		}
+	}
+	if inverter == 0 {
+		return fmt.Errorf("cannot find an inverter model of unit %d", r.unit)
+	}
+	r.models, r.inverter, r.meter = models, inverter, meter
+	if a, ok := models[ModelCommon]; ok {
+		regs, err := r.read(a, modelLength[ModelCommon])
+		if err != nil {
+			return err
+		}
+		r.common = decodeCommon(regs)
+	}
+	return nil
+}
+
+func (r *Reader) findBase() (uint16, error) {
+	var err error
+	for _, base := range baseAddresses {
+		var regs []uint16
+		regs, err = r.device.ReadHoldingRegisters(r.unit, base, 2)
+		if err == nil && regs[0] == marker0 && regs[1] == marker1 {
+			return base, nil
+		}
+		// a device which cannot be reached will not answer at the other bases
+		if err != nil && !modbus.IsException(err, modbus.IllegalDataAddress) {
+			return 0, fmt.Errorf("cannot read SunSpec marker: %w", err)
+		}
+	}
+	return 0, fmt.Errorf("cannot find SunSpec marker of unit %d", r.unit)
+}
+
+// read reads count registers in blocks of the maximum size.
+func (r *Reader) read(addr uint16, count int) ([]uint16, error) {
+	res := make([]uint16, 0, count)
+	for len(res) < count {
+		n := count - len(res)
+		if n > modbus.MaxRegisters {
+			n = modbus.MaxRegisters
+		}
+		regs, err := r.device.ReadHoldingRegisters(r.unit, addr+uint16(len(res)), uint16(n))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read registers at %d: %w", addr+uint16(len(res)), err)
+		}
+		res = append(res, regs...)
+	}
+	return res, nil
+}
+
+func (r *Reader) readModel(id int) ([]uint16, error) {
+	return r.read(r.models[id], modelLength[id])
+}
+
+// Read reads the inverter, meter and battery models of the device.
+func (r *Reader) Read() (*Reading, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.models == nil {
+		if err := r.discover(); err != nil {
+			return nil, err
+		}
+	}
+	res, err := r.readModels()
+	if err != nil {
+		// the device could have been replaced
+		r.models = nil
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *Reader) readModels() (*Reading, error) {
+	var res Reading
+	regs, err := r.readModel(r.inverter)
+	if err != nil {
+		return nil, err
+	}
+	res.Inverter = decodeInverter(regs)
+	if r.meter != 0 {
+		regs, err := r.readModel(r.meter)
+		if err != nil {
+			return nil, err
+		}
+		m := decodeMeter(regs)
+		if r.invert {
+			m.W = -m.W
+		}
+		res.Meter = &m
+	}
+	if _, ok := r.models[ModelBattery]; ok {
+		regs, err := r.readModel(ModelBattery)
+		if err != nil {
+			return nil, err
+		}
+		b := decodeBattery(regs)
+		res.Battery = &b
+	}
+	return &res, nil
+}
+
+// PowerFlow reads the device and returns its values as powerflow, so the
+// reader is a solaredge.DataSource.
+func (r *Reader) PowerFlow() (*solaredge.PowerFlow, error) {
+	rd, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return rd.PowerFlow(), nil
+}
+
+func valueOrZero(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// PowerFlow returns the reading as powerflow in W. The battery is DC coupled,
+// so the PV power is the AC power of the inverter without the power of the
+// battery. Without a meter the grid is unknown and the load is the output of
+// the inverter.
+func (rd *Reading) PowerFlow() *solaredge.PowerFlow {
+	battery := 0.0
+	if rd.Battery != nil {
+		battery = valueOrZero(rd.Battery.W)
+	}
+	pv := math.Max(0, valueOrZero(rd.Inverter.W)-battery)
+	grid := 0.0
+	if rd.Meter != nil {
+		grid = valueOrZero(rd.Meter.W)
+	}
+	load := math.Max(0, pv+battery+grid)
+
+	res := &solaredge.PowerFlow{
+		Unit: "W",
+		Grid: solaredge.PowerFlowStatus{Status: "Active", CurrentPower: math.Abs(grid)},
+		Load: solaredge.PowerFlowStatus{Status: "Active", CurrentPower: load},
+		PV:   &solaredge.PowerFlowStatus{Status: "Active", CurrentPower: pv},
+	}
+	if pv == 0 {
+		res.PV.Status = "Idle"
+	}
+	if pv > 0 {
+		res.Connections = append(res.Connections, solaredge.PowerFlowConnection{From: "PV", To: "Load"})
+	}
+	switch {
+	case grid > 0:
+		res.Connections = append(res.Connections, solaredge.PowerFlowConnection{From: "GRID", To: "Load"})
+	case grid < 0:
+		res.Connections = append(res.Connections, solaredge.PowerFlowConnection{From: "LOAD", To: "Grid"})
+	}
+	if rd.Battery != nil {
+		st := &solaredge.StoragePowerFlowStatus{
+			PowerFlowStatus: solaredge.PowerFlowStatus{Status: "Idle", CurrentPower: math.Abs(battery)},
+			ChargeLevel:     int(math.Round(valueOrZero(rd.Battery.SoC))),
+		}
+		switch {
+		case battery > 0:
+			st.Status = "Discharging"
+			res.Connections = append(res.Connections, solaredge.PowerFlowConnection{From: "STORAGE", To: "Load"})
+		case battery < 0:
+			st.Status = "Charging"
+			res.Connections = append(res.Connections, solaredge.PowerFlowConnection{From: "PV", To: "Storage"})
+		}
+		res.Storage = st
+	}
+	return res
+}
@@ -0,0 +1,198 @@
+package sunspec
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+
+	"gitlab.com/ulrichSchreiner/solaredge/modbus"
+)
+
+var testCommon = Common{Manufacturer: "SolarEdge", Model: "SE8K", Version: "4.18", Serial: "7F123456"}
+
+// serve answers the reads of the handler with Modbus TCP on a local port and
+// returns a reader for it.
+func serve(t *testing.T, h modbus.Handler, opts ...ReaderOpt) *Reader {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := modbus.NewServer(h)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	c := modbus.NewClient(l.Addr().String())
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+	})
+	return NewReader(c, opts...)
+}
+
+func newTestDevice(t *testing.T, models ...int) *Device {
+	t.Helper()
+	dev, err := NewDevice(testCommon, 1, models...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestReader(t *testing.T) {
+	dev := newTestDevice(t, ModelInverterThreePhase, ModelMeterWye, ModelBattery)
+	// the meter is a SolarEdge meter, 1200 W are exported
+	dev.Update(
+		Inverter{W: 5000, DCW: 5000, WH: 123456, State: InverterMPPT},
+		Meter{W: 1200, WhImported: 1000, WhExported: 2000},
+		&Battery{W: -1000, SoC: 55.5, ChargeState: ChargeCharging, WHRtg: math.NaN()},
+	)
+	rd := serve(t, dev.Registers(), WithExportPositive())
+
+	r, err := rd.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := rd.Common(); c != testCommon {
+		t.Errorf("common is %+v, want %+v", c, testCommon)
+	}
+	if r.Inverter.W != 5000 || r.Inverter.DCW != 5000 || r.Inverter.WH != 123456 || r.Inverter.State != InverterMPPT {
+		t.Errorf("unexpected inverter %+v", r.Inverter)
+	}
+	if r.Meter == nil || r.Meter.W != -1200 || r.Meter.WhImported != 1000 || r.Meter.WhExported != 2000 {
+		t.Errorf("unexpected meter %+v", r.Meter)
+	}
+	if r.Battery == nil || r.Battery.W != -1000 || !near(r.Battery.SoC, 55.5) || r.Battery.ChargeState != ChargeCharging {
+		t.Errorf("unexpected battery %+v", r.Battery)
+	}
+	if !math.IsNaN(r.Battery.WHRtg) {
+		t.Errorf("rating of the battery is %v, want NaN", r.Battery.WHRtg)
+	}
+
+	pf, err := rd.PowerFlow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the battery charges with 1000 W from the PV, the export goes to the grid
+	if pf.PV.CurrentPower != 6000 || pf.Grid.CurrentPower != 1200 || pf.Load.CurrentPower != 3800 {
+		t.Errorf("unexpected powerflow pv %v, grid %v, load %v", pf.PV.CurrentPower, pf.Grid.CurrentPower, pf.Load.CurrentPower)
+	}
+	if pf.Storage == nil || pf.Storage.Status != "Charging" || pf.Storage.CurrentPower != 1000 || pf.Storage.ChargeLevel != 56 {
+		t.Errorf("unexpected storage %+v", pf.Storage)
+	}
+	exported := false
+	for _, c := range pf.Connections {
+		exported = exported || c.From == "LOAD" && c.To == "Grid"
+	}
+	if !exported {
+		t.Errorf("powerflow does not export: %+v", pf.Connections)
+	}
+
+	// a meter which reports imported power as positive
+	r, err = serve(t, dev.Registers()).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Meter.W != 1200 {
+		t.Errorf("meter without inversion is %v, want 1200", r.Meter.W)
+	}
+}
+
+func TestReaderScaleFactors(t *testing.T) {
+	dev := newTestDevice(t, ModelInverterSinglePhase, ModelMeterSinglePhase)
+	rd := serve(t, dev.Registers())
+	inv, meter := dev.models[ModelInverterSinglePhase], dev.models[ModelMeterSinglePhase]
+
+	// W = 1234 * 10^-1, DCW = 50 * 10^2
+	dev.Registers().Set(inv+inverterW, []uint16{1234, uint16(0xFFFF)})
+	dev.Registers().Set(inv+inverterDCW, []uint16{50, 2})
+	// the power of the meter is not implemented
+	dev.Registers().Set(meter+meterW, []uint16{notImplementedInt16})
+	r, err := rd.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(r.Inverter.W, 123.4) || r.Inverter.DCW != 5000 {
+		t.Errorf("unexpected inverter %+v", r.Inverter)
+	}
+	if !math.IsNaN(r.Meter.W) {
+		t.Errorf("meter is %v, want NaN", r.Meter.W)
+	}
+	if r.Battery != nil {
+		t.Errorf("unexpected battery %+v", r.Battery)
+	}
+	// an unknown grid is no flow
+	pf := r.PowerFlow()
+	if !near(pf.PV.CurrentPower, 123.4) || pf.Grid.CurrentPower != 0 || !near(pf.Load.CurrentPower, 123.4) {
+		t.Errorf("unexpected powerflow %+v", pf)
+	}
+}
+
+func TestReaderDiscovery(t *testing.T) {
+	dev := newTestDevice(t, ModelInverterThreePhase)
+	dev.SetInverter(Inverter{W: 3000})
+
+	// the same models at base address 0
+	size := 4 + 2 + modelLength[ModelCommon] + 2 + modelLength[ModelInverterThreePhase]
+	regs, err := dev.Registers().ReadHoldingRegisters(1, BaseAddress, uint16(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := modbus.NewRegisters(0, size)
+	moved.Set(0, regs)
+	r, err := serve(t, moved).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Inverter.W != 3000 || r.Meter != nil || r.Battery != nil {
+		t.Errorf("unexpected reading %+v", r)
+	}
+
+	// a device without an inverter
+	if _, err := serve(t, newTestDevice(t, ModelMeterWye).Registers()).Read(); err == nil {
+		t.Error("device without inverter is read")
+	}
+	// a device without the marker
+	if _, err := serve(t, modbus.NewRegisters(BaseAddress, 10)).Read(); err == nil {
+		t.Error("device without marker is read")
+	}
+}
+
+func TestDeviceScaleFactors(t *testing.T) {
+	dev := newTestDevice(t, ModelInverterThreePhase, ModelMeterWye, ModelBattery)
+	inv, meter, battery := dev.models[ModelInverterThreePhase], dev.models[ModelMeterWye], dev.models[ModelBattery]
+	sfs := func() []uint16 {
+		var res []uint16
+		for _, a := range []uint16{inv + inverterASF, inv + inverterWSF, inv + inverterDCWSF, meter + meterASF, meter + meterWSF, battery + batteryWHRtgSF, battery + batteryWSF} {
+			regs, err := dev.Registers().ReadHoldingRegisters(1, a, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, regs[0])
+		}
+		return res
+	}
+
+	dev.Update(Inverter{W: 12.3}, Meter{W: -4.5}, &Battery{W: 1, WHRtg: 9800})
+	small := sfs()
+	// the powers do not fit into the registers
+	dev.Update(Inverter{W: 40000, DCW: -40000}, Meter{W: -50000}, &Battery{W: 33000, WHRtg: 1e6})
+	if large := sfs(); fmt.Sprint(small) != fmt.Sprint(large) {
+		t.Errorf("scale factors changed from %v to %v", small, large)
+	}
+	r, err := serve(t, dev.Registers()).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Inverter.W != math.MaxInt16 || r.Inverter.DCW != -math.MaxInt16 || r.Meter.W != -math.MaxInt16 || r.Battery.W != math.MaxInt16 {
+		t.Errorf("powers are not clamped: %+v %+v %+v", r.Inverter, *r.Meter, *r.Battery)
+	}
+	if r.Battery.WHRtg != 655340 {
+		t.Errorf("rating of the battery is %v, want 655340", r.Battery.WHRtg)
+	}
+}